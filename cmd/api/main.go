@@ -1,27 +1,84 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/joshuatakyi/shop/internal/app"
+	"github.com/joshuatakyi/shop/internal/config"
+	"github.com/joshuatakyi/shop/internal/logging"
 	"github.com/joshuatakyi/shop/internal/router"
-	"github.com/joshuatakyi/shop/internal/server"
+	"github.com/joshuatakyi/shop/internal/tracing"
 )
 
 func main() {
-	if err := server.InitializeConnection(); err != nil {
-		fmt.Printf("Error initializing connection: %v\n", err)
-		return
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
 	}
+	// Fail fast with every missing setting instead of erroring on first use
+	if err := cfg.Validate(); err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	logger, err := logging.New(cfg.Log, os.Stdout)
+	if err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	// Code without a request-scoped logger, like the background jobs, logs through the default
+	slog.SetDefault(logger)
+
+	// Exit only after run's deferred cleanup: flushing traces and closing MongoDB
+	if err := run(cfg, logger); err != nil {
+		logger.Error("Server stopped with an error", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Server stopped")
+}
 
-	defer server.Disconnect()
+// run starts the API and serves it until SIGINT or SIGTERM
+func run(cfg *config.Config, logger *slog.Logger) error {
+	// Set up tracing before connecting so startup queries are traced too
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, cfg.Env, os.Stdout)
+	if err != nil {
+		return fmt.Errorf("error setting up tracing: %v", err)
+	}
+	defer func() {
+		// Flush spans still buffered by the exporter
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("Error flushing traces", "error", err)
+		}
+	}()
 
-	port :=os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // Default port if not specified
+	application, err := app.New(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("error initializing application: %v", err)
 	}
-	r := router.Router()
-	if err := r.Start(":" + port); err != nil {
-		fmt.Printf("Error starting server: %v\n", err)
+	defer application.Close()
+
+	// Make sure required indexes exist before serving traffic
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelIndexes()
+	if err := application.EnsureIndexes(indexCtx); err != nil {
+		return fmt.Errorf("error ensuring indexes: %v", err)
 	}
+
+	// Run until SIGINT or SIGTERM, then drain in-flight requests before exiting.
+	// Once draining has started, a second signal exits at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	r := router.Router(application.Handler, cfg, logger)
+	return application.Serve(ctx, r)
 }
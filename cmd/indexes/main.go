@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/joshuatakyi/shop/internal/server"
+)
+
+const usage = `Usage: indexes [flags] <command>
+
+Commands:
+  ensure  create missing indexes (and rebuild changed ones with -rebuild)
+  status  list drift between the declared and the actual indexes; exits 1 if there is any
+  list    print the declared indexes
+
+Flags:
+`
+
+func main() {
+	rebuild := flag.Bool("rebuild", false, "drop and recreate declared indexes whose keys or options changed")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+	// Indexes only need MongoDB, so the other settings may be missing
+	if cfg.Mongo.URI == "" || cfg.Mongo.Database == "" {
+		fmt.Println("Invalid configuration: MONGODB_URI and MONGODB_DATABASE must be set")
+		os.Exit(1)
+	}
+
+	client, err := server.Connect(cfg.Mongo.URI)
+	if err != nil {
+		fmt.Printf("Error initializing connection: %v\n", err)
+		os.Exit(1)
+	}
+	defer server.Disconnect(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	code, err := run(ctx, models.NewMongoClient(client, cfg.Mongo), flag.Arg(0), cfg.Cart.TTL, *rebuild)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		code = 1
+	}
+	if code != 0 {
+		server.Disconnect(client)
+		os.Exit(code)
+	}
+}
+
+func run(ctx context.Context, repo *models.MongoClient, command string, cartTTL time.Duration, rebuild bool) (int, error) {
+	switch command {
+	case "ensure":
+		report, err := repo.EnsureIndexes(ctx, cartTTL, rebuild)
+		for _, name := range report.Created {
+			fmt.Printf("created  %s\n", name)
+		}
+		for _, name := range report.Updated {
+			fmt.Printf("updated  %s\n", name)
+		}
+		for _, name := range report.Dropped {
+			fmt.Printf("dropped  %s\n", name)
+		}
+		for _, drift := range report.Drift {
+			fmt.Printf("drift    %s\n", drift)
+		}
+		if err == nil && len(report.Created)+len(report.Updated)+len(report.Dropped)+len(report.Drift) == 0 {
+			fmt.Println("all indexes are up to date")
+		}
+		return 0, err
+
+	case "status":
+		drift, err := repo.CheckIndexes(ctx, cartTTL)
+		if err != nil {
+			return 1, err
+		}
+		if len(drift) == 0 {
+			fmt.Println("all indexes match their declarations")
+			return 0, nil
+		}
+		for _, d := range drift {
+			fmt.Println(d)
+		}
+		return 1, nil
+
+	case "list":
+		for _, spec := range repo.DeclaredIndexes(cartTTL) {
+			options := ""
+			if spec.Unique {
+				options += " unique"
+			}
+			if spec.PartialFilter != nil {
+				options += fmt.Sprintf(" partial=%v", spec.PartialFilter)
+			}
+			if spec.ExpireAfter > 0 {
+				options += fmt.Sprintf(" ttl=%s", spec.ExpireAfter)
+			}
+			fmt.Printf("%s.%s %v%s\n", spec.Collection, spec.Name, spec.Keys, options)
+		}
+		return 0, nil
+
+	default:
+		return 2, fmt.Errorf("unknown command %q", command)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"github.com/joshuatakyi/shop/internal/migrations"
+	"github.com/joshuatakyi/shop/internal/server"
+)
+
+const usage = `Usage: migrate [flags] <command>
+
+Commands:
+  up      apply pending migrations (all, or up to -to)
+  down    revert the most recent migrations (-steps, default 1)
+  status  list migrations and whether they are applied
+
+Flags:
+`
+
+func main() {
+	to := flag.Int("to", 0, "apply migrations up to and including this version (0 = all)")
+	steps := flag.Int("steps", 1, "number of migrations to revert with down")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+	// Migrations only talk to MongoDB, so the other settings may be missing
+	if cfg.Mongo.URI == "" || cfg.Mongo.Database == "" {
+		fmt.Println("Invalid configuration: MONGODB_URI and MONGODB_DATABASE must be set")
+		os.Exit(1)
+	}
+
+	client, err := server.Connect(cfg.Mongo.URI)
+	if err != nil {
+		fmt.Printf("Error initializing connection: %v\n", err)
+		os.Exit(1)
+	}
+	defer server.Disconnect(client)
+
+	runner, err := migrations.NewRunner(client.Database(cfg.Mongo.Database), cfg.Mongo.ResolvedCollections(), migrations.All())
+	if err != nil {
+		fmt.Printf("Error loading migrations: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if err := run(ctx, runner, flag.Arg(0), *to, *steps); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		server.Disconnect(client)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, runner *migrations.Runner, command string, to, steps int) error {
+	switch command {
+	case "up":
+		ran, err := runner.Up(ctx, to)
+		for _, migration := range ran {
+			fmt.Printf("applied %04d %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(ran) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+
+	case "down":
+		if steps < 1 {
+			return fmt.Errorf("-steps must be at least 1")
+		}
+		reverted, err := runner.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %04d %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Println("no applied migrations to revert")
+		}
+		return err
+
+	case "status":
+		statuses, err := runner.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-40s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
@@ -0,0 +1,226 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"github.com/joshuatakyi/shop/internal/database"
+	"github.com/joshuatakyi/shop/internal/jobs"
+	"github.com/joshuatakyi/shop/internal/memstore"
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/joshuatakyi/shop/internal/notifier"
+	"github.com/joshuatakyi/shop/internal/server"
+	"github.com/joshuatakyi/shop/internal/services"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// App owns the long-lived dependencies of the API and wires them into the
+// handlers and background jobs
+type App struct {
+	Config         *config.Config
+	Logger         *slog.Logger
+	Mongo          *mongo.Client       // nil in demo mode
+	MongoRepo      *models.MongoClient // nil in demo mode
+	Repo           models.ShopCalls
+	Payments       *services.PaymentService
+	Auth           *services.AuthService // nil unless native accounts are enabled
+	Handler        *database.Handler
+	AbandonedCarts *jobs.AbandonedCartWorker
+}
+
+// New connects to MongoDB and builds every component from cfg. In demo mode
+// the API runs against a seeded in-memory store and payments are optional.
+func New(cfg *config.Config, logger *slog.Logger) (*App, error) {
+	payments, err := services.NewPaymentService(cfg.Paystack)
+	if err != nil {
+		if !cfg.Demo {
+			return nil, fmt.Errorf("error configuring payments: %v", err)
+		}
+		logger.Warn("Demo mode: checkout is disabled", "error", err)
+		payments = nil
+	}
+
+	cartNotifier, err := notifier.New(cfg.Notifier)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring notifier: %v", err)
+	}
+
+	if cfg.Demo {
+		repo := memstore.NewSeeded()
+		logger.Info("Demo mode: serving a seeded in-memory store")
+		return newApp(cfg, logger, nil, repo, payments, cartNotifier)
+	}
+
+	client, err := server.Connect(cfg.Mongo.URI)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Connected to MongoDB", "database", cfg.Mongo.Database)
+
+	a, err := newApp(cfg, logger, client, models.NewMongoClient(client, cfg.Mongo), payments, cartNotifier)
+	if err != nil {
+		server.Disconnect(client)
+		return nil, err
+	}
+	return a, nil
+}
+
+func newApp(cfg *config.Config, logger *slog.Logger, client *mongo.Client, repo models.ShopCalls, payments *services.PaymentService, cartNotifier notifier.Notifier) (*App, error) {
+	abandonedCarts, err := jobs.NewAbandonedCartWorker(repo, cartNotifier, cfg.AbandonedCart, cfg.FrontendURL)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring abandoned cart worker: %v", err)
+	}
+	abandonedCarts.Logger = logger
+
+	// Password reset links go out through the same notifier as cart reminders
+	var auth *services.AuthService
+	if cfg.Auth.NativeUsers {
+		auth, err = services.NewAuthService(cfg.Auth, cfg.FrontendURL, repo, cartNotifier)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring accounts: %v", err)
+		}
+		auth.Denylist.Logger = logger
+		logger.Info("Native accounts are enabled")
+	}
+
+	handler := database.NewHandler(repo, payments)
+	handler.Auth = auth
+
+	mongoRepo, _ := repo.(*models.MongoClient)
+	return &App{
+		Config:         cfg,
+		Logger:         logger,
+		Mongo:          client,
+		MongoRepo:      mongoRepo,
+		Repo:           repo,
+		Payments:       payments,
+		Auth:           auth,
+		Handler:        handler,
+		AbandonedCarts: abandonedCarts,
+	}, nil
+}
+
+// EnsureIndexes creates the missing indexes the repository relies on and logs
+// any drift it leaves for `go run ./cmd/indexes` to resolve. The in-memory
+// store has none.
+func (a *App) EnsureIndexes(ctx context.Context) error {
+	if a.MongoRepo == nil {
+		return nil
+	}
+
+	report, err := a.MongoRepo.EnsureIndexes(ctx, a.Config.Cart.TTL, false)
+	if err != nil {
+		return err
+	}
+	for _, name := range report.Created {
+		a.Logger.Info("Created index", "index", name)
+	}
+	for _, name := range report.Updated {
+		a.Logger.Info("Updated index", "index", name)
+	}
+	for _, name := range report.Dropped {
+		a.Logger.Info("Dropped index", "index", name)
+	}
+	for _, drift := range report.Drift {
+		a.Logger.Warn("Index drift", "collection", drift.Collection, "index", drift.Name, "status", drift.Status, "detail", drift.Detail)
+	}
+	return nil
+}
+
+// Serve runs the HTTP server and the background jobs until ctx is cancelled,
+// e.g. on SIGINT or SIGTERM, then shuts down in order: readiness starts failing
+// for DrainDelay, the server stops accepting connections and gives in-flight
+// requests up to ShutdownTimeout to finish, and the background jobs are stopped and waited
+// for. The caller closes the MongoDB connection afterwards with Close.
+func (a *App) Serve(ctx context.Context, e *echo.Echo) error {
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	var jobs sync.WaitGroup
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		a.AbandonedCarts.Run(jobsCtx)
+	}()
+	if a.Auth != nil {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			a.Auth.Denylist.Run(jobsCtx)
+		}()
+	}
+
+	serverErr := make(chan error, 1)
+	a.Logger.Info("Server listening", "port", a.Config.Port, "env", a.Config.Env, "demo", a.Config.Demo)
+	go func() {
+		serverErr <- e.Start(":" + a.Config.Port)
+	}()
+
+	var startErr error
+	select {
+	case <-ctx.Done():
+		a.Logger.Info("Shutting down")
+	case err := <-serverErr:
+		// The server failed on its own, e.g. the port is taken
+		startErr = fmt.Errorf("error starting server: %v", err)
+	}
+
+	a.Handler.BeginDrain()
+
+	// Keep serving while readiness fails, so load balancers take this instance
+	// out of rotation before connections start being refused
+	if startErr == nil && a.Config.DrainDelay > 0 {
+		a.Logger.Info("Draining before closing the listener", "delay", a.Config.DrainDelay)
+		timer := time.NewTimer(a.Config.DrainDelay)
+		select {
+		case <-timer.C:
+		case err := <-serverErr:
+			startErr = fmt.Errorf("server stopped while draining: %v", err)
+		}
+		timer.Stop()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.Config.ShutdownTimeout)
+	defer cancel()
+
+	var shutdownErr error
+	if startErr == nil {
+		if err := e.Shutdown(shutdownCtx); err != nil {
+			shutdownErr = fmt.Errorf("error draining requests: %v", err)
+		}
+	}
+
+	stopJobs()
+	done := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		a.Logger.Warn("Background jobs did not stop before the shutdown timeout")
+	}
+
+	if startErr != nil {
+		return startErr
+	}
+	return shutdownErr
+}
+
+// Close releases the MongoDB connection
+func (a *App) Close() {
+	if a.Mongo == nil {
+		return
+	}
+	if err := server.Disconnect(a.Mongo); err != nil {
+		a.Logger.Error("Failed to disconnect from MongoDB", "error", err)
+		return
+	}
+	a.Logger.Info("Disconnected from MongoDB")
+}
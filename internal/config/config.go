@@ -0,0 +1,568 @@
+// Package config loads the application settings once at startup. Values come
+// from, in increasing order of precedence: built-in defaults, an optional YAML
+// file, .env files and the process environment. APP_ENV selects the profile
+// (development, test or production) which decides the defaults, the extra
+// files that are read and how strict validation is.
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Profiles selected with APP_ENV
+const (
+	EnvDevelopment = "development"
+	EnvTest        = "test"
+	EnvProduction  = "production"
+)
+
+// Config holds every setting the API, the workers and the CLIs read
+type Config struct {
+	Env         string `yaml:"-"`
+	Port        string `yaml:"port"`
+	FrontendURL string `yaml:"frontend_url"` // The Next.js app, used for CORS, session checks and links in emails
+	Demo        bool   `yaml:"demo"`         // Serve a seeded in-memory store instead of MongoDB
+
+	// Load balancers and reverse proxies, as IPs or CIDR ranges, whose
+	// X-Forwarded-For is believed. Without any, the client IP is the peer
+	// address of the connection.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // How long in-flight requests get to finish on SIGINT/SIGTERM
+	DrainDelay      time.Duration `yaml:"drain_delay"`      // How long readiness fails before the listener closes, so load balancers stop routing here
+
+	Log           LogConfig           `yaml:"log"`
+	Tracing       TracingConfig       `yaml:"tracing"`
+	Mongo         MongoConfig         `yaml:"mongo"`
+	Auth          AuthConfig          `yaml:"auth"`
+	Paystack      PaystackConfig      `yaml:"paystack"`
+	Cloudinary    CloudinaryConfig    `yaml:"cloudinary"`
+	Cart          CartConfig          `yaml:"cart"`
+	AbandonedCart AbandonedCartConfig `yaml:"abandoned_cart"`
+	Notifier      NotifierConfig      `yaml:"notifier"`
+	RateLimit     RateLimitConfig     `yaml:"rate_limit"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error
+	Format string `yaml:"format"` // json or text
+}
+
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`     // "none", "otlp" or "stdout"
+	Endpoint    string  `yaml:"endpoint"`     // OTLP/HTTP collector URL, e.g. http://localhost:4318
+	ServiceName string  `yaml:"service_name"` // Reported as service.name on every span
+	SampleRatio float64 `yaml:"sample_ratio"` // Fraction of new traces to record, from 0 to 1
+}
+
+type MongoConfig struct {
+	URI              string          `yaml:"uri"`
+	Database         string          `yaml:"database"`
+	CollectionPrefix string          `yaml:"collection_prefix"` // Prepended to every collection name, e.g. "storefront2_"
+	Collections      CollectionNames `yaml:"collections"`       // Overrides for individual collection names
+}
+
+// CollectionNames are the collections the repository and the migrations use
+type CollectionNames struct {
+	Users      string `yaml:"users"`
+	Sessions   string `yaml:"sessions"`
+	Resets     string `yaml:"password_resets"`
+	Denylist   string `yaml:"revoked_tokens"`
+	Profiles   string `yaml:"profiles"`
+	Products   string `yaml:"products"`
+	Carts      string `yaml:"carts"`
+	CartEvents string `yaml:"cart_events"`
+	Migrations string `yaml:"migrations"`
+}
+
+// DefaultCollectionNames returns the collection names used when nothing overrides them
+func DefaultCollectionNames() CollectionNames {
+	return CollectionNames{
+		Users:      "users",
+		Sessions:   "sessions",
+		Resets:     "password_resets",
+		Denylist:   "revoked_tokens",
+		Profiles:   "profiles",
+		Products:   "products",
+		Carts:      "cart",
+		CartEvents: "cart_events",
+		Migrations: "migrations",
+	}
+}
+
+// ResolvedCollections returns the final collection names: the defaults for any
+// name left empty, with CollectionPrefix applied to all of them
+func (m MongoConfig) ResolvedCollections() CollectionNames {
+	names := DefaultCollectionNames()
+	overrides := m.Collections
+	for _, pair := range []struct{ name, override *string }{
+		{&names.Users, &overrides.Users},
+		{&names.Sessions, &overrides.Sessions},
+		{&names.Resets, &overrides.Resets},
+		{&names.Denylist, &overrides.Denylist},
+		{&names.Profiles, &overrides.Profiles},
+		{&names.Products, &overrides.Products},
+		{&names.Carts, &overrides.Carts},
+		{&names.CartEvents, &overrides.CartEvents},
+		{&names.Migrations, &overrides.Migrations},
+	} {
+		if *pair.override != "" {
+			*pair.name = *pair.override
+		}
+		*pair.name = m.CollectionPrefix + *pair.name
+	}
+	return names
+}
+
+type AuthConfig struct {
+	JWTSecret       string `yaml:"jwt_secret"`
+	GuestCartSecret string `yaml:"guest_cart_secret"` // Defaults to a key derived from JWTSecret
+	CSRFSecret      string `yaml:"csrf_secret"`       // Signs CSRF tokens, defaults to a key derived from GuestCartSecret
+
+	// Tokens signed with RS256, ES256 or EdDSA by another identity provider are
+	// verified against its JWKS, fetched from a URL or read from a file
+	JWKSURL     string        `yaml:"jwks_url"`
+	JWKSFile    string        `yaml:"jwks_file"`
+	JWKSRefresh time.Duration `yaml:"jwks_refresh"` // How long fetched keys are trusted before they are fetched again
+	Issuer      string        `yaml:"issuer"`       // Required iss claim, if set
+	Audience    string        `yaml:"audience"`     // Required aud claim, if set
+
+	// Auth.js sessions verified by Next.js are cached for a short while
+	SessionCacheTTL  time.Duration `yaml:"session_cache_ttl"`
+	SessionCacheSize int           `yaml:"session_cache_size"`
+
+	// The API can own user accounts itself: registration, login and password
+	// resets, with HS256 access and refresh tokens signed with JWTSecret
+	NativeUsers      bool          `yaml:"native_users"`
+	AccessTokenTTL   time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL  time.Duration `yaml:"refresh_token_ttl"`
+	PasswordResetTTL time.Duration `yaml:"password_reset_ttl"`
+	DenylistSync     time.Duration `yaml:"denylist_sync"` // How often revoked tokens are read back from MongoDB
+}
+
+type PaystackConfig struct {
+	SecretKey string `yaml:"secret_key"`
+	PublicKey string `yaml:"public_key"`
+	BaseURL   string `yaml:"base_url"`
+}
+
+type CloudinaryConfig struct {
+	CloudName string `yaml:"cloud_name"`
+	APIKey    string `yaml:"api_key"`
+	APISecret string `yaml:"api_secret"`
+}
+
+type CartConfig struct {
+	TTL time.Duration `yaml:"ttl"` // Inactive carts are deleted after this long, 0 keeps them forever
+}
+
+type AbandonedCartConfig struct {
+	IdleAfter      time.Duration `yaml:"idle_after"`      // How long a cart must sit untouched before it counts as abandoned
+	ReminderWindow time.Duration `yaml:"reminder_window"` // Minimum time between two reminders for the same cart
+	ScanInterval   time.Duration `yaml:"scan_interval"`   // How often to scan for abandoned carts
+}
+
+type NotifierConfig struct {
+	Kind string     `yaml:"kind"` // "log" or "smtp"
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+type RateLimitConfig struct {
+	Enabled  bool                       `yaml:"enabled"`
+	Policies map[string]RateLimitPolicy `yaml:"policies"` // By name, as used by the route groups in router.go
+}
+
+// RateLimitPolicy is a token bucket: it holds Burst requests and refills at
+// Limit requests per Window
+type RateLimitPolicy struct {
+	Limit  int           `yaml:"limit"`
+	Window time.Duration `yaml:"window"`
+	Burst  int           `yaml:"burst"` // Defaults to Limit
+}
+
+// Rate limit policies the router applies
+const (
+	RateLimitAPI      = "api"      // Every API route, by client IP
+	RateLimitAuth     = "auth"     // Sign-in, registration and password resets, by client IP
+	RateLimitSearch   = "search"   // filter_products
+	RateLimitComments = "comments" // add_comment
+	RateLimitCheckout = "checkout" // checkout and payment verification
+)
+
+// DefaultRateLimitPolicies returns the policies used when the config file
+// does not set them
+func DefaultRateLimitPolicies() map[string]RateLimitPolicy {
+	return map[string]RateLimitPolicy{
+		RateLimitAPI:      {Limit: 300, Window: time.Minute, Burst: 100},
+		RateLimitAuth:     {Limit: 10, Window: time.Minute, Burst: 10},
+		RateLimitSearch:   {Limit: 60, Window: time.Minute, Burst: 20},
+		RateLimitComments: {Limit: 10, Window: time.Minute, Burst: 5},
+		RateLimitCheckout: {Limit: 20, Window: time.Minute, Burst: 10},
+	}
+}
+
+// defaults returns the settings used when nothing else sets them
+func defaults(env string) *Config {
+	cfg := &Config{
+		Env:             env,
+		Port:            "8080",
+		ShutdownTimeout: 20 * time.Second,
+		Log:             LogConfig{Level: "info", Format: "json"},
+		Tracing:         TracingConfig{Exporter: "none", ServiceName: "shop-api", SampleRatio: 1},
+		Mongo:           MongoConfig{Database: "shop"},
+		Auth: AuthConfig{
+			JWKSRefresh:      time.Hour,
+			SessionCacheTTL:  30 * time.Second,
+			SessionCacheSize: 10000,
+			AccessTokenTTL:   15 * time.Minute,
+			RefreshTokenTTL:  30 * 24 * time.Hour,
+			PasswordResetTTL: time.Hour,
+			DenylistSync:     30 * time.Second,
+		},
+		Paystack: PaystackConfig{BaseURL: "https://api.paystack.co"},
+		Cart:     CartConfig{TTL: 30 * 24 * time.Hour},
+		AbandonedCart: AbandonedCartConfig{
+			IdleAfter:      24 * time.Hour,
+			ReminderWindow: 72 * time.Hour,
+			ScanInterval:   15 * time.Minute,
+		},
+		Notifier: NotifierConfig{Kind: "log", SMTP: SMTPConfig{Port: "587"}},
+		// Tests send bursts of requests from one address
+		RateLimit: RateLimitConfig{Enabled: env != EnvTest, Policies: DefaultRateLimitPolicies()},
+	}
+	// Production must name its frontend explicitly, and runs behind a load
+	// balancer that needs a few readiness checks to notice a drain
+	if env != EnvProduction {
+		cfg.FrontendURL = "http://localhost:3000"
+	} else {
+		cfg.DrainDelay = 5 * time.Second
+	}
+	return cfg
+}
+
+// Load reads the configuration for the profile named by APP_ENV. It only fails
+// on settings that cannot be parsed; call Validate to check required settings.
+func Load() (*Config, error) {
+	env := os.Getenv("APP_ENV")
+	if env == "" {
+		env = EnvDevelopment
+	}
+	if env != EnvDevelopment && env != EnvTest && env != EnvProduction {
+		return nil, fmt.Errorf("unknown APP_ENV %q: must be %s, %s or %s", env, EnvDevelopment, EnvTest, EnvProduction)
+	}
+
+	if err := loadDotEnv(env); err != nil {
+		return nil, err
+	}
+
+	cfg := defaults(env)
+	if err := cfg.loadYAML(); err != nil {
+		return nil, err
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadDotEnv copies the .env files into the environment without overriding
+// variables that are already set, so the most specific file wins
+func loadDotEnv(env string) error {
+	files := []string{".env." + env + ".local", ".env.local", ".env." + env, ".env"}
+	// The test profile must not pick up a developer's local overrides
+	if env == EnvTest {
+		files = []string{".env.test", ".env"}
+	}
+
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		if err := godotenv.Load(file); err != nil {
+			return fmt.Errorf("error loading %s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// loadYAML applies CONFIG_FILE, or config.yaml followed by config.<env>.yaml
+// when they exist. A CONFIG_FILE that cannot be read is an error.
+func (c *Config) loadYAML() error {
+	files := []string{"config.yaml", "config." + c.Env + ".yaml"}
+	explicit := os.Getenv("CONFIG_FILE")
+	if explicit != "" {
+		files = []string{explicit}
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if explicit == "" && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("error reading config file %s: %v", file, err)
+		}
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return fmt.Errorf("error parsing config file %s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides settings with the environment variables that are set
+func (c *Config) applyEnv() error {
+	strs := map[string]*string{
+		"PORT":                        &c.Port,
+		"NEXT_API_URL":                &c.FrontendURL,
+		"MONGODB_URI":                 &c.Mongo.URI,
+		"MONGODB_DATABASE":            &c.Mongo.Database,
+		"MONGODB_COLLECTION_PREFIX":   &c.Mongo.CollectionPrefix,
+		"JWT_SECRET":                  &c.Auth.JWTSecret,
+		"GUEST_CART_SECRET":           &c.Auth.GuestCartSecret,
+		"CSRF_SECRET":                 &c.Auth.CSRFSecret,
+		"JWT_JWKS_URL":                &c.Auth.JWKSURL,
+		"JWT_JWKS_FILE":               &c.Auth.JWKSFile,
+		"JWT_ISSUER":                  &c.Auth.Issuer,
+		"JWT_AUDIENCE":                &c.Auth.Audience,
+		"PAYSTACK_SECRET_KEY":         &c.Paystack.SecretKey,
+		"PAYSTACK_PUBLIC_KEY":         &c.Paystack.PublicKey,
+		"PAYSTACK_BASE_URL":           &c.Paystack.BaseURL,
+		"CLOUDINARY_NAME":             &c.Cloudinary.CloudName,
+		"CLOUDINARY_API_KEY":          &c.Cloudinary.APIKey,
+		"CLOUDINARY_API_SECRET":       &c.Cloudinary.APISecret,
+		"LOG_LEVEL":                   &c.Log.Level,
+		"LOG_FORMAT":                  &c.Log.Format,
+		"OTEL_TRACES_EXPORTER":        &c.Tracing.Exporter,
+		"OTEL_EXPORTER_OTLP_ENDPOINT": &c.Tracing.Endpoint,
+		"OTEL_SERVICE_NAME":           &c.Tracing.ServiceName,
+		"NOTIFIER":                    &c.Notifier.Kind,
+		"SMTP_HOST":                   &c.Notifier.SMTP.Host,
+		"SMTP_PORT":                   &c.Notifier.SMTP.Port,
+		"SMTP_USERNAME":               &c.Notifier.SMTP.Username,
+		"SMTP_PASSWORD":               &c.Notifier.SMTP.Password,
+		"SMTP_FROM":                   &c.Notifier.SMTP.From,
+	}
+	for key, target := range strs {
+		if value := os.Getenv(key); value != "" {
+			*target = value
+		}
+	}
+
+	// Better Auth deployments share their secret instead of setting JWT_SECRET
+	if c.Auth.JWTSecret == "" {
+		c.Auth.JWTSecret = os.Getenv("BETTER_AUTH_SECRET")
+	}
+
+	if value := os.Getenv("TRUSTED_PROXIES"); value != "" {
+		c.TrustedProxies = nil
+		for _, proxy := range strings.Split(value, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				c.TrustedProxies = append(c.TrustedProxies, proxy)
+			}
+		}
+	}
+
+	if value := os.Getenv("DEMO_MODE"); value != "" {
+		c.Demo = value == "true"
+	}
+	if value := os.Getenv("RATE_LIMIT_ENABLED"); value != "" {
+		c.RateLimit.Enabled = value == "true"
+	}
+	if value := os.Getenv("NATIVE_USERS"); value != "" {
+		c.Auth.NativeUsers = value == "true"
+	}
+
+	var problems []string
+
+	if value := os.Getenv("CART_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if value == "0" {
+			ttl, err = 0, nil
+		}
+		if err != nil || (ttl != 0 && ttl < time.Second) {
+			problems = append(problems, fmt.Sprintf("CART_TTL %q must be a duration of at least 1s or 0 to disable", value))
+		} else {
+			c.Cart.TTL = ttl
+		}
+	}
+
+	if value := os.Getenv("DRAIN_DELAY"); value != "" {
+		delay, err := time.ParseDuration(value)
+		if value == "0" {
+			delay, err = 0, nil
+		}
+		if err != nil || delay < 0 {
+			problems = append(problems, fmt.Sprintf("DRAIN_DELAY %q must be a duration, or 0 to close the listener at once", value))
+		} else {
+			c.DrainDelay = delay
+		}
+	}
+
+	if value := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			problems = append(problems, fmt.Sprintf("OTEL_TRACES_SAMPLER_ARG %q must be a number from 0 to 1", value))
+		} else {
+			c.Tracing.SampleRatio = ratio
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"ABANDONED_CART_IDLE_AFTER":      &c.AbandonedCart.IdleAfter,
+		"ABANDONED_CART_REMINDER_WINDOW": &c.AbandonedCart.ReminderWindow,
+		"ABANDONED_CART_SCAN_INTERVAL":   &c.AbandonedCart.ScanInterval,
+		"SHUTDOWN_TIMEOUT":               &c.ShutdownTimeout,
+		"JWT_JWKS_REFRESH":               &c.Auth.JWKSRefresh,
+		"SESSION_CACHE_TTL":              &c.Auth.SessionCacheTTL,
+		"ACCESS_TOKEN_TTL":               &c.Auth.AccessTokenTTL,
+		"REFRESH_TOKEN_TTL":              &c.Auth.RefreshTokenTTL,
+		"PASSWORD_RESET_TTL":             &c.Auth.PasswordResetTTL,
+		"TOKEN_DENYLIST_SYNC":            &c.Auth.DenylistSync,
+	}
+	for key, target := range durations {
+		if value := os.Getenv(key); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				problems = append(problems, fmt.Sprintf("%s %q must be a positive duration", key, value))
+				continue
+			}
+			*target = d
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid settings: %s", strings.Join(problems, "; "))
+	}
+
+	c.FrontendURL = strings.TrimSuffix(c.FrontendURL, "/")
+	if c.Auth.GuestCartSecret == "" {
+		c.Auth.GuestCartSecret = deriveSecret(c.Auth.JWTSecret, "guest-cart")
+	}
+	if c.Auth.CSRFSecret == "" {
+		c.Auth.CSRFSecret = deriveSecret(c.Auth.GuestCartSecret, "csrf")
+	}
+	return nil
+}
+
+// deriveSecret turns secret into a key used only for purpose, so one secret can
+// sign several kinds of token without a token of one kind passing as another.
+// An empty secret stays empty, to be reported by Validate.
+func deriveSecret(secret, purpose string) string {
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Validate checks that everything the API server needs is set and reports
+// all missing settings at once, by their environment variable names. Demo
+// mode runs without MongoDB, Paystack and Cloudinary.
+func (c *Config) Validate() error {
+	var missing []string
+	require := func(value, name string) {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	require(c.Port, "PORT")
+	require(c.FrontendURL, "NEXT_API_URL")
+	// Sessions can be verified with our own secret, an identity provider's
+	// keys or both, but guest carts are always signed with a secret
+	if c.Auth.JWKSURL == "" && c.Auth.JWKSFile == "" || c.Auth.NativeUsers {
+		// Native accounts sign their tokens with the secret too
+		require(c.Auth.JWTSecret, "JWT_SECRET")
+	} else {
+		require(c.Auth.GuestCartSecret, "GUEST_CART_SECRET")
+	}
+	if !c.Demo {
+		require(c.Mongo.URI, "MONGODB_URI")
+		require(c.Mongo.Database, "MONGODB_DATABASE")
+		require(c.Paystack.SecretKey, "PAYSTACK_SECRET_KEY")
+		require(c.Paystack.PublicKey, "PAYSTACK_PUBLIC_KEY")
+		require(c.Cloudinary.CloudName, "CLOUDINARY_NAME")
+		require(c.Cloudinary.APIKey, "CLOUDINARY_API_KEY")
+		require(c.Cloudinary.APISecret, "CLOUDINARY_API_SECRET")
+	}
+
+	switch c.Notifier.Kind {
+	case "log":
+	case "smtp":
+		require(c.Notifier.SMTP.Host, "SMTP_HOST")
+		require(c.Notifier.SMTP.From, "SMTP_FROM")
+	default:
+		return fmt.Errorf("unknown notifier %q: must be log or smtp", c.Notifier.Kind)
+	}
+
+	if c.Auth.JWKSURL != "" && c.Auth.JWKSFile != "" {
+		return fmt.Errorf("JWT_JWKS_URL and JWT_JWKS_FILE cannot both be set")
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		return fmt.Errorf("unknown trace exporter %q: must be none, otlp or stdout", c.Tracing.Exporter)
+	}
+
+	if _, err := c.ProxyRanges(); err != nil {
+		return err
+	}
+
+	for name, policy := range c.RateLimit.Policies {
+		if policy.Limit <= 0 || policy.Window <= 0 || policy.Burst < 0 {
+			return fmt.Errorf("rate limit policy %q needs a positive limit and window", name)
+		}
+	}
+
+	if c.Env == EnvProduction && c.Demo {
+		return fmt.Errorf("demo mode cannot be enabled in production")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required settings for %s: %s (set them in the environment, a .env file or the config file)", c.Env, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// ProxyRanges parses TrustedProxies. A bare IP is a range of one address.
+func (c *Config) ProxyRanges() ([]*net.IPNet, error) {
+	ranges := make([]*net.IPNet, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		if ip := net.ParseIP(proxy); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q must be an IP or a CIDR range", proxy)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// inTempDir runs the test from an empty directory so no real .env or config
+// file is picked up, and clears the variables Load reads
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, key := range []string{
+		"APP_ENV", "CONFIG_FILE", "PORT", "NEXT_API_URL", "MONGODB_URI", "MONGODB_DATABASE", "MONGODB_COLLECTION_PREFIX", "JWT_SECRET", "BETTER_AUTH_SECRET",
+		"GUEST_CART_SECRET", "JWT_JWKS_URL", "JWT_JWKS_FILE", "JWT_JWKS_REFRESH", "JWT_ISSUER", "JWT_AUDIENCE", "SESSION_CACHE_TTL", "PAYSTACK_SECRET_KEY", "PAYSTACK_PUBLIC_KEY", "PAYSTACK_BASE_URL",
+		"CLOUDINARY_NAME", "CLOUDINARY_API_KEY", "CLOUDINARY_API_SECRET", "DEMO_MODE", "CART_TTL",
+		"ABANDONED_CART_IDLE_AFTER", "ABANDONED_CART_REMINDER_WINDOW", "ABANDONED_CART_SCAN_INTERVAL",
+		"NOTIFIER", "SMTP_HOST", "SMTP_PORT", "SMTP_USERNAME", "SMTP_PASSWORD", "SMTP_FROM", "RATE_LIMIT_ENABLED", "CSRF_SECRET", "TRUSTED_PROXIES", "DRAIN_DELAY",
+	} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := inTempDir(t)
+	writeFile(t, filepath.Join(dir, "config.yaml"), "port: \"9000\"\nmongo:\n  uri: mongodb://yaml\ncart:\n  ttl: 1h\n")
+	writeFile(t, filepath.Join(dir, ".env"), "MONGODB_URI=mongodb://dotenv\nJWT_SECRET=from-dotenv\n")
+	writeFile(t, filepath.Join(dir, ".env.local"), "JWT_SECRET=from-local\n")
+	t.Setenv("PORT", "9100")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Env != EnvDevelopment {
+		t.Errorf("got env %q, want development by default", cfg.Env)
+	}
+	if cfg.Port != "9100" {
+		t.Errorf("got port %q, the environment should win over the config file", cfg.Port)
+	}
+	if cfg.Mongo.URI != "mongodb://dotenv" {
+		t.Errorf("got mongo uri %q, .env should win over the config file", cfg.Mongo.URI)
+	}
+	if cfg.Auth.JWTSecret != "from-local" || cfg.Auth.GuestCartSecret != deriveSecret("from-local", "guest-cart") {
+		t.Errorf("got secrets %+v, .env.local should win over .env and guest carts use a key derived from it", cfg.Auth)
+	}
+	if cfg.Cart.TTL != time.Hour {
+		t.Errorf("got cart ttl %v, want 1h from the config file", cfg.Cart.TTL)
+	}
+	if cfg.FrontendURL != "http://localhost:3000" {
+		t.Errorf("got frontend url %q, want the development default", cfg.FrontendURL)
+	}
+	if cfg.DrainDelay != 0 {
+		t.Errorf("got drain delay %v, want none outside production", cfg.DrainDelay)
+	}
+}
+
+func TestLoadRejectsInvalidSettings(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("APP_ENV", "staging")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "APP_ENV") {
+		t.Fatalf("got error %v, want unknown APP_ENV", err)
+	}
+
+	t.Setenv("APP_ENV", EnvTest)
+	t.Setenv("CART_TTL", "10ms")
+	t.Setenv("ABANDONED_CART_SCAN_INTERVAL", "soon")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "1.5")
+	t.Setenv("DRAIN_DELAY", "-5s")
+	_, err := Load()
+	for _, key := range []string{"CART_TTL", "ABANDONED_CART_SCAN_INTERVAL", "OTEL_TRACES_SAMPLER_ARG", "DRAIN_DELAY"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Fatalf("got error %v, want %s reported", err, key)
+		}
+	}
+}
+
+func TestValidateListsMissingSettings(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("APP_ENV", EnvProduction)
+	t.Setenv("JWT_SECRET", "secret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted a production config without required settings")
+	}
+	for _, key := range []string{"NEXT_API_URL", "MONGODB_URI", "PAYSTACK_SECRET_KEY", "PAYSTACK_PUBLIC_KEY", "CLOUDINARY_NAME", "CLOUDINARY_API_KEY", "CLOUDINARY_API_SECRET"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error %q does not mention %s", err, key)
+		}
+	}
+	if strings.Contains(err.Error(), "JWT_SECRET") {
+		t.Errorf("error %q mentions JWT_SECRET which is set", err)
+	}
+	if cfg.DrainDelay != 5*time.Second {
+		t.Errorf("got drain delay %v, want 5s in production", cfg.DrainDelay)
+	}
+}
+
+func TestValidateDemoMode(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("DEMO_MODE", "true")
+	t.Setenv("JWT_SECRET", "secret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("demo mode should not need MongoDB, Paystack or Cloudinary: %v", err)
+	}
+	// Guest carts and CSRF tokens fall back to keys of their own derived from the JWT secret
+	secrets := map[string]bool{"secret": true}
+	for _, derived := range []string{cfg.Auth.GuestCartSecret, cfg.Auth.CSRFSecret} {
+		if derived == "" || secrets[derived] {
+			t.Errorf("got guest cart secret %q and CSRF secret %q, want distinct keys derived from JWT_SECRET", cfg.Auth.GuestCartSecret, cfg.Auth.CSRFSecret)
+			break
+		}
+		secrets[derived] = true
+	}
+
+	cfg.Env = EnvProduction
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Validate accepted demo mode in production")
+	}
+}
+
+func TestValidateJWKS(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("DEMO_MODE", "true")
+	t.Setenv("JWT_JWKS_URL", "https://idp.example.com/jwks.json")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Auth.JWKSRefresh != time.Hour {
+		t.Errorf("got jwks refresh %v, want the 1h default", cfg.Auth.JWKSRefresh)
+	}
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "GUEST_CART_SECRET") || strings.Contains(err.Error(), "JWT_SECRET") {
+		t.Fatalf("got error %v, want only GUEST_CART_SECRET missing when keys come from a JWKS", err)
+	}
+
+	cfg.Auth.GuestCartSecret = "guest-secret"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	// Native accounts sign their own tokens, so they need the secret even with a JWKS
+	cfg.Auth.NativeUsers = true
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "JWT_SECRET") {
+		t.Fatalf("got error %v, want JWT_SECRET required for native accounts", err)
+	}
+	cfg.Auth.NativeUsers = false
+
+	cfg.Auth.JWKSFile = "jwks.json"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Validate accepted both a JWKS URL and file")
+	}
+}
+
+func TestRateLimitPolicies(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("DEMO_MODE", "true")
+	t.Setenv("JWT_SECRET", "secret")
+	writeFile(t, "config.yaml", "rate_limit:\n  policies:\n    search: {limit: 5, window: 10s}\n")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !cfg.RateLimit.Enabled {
+		t.Error("rate limits are off by default in development, want them on")
+	}
+	want := RateLimitPolicy{Limit: 5, Window: 10 * time.Second}
+	if got := cfg.RateLimit.Policies[RateLimitSearch]; got != want {
+		t.Errorf("got search policy %+v, want %+v from the file", got, want)
+	}
+	if got := cfg.RateLimit.Policies[RateLimitAuth]; got != DefaultRateLimitPolicies()[RateLimitAuth] {
+		t.Errorf("got auth policy %+v, want the default kept", got)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	cfg.RateLimit.Policies[RateLimitComments] = RateLimitPolicy{Limit: 5}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "comments") {
+		t.Fatalf("got error %v, want the policy without a window rejected", err)
+	}
+
+	t.Setenv("RATE_LIMIT_ENABLED", "false")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.RateLimit.Enabled {
+		t.Fatal("RATE_LIMIT_ENABLED=false left rate limits on")
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("DEMO_MODE", "true")
+	t.Setenv("JWT_SECRET", "secret")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.7,2001:db8::/32")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	ranges, err := cfg.ProxyRanges()
+	if err != nil {
+		t.Fatalf("ProxyRanges failed: %v", err)
+	}
+	var got []string
+	for _, r := range ranges {
+		got = append(got, r.String())
+	}
+	if want := "10.0.0.0/8 192.0.2.7/32 2001:db8::/32"; strings.Join(got, " ") != want {
+		t.Errorf("got ranges %v, want %s", got, want)
+	}
+
+	cfg.TrustedProxies = append(cfg.TrustedProxies, "lb.internal")
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "lb.internal") {
+		t.Fatalf("got error %v, want the host name rejected", err)
+	}
+}
+
+func TestResolvedCollections(t *testing.T) {
+	mongo := MongoConfig{
+		CollectionPrefix: "store2_",
+		Collections:      CollectionNames{Carts: "carts"},
+	}
+	names := mongo.ResolvedCollections()
+	if names.Carts != "store2_carts" || names.Products != "store2_products" || names.Sessions != "store2_sessions" {
+		t.Fatalf("got %+v, want prefixed defaults with the cart override", names)
+	}
+	if (MongoConfig{}).ResolvedCollections() != DefaultCollectionNames() {
+		t.Fatal("an empty config should resolve to the default names")
+	}
+}
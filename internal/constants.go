@@ -1,9 +0,0 @@
-package internal
-
-var (
-	DbName                string = "shop"
-	UserCollection        string = "users"
-	UserSessionCollection string = "ssession"
-	ProductCollection     string = "products"
-	CartCollection        string = "cart"
-)
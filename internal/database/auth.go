@@ -0,0 +1,215 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/joshuatakyi/shop/internal/services"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RegisterBody struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required"`
+	Name     string `json:"name" validate:"max=100"`
+}
+
+type LoginBody struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type RefreshBody struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type ForgotPasswordBody struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordBody struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// errAccountsUnavailable is returned when native accounts are not enabled
+var errAccountsUnavailable = echo.NewHTTPError(http.StatusServiceUnavailable, "Accounts are not enabled")
+
+// bindAuthBody binds and validates the body of an account request
+func bindAuthBody(c echo.Context, body any) error {
+	if err := c.Bind(body); err != nil {
+		requestLogger(c).Warn("Failed to bind auth body", "error", err)
+		return models.Invalid("Invalid request body")
+	}
+	return models.Validate(body)
+}
+
+func clientInfo(c echo.Context) services.ClientInfo {
+	return services.ClientInfo{UserAgent: c.Request().UserAgent(), IP: c.RealIP()}
+}
+
+// currentUserID returns the signed-in user's ID. Users signed in through
+// Next.js whose IDs are not ObjectIDs have no native account.
+func currentUserID(c echo.Context) (primitive.ObjectID, error) {
+	userId, _ := c.Get("userId").(string)
+	if userId == "" {
+		return primitive.NilObjectID, echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return primitive.NilObjectID, models.NotFound("Account not found")
+	}
+	return id, nil
+}
+
+func (h *Handler) Register(c echo.Context) error {
+	if h.Auth == nil {
+		return errAccountsUnavailable
+	}
+	var body RegisterBody
+	if err := bindAuthBody(c, &body); err != nil {
+		return err
+	}
+
+	result, err := h.Auth.Register(c.Request().Context(), body.Email, body.Password, body.Name, clientInfo(c))
+	if err != nil {
+		return err
+	}
+	requestLogger(c).Info("Account registered", "userId", result.User.ID.Hex())
+	return c.JSON(http.StatusCreated, result)
+}
+
+func (h *Handler) Login(c echo.Context) error {
+	if h.Auth == nil {
+		return errAccountsUnavailable
+	}
+	var body LoginBody
+	if err := bindAuthBody(c, &body); err != nil {
+		return err
+	}
+
+	result, err := h.Auth.Login(c.Request().Context(), body.Email, body.Password, clientInfo(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) RefreshToken(c echo.Context) error {
+	if h.Auth == nil {
+		return errAccountsUnavailable
+	}
+	var body RefreshBody
+	if err := bindAuthBody(c, &body); err != nil {
+		return err
+	}
+
+	tokens, err := h.Auth.Refresh(c.Request().Context(), body.RefreshToken)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+func (h *Handler) Logout(c echo.Context) error {
+	if h.Auth == nil {
+		return errAccountsUnavailable
+	}
+	var body RefreshBody
+	if err := bindAuthBody(c, &body); err != nil {
+		return err
+	}
+
+	if err := h.Auth.Logout(c.Request().Context(), body.RefreshToken); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Logged out"})
+}
+
+// LogoutEverywhere revokes every session of the signed-in user
+func (h *Handler) LogoutEverywhere(c echo.Context) error {
+	if h.Auth == nil {
+		return errAccountsUnavailable
+	}
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := h.Auth.LogoutEverywhere(c.Request().Context(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	requestLogger(c).Info("Logged out everywhere", "sessions", revoked)
+	return c.JSON(http.StatusOK, map[string]any{"message": "Logged out of every session", "revoked": revoked})
+}
+
+func (h *Handler) ListSessions(c echo.Context) error {
+	if h.Auth == nil {
+		return errAccountsUnavailable
+	}
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := h.Auth.Sessions(c.Request().Context(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+	current, _ := c.Get("sessionId").(string)
+	return c.JSON(http.StatusOK, map[string]any{"sessions": sessions, "current": current})
+}
+
+func (h *Handler) RevokeSession(c echo.Context) error {
+	if h.Auth == nil {
+		return errAccountsUnavailable
+	}
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return invalidID("id", "session")
+	}
+
+	if err := h.Auth.RevokeSession(c.Request().Context(), userID, sessionID); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Session revoked"})
+}
+
+// ForgotPassword always answers the same way so it cannot be used to find out
+// which emails have accounts
+func (h *Handler) ForgotPassword(c echo.Context) error {
+	if h.Auth == nil {
+		return errAccountsUnavailable
+	}
+	var body ForgotPasswordBody
+	if err := bindAuthBody(c, &body); err != nil {
+		return err
+	}
+
+	if err := h.Auth.RequestPasswordReset(c.Request().Context(), body.Email); err != nil {
+		return fmt.Errorf("failed to request password reset: %w", err)
+	}
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "If an account exists for this email, a reset link has been sent"})
+}
+
+func (h *Handler) ResetPassword(c echo.Context) error {
+	if h.Auth == nil {
+		return errAccountsUnavailable
+	}
+	var body ResetPasswordBody
+	if err := bindAuthBody(c, &body); err != nil {
+		return err
+	}
+
+	if err := h.Auth.ResetPassword(c.Request().Context(), body.Token, body.Password); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password updated, please sign in again"})
+}
@@ -1,53 +1,84 @@
 package database
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/joshuatakyi/shop/internal/metrics"
 	"github.com/joshuatakyi/shop/internal/models"
-	"github.com/joshuatakyi/shop/internal/server"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func AddToCart(c echo.Context) error {
+// cartShopper identifies whose cart a request works on. Protected routes always
+// resolve to the signed-in user; guest routes fall back to the guest cart cookie.
+type cartShopper struct {
+	userID  primitive.ObjectID
+	guestID string
+}
+
+func (s cartShopper) isGuest() bool {
+	return s.guestID != ""
+}
+
+// resolveCartShopper reads the userId set by AuthMiddleware, or the guestCartId
+// set by GuestCartMiddleware when the request is anonymous
+func resolveCartShopper(c echo.Context) (cartShopper, error) {
+	if userId, ok := c.Get("userId").(string); ok && userId != "" {
+		convertedId, err := primitive.ObjectIDFromHex(userId)
+		if err != nil {
+			return cartShopper{}, fmt.Errorf("invalid userId: %v", err)
+		}
+		return cartShopper{userID: convertedId}, nil
+	}
+	if guestId, ok := c.Get("guestCartId").(string); ok && guestId != "" {
+		return cartShopper{guestID: guestId}, nil
+	}
+	return cartShopper{}, fmt.Errorf("no user or guest cart in context")
+}
+
+// MergeGuestCart is the hook used by middleware.MergeGuestCart once a shopper signs in
+func (h *Handler) MergeGuestCart(ctx context.Context, guestID, userID string) error {
+	convertedId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return fmt.Errorf("invalid userId: %v", err)
+	}
+	return h.Repo.MergeGuestCart(ctx, guestID, convertedId)
+}
+
+func (h *Handler) AddToCart(c echo.Context) error {
 	ctx := c.Request().Context()
 	var cart models.CartItem
 
-	// Retrieve userId from context
-	userId, ok := c.Get("userId").(string)
-	if !ok {
-		c.Logger().Error("Failed to retrieve userId from context")
-		return c.JSON(500, echo.Map{
-			"message": "Internal server error",
-		})
+	// Retrieve the signed-in user or guest from context
+	shopper, err := resolveCartShopper(c)
+	if err != nil {
+		requestLogger(c).Warn("Failed to resolve cart owner", "error", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
 	// Bind the request body to the cart struct
 	if err := c.Bind(&cart); err != nil {
-		c.Logger().Error("Failed to bind cart data: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid input",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Failed to bind cart data", "error", err)
+		return models.Invalid("Invalid request body")
 	}
 
-	shopRepo := models.NewMongoClient(server.Client)
-
-	// Convert userId to ObjectID
-	convertedId, err := primitive.ObjectIDFromHex(userId)
+	if shopper.isGuest() {
+		err = h.Repo.AddToGuestCart(ctx, shopper.guestID, cart)
+	} else {
+		err = h.Repo.AddToCart(ctx, shopper.userID, cart)
+	}
 	if err != nil {
-		c.Logger().Error("Failed to convert userId to ObjectID: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid userId",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to add item to cart: %w", err)
 	}
+	metrics.RecordFunnel(metrics.StageCartAdd)
 
-	err = shopRepo.AddToCart(ctx, convertedId, cart)
-	if err != nil {
-		c.Logger().Error("Failed to add item to cart: ", err)
-		return c.JSON(500, echo.Map{
-			"message": "Failed to add item to cart",
-			"error":   err.Error(),
-		})
+	// Remember where to send abandoned cart reminders for signed-in shoppers
+	if email, ok := c.Get("email").(string); ok && email != "" && !shopper.isGuest() {
+		if err := h.Repo.SetCartEmail(ctx, shopper.userID, email); err != nil {
+			requestLogger(c).Error("Failed to set cart email", "error", err)
+		}
 	}
 
 	return c.JSON(201, echo.Map{
@@ -55,19 +86,11 @@ func AddToCart(c echo.Context) error {
 	})
 }
 
-func UpdateCart(c echo.Context) error {
-	userId, ok := c.Get("userId").(string)
-	if !ok {
-		c.Logger().Error("Failed to retrieve userId from context")
-		return c.JSON(500, echo.Map{
-			"message": "Internal server error",
-		})
-	}
-	if userId == "" {
-		c.Logger().Error("userId is empty")
-		return c.JSON(400, echo.Map{
-			"message": "Unauthorized",
-		})
+func (h *Handler) UpdateCart(c echo.Context) error {
+	shopper, err := resolveCartShopper(c)
+	if err != nil {
+		requestLogger(c).Warn("Failed to resolve cart owner", "error", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
 	ctx := c.Request().Context()
@@ -81,41 +104,23 @@ func UpdateCart(c echo.Context) error {
 
 	var updateReq CartUpdateRequest
 	if err := c.Bind(&updateReq); err != nil {
-		c.Logger().Error("Failed to bind cart data: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid input",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Failed to bind cart data", "error", err)
+		return models.Invalid("Invalid request body")
 	}
 
 	// Log the request for debugging
-	c.Logger().Info("Request data: ", updateReq)
+	requestLogger(c).Debug("Updating cart", "productId", updateReq.ProductID.Hex(), "action", updateReq.Action, "quantity", updateReq.Quantity)
 
 	// Convert the product_Id to ProductID if needed
 	if updateReq.CartItem.ProductID.IsZero() && updateReq.Product_Id != "" {
 		productID, err := primitive.ObjectIDFromHex(updateReq.Product_Id)
 		if err != nil {
-			c.Logger().Error("Failed to convert product_Id to ObjectID: ", err)
-			return c.JSON(400, echo.Map{
-				"message": "Invalid product_Id",
-				"error":   err.Error(),
-			})
+			requestLogger(c).Warn("Failed to convert product_Id to ObjectID", "productId", updateReq.Product_Id, "error", err)
+			return invalidID("product_Id", "product")
 		}
 		updateReq.CartItem.ProductID = productID
 	}
 
-	shopRepo := models.NewMongoClient(server.Client)
-
-	// Convert userId to ObjectID
-	convertedId, err := primitive.ObjectIDFromHex(userId)
-	if err != nil {
-		c.Logger().Error("Failed to convert userId to ObjectID: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid userId",
-			"error":   err.Error(),
-		})
-	}
-
 	// Define action - handle both the query param and body, with body taking precedence
 	// Also handle the typo "increament" vs "increment"
 	var actions models.CartActions
@@ -134,13 +139,13 @@ func UpdateCart(c echo.Context) error {
 	}
 
 	// This call will properly recalculate the total amount as the sum of item total prices
-	err = shopRepo.UpdateCartItem(ctx, convertedId, updateReq.CartItem, actions)
+	if shopper.isGuest() {
+		err = h.Repo.UpdateGuestCartItem(ctx, shopper.guestID, updateReq.CartItem, actions)
+	} else {
+		err = h.Repo.UpdateCartItem(ctx, shopper.userID, updateReq.CartItem, actions)
+	}
 	if err != nil {
-		c.Logger().Error("Failed to update cart: ", err)
-		return c.JSON(500, echo.Map{
-			"message": "Failed to update cart",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to update cart: %w", err)
 	}
 
 	return c.JSON(200, echo.Map{
@@ -148,35 +153,23 @@ func UpdateCart(c echo.Context) error {
 	})
 }
 
-func GetUserCart(c echo.Context) error {
-	userId, ok := c.Get("userId").(string)
-	if !ok || userId == "" {
-		c.Logger().Error("Failed to retrieve userId from context")
-		return c.JSON(401, echo.Map{
-			"message": "Unauthorized",
-		})
+func (h *Handler) GetUserCart(c echo.Context) error {
+	shopper, err := resolveCartShopper(c)
+	if err != nil {
+		requestLogger(c).Warn("Failed to resolve cart owner", "error", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
 	ctx := c.Request().Context()
-	shopRepo := models.NewMongoClient(server.Client)
 
-	// Convert userId to ObjectID
-	convertedId, err := primitive.ObjectIDFromHex(userId)
-	if err != nil {
-		c.Logger().Error("Failed to convert userId to ObjectID: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid userId",
-			"error":   err.Error(),
-		})
+	var cart *models.Cart
+	if shopper.isGuest() {
+		cart, err = h.Repo.GetGuestCart(ctx, shopper.guestID)
+	} else {
+		cart, err = h.Repo.GetUserCart(ctx, shopper.userID)
 	}
-
-	cart, err := shopRepo.GetUserCart(ctx, convertedId)
 	if err != nil {
-		c.Logger().Error("Failed to get user cart: ", err)
-		return c.JSON(500, echo.Map{
-			"message": "Failed to retrieve cart",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to retrieve cart: %w", err)
 	}
 
 	// Ensure we're not returning a cart with a zero ID
@@ -188,38 +181,22 @@ func GetUserCart(c echo.Context) error {
 	return c.JSON(200, cart)
 }
 
-func ClearCart(c echo.Context) error {
-	userId, ok := c.Get("userId").(string)
-	if !ok || userId == "" {
-		c.Logger().Error("Failed to retrieve userId from context")
-		return c.JSON(401, echo.Map{
-			"message": "Unauthorized",
-		})
+func (h *Handler) ClearCart(c echo.Context) error {
+	shopper, err := resolveCartShopper(c)
+	if err != nil {
+		requestLogger(c).Warn("Failed to resolve cart owner", "error", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
 	ctx := c.Request().Context()
-	shopRepo := models.NewMongoClient(server.Client)
 
-	userObjectId, err := primitive.ObjectIDFromHex(userId)
-	if err != nil {
-		c.Logger().Error("Failed to convert userId to ObjectID: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid userId",
-		})
+	if shopper.isGuest() {
+		err = h.Repo.ClearGuestCart(ctx, shopper.guestID)
+	} else {
+		err = h.Repo.ClearCart(ctx, shopper.userID)
 	}
-
-	err = shopRepo.ClearCart(ctx, userObjectId)
 	if err != nil {
-		c.Logger().Error("Failed to clear cart: ", err)
-		if err.Error() == "no documents in result" {
-			return c.JSON(404, echo.Map{
-				"message": "Cart not found",
-			})
-		}
-		return c.JSON(500, echo.Map{
-			"message": "Failed to clear cart",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to clear cart: %w", err)
 	}
 
 	return c.JSON(200, echo.Map{
@@ -227,69 +204,60 @@ func ClearCart(c echo.Context) error {
 	})
 }
 
-func RemoveCartItem(c echo.Context) error {
+func (h *Handler) RemoveCartItem(c echo.Context) error {
 	ctx := c.Request().Context()
-	userId, ok := c.Get("userId").(string)
 	var requestBody struct {
 		Id string `json:"id"`
 	}
 
 	// Check user authentication first
-	if !ok || userId == "" {
-		c.Logger().Error("Failed to retrieve userId from context")
-		return c.JSON(401, echo.Map{
-			"message": "Unauthorized",
-		})
+	shopper, err := resolveCartShopper(c)
+	if err != nil {
+		requestLogger(c).Warn("Failed to resolve cart owner", "error", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
 	if err := c.Bind(&requestBody); err != nil {
-		c.Logger().Error("Failed to bind request body: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid input",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Failed to bind request body", "error", err)
+		return models.Invalid("Invalid request body")
 	}
 
 	if requestBody.Id == "" {
-		c.Logger().Error("Cart item ID is missing in the request body")
-		return c.JSON(400, echo.Map{
-			"message": "Cart item ID is required",
-		})
+		requestLogger(c).Warn("Cart item ID is missing in the request body")
+		return models.InvalidField("id", "required", "Cart item ID is required")
 	}
 
 	// Convert cart item ID from request
 	cartItemObjectId, err := primitive.ObjectIDFromHex(requestBody.Id)
 	if err != nil {
-		c.Logger().Error("Failed to convert cart item ID: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid cart item ID format",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Failed to convert cart item ID", "error", err)
+		return invalidID("id", "cart item")
 	}
 
-	// Convert user ID
-	userObjectId, err := primitive.ObjectIDFromHex(userId)
+	// Call the model's RemoveCartItem method
+	if shopper.isGuest() {
+		err = h.Repo.RemoveGuestCartItem(ctx, shopper.guestID, cartItemObjectId)
+	} else {
+		err = h.Repo.RemoveCartItem(ctx, shopper.userID, cartItemObjectId)
+	}
 	if err != nil {
-		c.Logger().Error("Failed to convert user ID: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid user ID format",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to remove item from cart: %w", err)
 	}
 
-	shopRepo := models.NewMongoClient(server.Client)
+	return c.JSON(200, echo.Map{
+		"message": "Item removed from cart successfully",
+	})
+}
 
-	// Call the model's RemoveCartItem method
-	err = shopRepo.RemoveCartItem(ctx, userObjectId, cartItemObjectId)
+// GetCartStats returns cart metrics for the admin dashboard
+func (h *Handler) GetCartStats(c echo.Context) error {
+	stats, err := h.Repo.GetCartStats(c.Request().Context())
 	if err != nil {
-		c.Logger().Error("Failed to remove item from cart: ", err)
-		return c.JSON(500, echo.Map{
-			"message": "Failed to remove item from cart",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to retrieve cart stats: %w", err)
 	}
 
 	return c.JSON(200, echo.Map{
-		"message": "Item removed from cart successfully",
+		"message": "Cart stats retrieved successfully",
+		"stats":   stats,
 	})
 }
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/joshuatakyi/shop/internal/metrics"
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PaymentBody struct {
+	Amount float64 `json:"amount" validate:"gt=0"`
+	Email  string  `json:"email" validate:"required,email"`
+
+	// Where to ship: a saved address, or a full one. With neither, the
+	// default saved address is used if there is one.
+	AddressID       string          `json:"address_id,omitempty"`
+	ShippingAddress *models.Address `json:"shipping_address,omitempty"`
+}
+
+// CheckoutMetadata is sent to Paystack with the transaction
+type CheckoutMetadata struct {
+	UserID          string          `json:"user_id,omitempty"`
+	ShippingAddress *models.Address `json:"shipping_address,omitempty"`
+}
+
+// shippingAddress resolves the address a checkout ships to
+func (h *Handler) shippingAddress(c echo.Context, body PaymentBody) (*models.Address, error) {
+	if body.ShippingAddress != nil {
+		if body.AddressID != "" {
+			return nil, models.InvalidField("address_id", "excluded_with", "Send address_id or shipping_address, not both")
+		}
+		address := *body.ShippingAddress
+		address.ID = primitive.NilObjectID
+		address.IsDefault = false
+		if err := models.ValidateAddress(&address); err != nil {
+			return nil, err
+		}
+		return &address, nil
+	}
+
+	userId, _ := c.Get("userId").(string)
+	userID, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		// Users without an ObjectID have no saved addresses
+		if body.AddressID != "" {
+			return nil, models.NotFound("Address not found")
+		}
+		return nil, nil
+	}
+	profile, err := h.Repo.GetProfile(c.Request().Context(), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saved addresses: %w", err)
+	}
+	if body.AddressID == "" {
+		return profile.DefaultAddress(), nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(body.AddressID)
+	if err != nil {
+		return nil, invalidID("address_id", "address")
+	}
+	address := profile.Address(id)
+	if address == nil {
+		return nil, models.NotFound("Address not found")
+	}
+	return address, nil
+}
+
+// errPaymentsUnavailable is returned when no payment provider is configured, as in demo mode
+var errPaymentsUnavailable = echo.NewHTTPError(http.StatusServiceUnavailable, "Payments are not configured")
+
+func (h *Handler) InitializeCheckout(c echo.Context) error {
+	if h.Payments == nil {
+		return errPaymentsUnavailable
+	}
+
+	var paymentBody PaymentBody
+	if err := c.Bind(&paymentBody); err != nil {
+		requestLogger(c).Warn("Failed to bind payment body", "error", err)
+		return models.Invalid("Invalid request body")
+	}
+	if err := models.Validate(paymentBody); err != nil {
+		return err
+	}
+
+	address, err := h.shippingAddress(c, paymentBody)
+	if err != nil {
+		return err
+	}
+	userId, _ := c.Get("userId").(string)
+	metadata := CheckoutMetadata{UserID: userId, ShippingAddress: address}
+
+	// Paystack expects amount in kobo (smallest currency unit)
+	// Convert the amount to kobo (multiply by 100)
+	amountInKobo := int(paymentBody.Amount * 100)
+
+	transaction, err := h.Payments.InitializeTransaction(c.Request().Context(), paymentBody.Email, amountInKobo, "", "", "", metadata)
+	if err != nil {
+		return fmt.Errorf("failed to initialize payment: %w", err)
+	}
+	metrics.RecordFunnel(metrics.StageCheckoutStarted)
+
+	return c.JSON(200, transaction)
+}
+
+func (h *Handler) VerifyTransaction(c echo.Context) error {
+	if h.Payments == nil {
+		return errPaymentsUnavailable
+	}
+
+	// Get the reference from the query parameters
+	reference := c.QueryParam("reference")
+	if reference == "" {
+		return models.InvalidField("reference", "required", "Reference is required")
+	}
+
+	paystackResponse, err := h.Payments.VerifyTransaction(c.Request().Context(), reference)
+	if err != nil {
+		return fmt.Errorf("failed to verify payment %s: %w", reference, err)
+	}
+
+	// Extract the transaction status and the user who started it from the response data
+	status, payer := "", ""
+	if data, ok := paystackResponse.Data.(map[string]interface{}); ok {
+		status, _ = data["status"].(string)
+		if metadata, ok := data["metadata"].(map[string]interface{}); ok {
+			payer, _ = metadata["user_id"].(string)
+		}
+	}
+
+	// Only the user who started a transaction may verify it
+	userId, _ := c.Get("userId").(string)
+	if payer == "" || payer != userId {
+		return models.Forbidden("Transaction does not belong to the requesting user")
+	}
+
+	// Use the extracted status to determine the transaction state
+	switch status {
+	case "success":
+		requestLogger(c).Info("Transaction successful", "reference", reference)
+		// Stop abandoned cart reminders for the cart that was just paid for. The
+		// payment is counted only when it checks the cart out, so verifying the
+		// same reference again does not count it twice.
+		if userObjectId, err := primitive.ObjectIDFromHex(userId); err == nil {
+			paid, err := h.Repo.MarkCartCheckedOut(c.Request().Context(), userObjectId, reference)
+			if err != nil {
+				requestLogger(c).Error("Failed to mark cart checked out", "error", err)
+			} else if paid {
+				metrics.RecordFunnel(metrics.StageOrderPaid)
+			}
+		}
+		return c.JSON(200, map[string]string{"status": "success", "message": "Transaction verified successfully"})
+	case "pending":
+		return c.JSON(200, map[string]string{"status": "pending", "message": "Transaction is pending"})
+	case "failed":
+		metrics.RecordFunnel(metrics.StagePaymentFailed)
+		return c.JSON(200, map[string]string{"status": "failed", "message": "Transaction failed"})
+	case "abandoned":
+		return c.JSON(200, map[string]string{"status": "abandoned", "message": "Transaction was abandoned"})
+	case "cancelled":
+		return c.JSON(200, map[string]string{"status": "cancelled", "message": "Transaction was cancelled"})
+	default:
+		return c.JSON(200, map[string]string{"status": "unknown", "message": "Transaction status is unknown"})
+	}
+}
+
+// use webhook to create order if transaction is successful
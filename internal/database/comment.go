@@ -1,60 +1,46 @@
 package database
 
 import (
+	"fmt"
+	"net/http"
+
+	"github.com/joshuatakyi/shop/internal/middleware"
 	"github.com/joshuatakyi/shop/internal/models"
-	"github.com/joshuatakyi/shop/internal/server"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func AddComment(c echo.Context) error {
+func (h *Handler) AddComment(c echo.Context) error {
 	ctx := c.Request().Context()
 	var comment models.Comments
 
 	// Retrieve productId from request parameters
 	productId := c.Param("id")
 	if productId == "" {
-		c.Logger().Error("Product ID is missing in the request")
-		return c.JSON(400, echo.Map{
-			"message": "Product ID is required",
-		})
+		requestLogger(c).Warn("Product ID is missing in the request")
+		return models.InvalidField("id", "required", "Product ID is required")
 	}
 
 	userId, ok := c.Get("userId").(string)
 	if !ok {
-		c.Logger().Error("Failed to retrieve userId from context")
-		return c.JSON(500, echo.Map{
-			"message": "Internal server error",
-		})
+		return fmt.Errorf("no userId in context")
 	}
 
 	// Bind the request body to the comment struct
 	if err := c.Bind(&comment); err != nil {
-		c.Logger().Error("Failed to bind comment data: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid input",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Failed to bind comment data", "error", err)
+		return models.Invalid("Invalid request body")
 	}
 
-	shopRepo := models.NewMongoClient(server.Client)
-
 	convertedId, err := primitive.ObjectIDFromHex(productId)
 	if err != nil {
-		c.Logger().Error("Invalid product ID format: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid product ID format",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Invalid product ID format", "productId", productId, "error", err)
+		return invalidID("id", "product")
 	}
 	// Add the comment to the product
-	err = shopRepo.AddComment(ctx, comment, userId, convertedId)
+	err = h.Repo.AddComment(ctx, comment, userId, convertedId)
 	if err != nil {
-		c.Logger().Error("Failed to add comment: ", err)
-		return c.JSON(500, echo.Map{
-			"message": "Failed to create comment",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to add comment to product %s: %w", productId, err)
 	}
 
 	return c.JSON(201, echo.Map{
@@ -63,68 +49,38 @@ func AddComment(c echo.Context) error {
 	})
 }
 
-func GetComments(c echo.Context) error {
+func (h *Handler) GetComments(c echo.Context) error {
 	ctx := c.Request().Context()
 	productId := c.Param("id")
 	if productId == "" {
-		c.Logger().Error("Product ID is missing in the request")
-		return c.JSON(400, echo.Map{
-			"message": "Product ID is required",
-		})
-	}
-
-	role, ok := c.Get("role").(string)
-	if !ok {
-		c.Logger().Error("Failed to retrieve role from context")
-		return c.JSON(500, echo.Map{
-			"message": "Internal server error",
-		})
-	}
-
-	if role != "admin" {
-		c.Logger().Error("Unauthorized access attempt")
-		return c.JSON(403, echo.Map{
-			"message": "Forbidden: You do not have permission to perform this action",
-		})
+		requestLogger(c).Warn("Product ID is missing in the request")
+		return models.InvalidField("id", "required", "Product ID is required")
 	}
 
 	convertedId, err := primitive.ObjectIDFromHex(productId)
 	if err != nil {
-		c.Logger().Error("Invalid product ID format: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid product ID format",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Invalid product ID format", "productId", productId, "error", err)
+		return invalidID("id", "product")
 	}
 
-	shopRepo := models.NewMongoClient(server.Client)
-	comments, err := shopRepo.GetComments(ctx, convertedId)
+	comments, err := h.Repo.GetCommentsByProductID(ctx, convertedId)
 	if err != nil {
-		c.Logger().Error("Failed to retrieve comments: ", err)
-		return c.JSON(500, echo.Map{
-			"message": "Failed to retrieve comments",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to retrieve comments for product %s: %w", productId, err)
 	}
 
 	return c.JSON(200, comments)
 }
 
-func DeleteComment(c echo.Context) error {
+func (h *Handler) DeleteComment(c echo.Context) error {
 	ctx := c.Request().Context()
 	userId, ok := c.Get("userId").(string)
 	if !ok {
-		c.Logger().Error("Failed to retrieve userId from context")
-		return c.JSON(500, echo.Map{
-			"message": "Internal server error",
-		})
+		return fmt.Errorf("no userId in context")
 	}
 
 	if userId == "" {
-		c.Logger().Error("User ID is missing in the request")
-		return c.JSON(400, echo.Map{
-			"message": "User ID is required",
-		})
+		requestLogger(c).Warn("User ID is missing in the request")
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
 	// Retrieve id from request body
@@ -133,61 +89,37 @@ func DeleteComment(c echo.Context) error {
 	}
 
 	if err := c.Bind(&requestBody); err != nil {
-		c.Logger().Error("Failed to bind request body: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid input",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Failed to bind request body", "error", err)
+		return models.Invalid("Invalid request body")
 	}
 
 	if requestBody.Id == "" {
-		c.Logger().Error("Comment ID is missing in the request body")
-		return c.JSON(400, echo.Map{
-			"message": "Comment ID is required",
-		})
+		requestLogger(c).Warn("Comment ID is missing in the request body")
+		return models.InvalidField("id", "required", "Comment ID is required")
 	}
 
 	convertedId, err := primitive.ObjectIDFromHex(requestBody.Id)
 	if err != nil {
-		c.Logger().Error("Invalid comment ID format: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid comment ID format",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Invalid comment ID format", "commentId", requestBody.Id, "error", err)
+		return invalidID("id", "comment")
 	}
 
-	shopRepo := models.NewMongoClient(server.Client)
-
 	// First, fetch the comment to check ownership
-	comment, err := shopRepo.GetCommentById(ctx, convertedId)
+	comment, err := h.Repo.GetCommentByID(ctx, convertedId)
 	if err != nil {
-		c.Logger().Error("Failed to retrieve comment: ", err)
-		return c.JSON(404, echo.Map{
-			"message": "Comment not found",
-		})
+		return fmt.Errorf("failed to retrieve comment %s: %w", requestBody.Id, err)
 	}
 
-	// Verify the user is the author of the comment
-	if userId != comment.UserId {
-		c.Logger().Error("Unauthorized access attempt")
-		return c.JSON(403, echo.Map{
-			"message": "Forbidden: Only the comment author can delete this comment",
-		})
+	// Only the author or a moderator may delete a comment
+	if userId != comment.UserId && !middleware.Can(c, middleware.PermCommentsModerate) {
+		requestLogger(c).Warn("Unauthorized access attempt")
+		return models.Forbidden("Only the comment author can delete this comment")
 	}
 
-	// Delete the comment
-	err = shopRepo.DeleteComment(ctx, convertedId, userId)
+	// Delete the comment, passing its author so a moderator can remove it too
+	err = h.Repo.DeleteComment(ctx, convertedId, comment.UserId)
 	if err != nil {
-		c.Logger().Error("Failed to delete comment: ", err)
-		if err.Error() == "comment not found" {
-			return c.JSON(404, echo.Map{
-				"message": "Comment not found",
-			})
-		}
-		return c.JSON(500, echo.Map{
-			"message": "Failed to delete comment",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to delete comment %s: %w", requestBody.Id, err)
 	}
 
 	return c.JSON(200, echo.Map{
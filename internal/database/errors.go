@@ -0,0 +1,141 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// Machine-readable error codes sent in the error envelope. Clients should
+// branch on these rather than on messages, which may change.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeOutOfStock         = "out_of_stock"
+	CodePaymentFailed      = "payment_failed"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeServiceUnavailable = "service_unavailable"
+	CodeInternal           = "internal_error"
+)
+
+// ErrorBody is the body of every error response:
+//
+//	{"error": {"code": "validation_failed", "message": "Validation failed",
+//	  "details": [{"field": "price", "rule": "min", "message": "..."}],
+//	  "request_id": "..."}}
+type ErrorBody struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes what went wrong with a request
+type APIError struct {
+	Code      string              `json:"code"`
+	Message   string              `json:"message"`
+	Details   []models.FieldError `json:"details,omitempty"`
+	RequestID string              `json:"request_id,omitempty"`
+}
+
+// domainErrors maps the repository's error kinds to a status and code
+var domainErrors = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{models.ErrValidation, http.StatusBadRequest, CodeValidationFailed},
+	{models.ErrNotFound, http.StatusNotFound, CodeNotFound},
+	{models.ErrConflict, http.StatusConflict, CodeConflict},
+	{models.ErrUnauthenticated, http.StatusUnauthorized, CodeUnauthorized},
+	{models.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{models.ErrOutOfStock, http.StatusConflict, CodeOutOfStock},
+	{models.ErrPaymentFailed, http.StatusPaymentRequired, CodePaymentFailed},
+}
+
+// HTTPErrorHandler writes the error envelope for errors returned by handlers
+// and middleware. Domain errors keep their message, Echo's HTTP errors keep
+// theirs, and anything else is an internal failure: the request log line keeps
+// the error and the client only gets a generic message and the request ID.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status, body := errorResponse(err)
+	if requestId, ok := c.Get("requestId").(string); ok {
+		body.Error.RequestID = requestId
+	}
+
+	var writeErr error
+	if c.Request().Method == http.MethodHead {
+		writeErr = c.NoContent(status)
+	} else {
+		writeErr = c.JSON(status, body)
+	}
+	if writeErr != nil {
+		requestLogger(c).Error("Failed to write error response", "error", writeErr)
+	}
+}
+
+func errorResponse(err error) (int, ErrorBody) {
+	var domainErr *models.Error
+	if errors.As(err, &domainErr) {
+		for _, d := range domainErrors {
+			if errors.Is(domainErr, d.kind) {
+				return d.status, ErrorBody{APIError{Code: d.code, Message: domainErr.Message, Details: domainErr.Fields}}
+			}
+		}
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		message := http.StatusText(httpErr.Code)
+		if m, ok := httpErr.Message.(string); ok && m != "" {
+			message = m
+		} else if httpErr.Message != nil {
+			message = fmt.Sprint(httpErr.Message)
+		}
+		return httpErr.Code, ErrorBody{APIError{Code: statusCode(httpErr.Code), Message: message}}
+	}
+
+	return http.StatusInternalServerError, ErrorBody{APIError{Code: CodeInternal, Message: "Internal server error"}}
+}
+
+// statusCode picks the error code for a plain HTTP status
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case http.StatusServiceUnavailable:
+		return CodeServiceUnavailable
+	case http.StatusInternalServerError:
+		return CodeInternal
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// invalidID reports a path or body field that should hold an ObjectID but does not
+func invalidID(field, what string) error {
+	return models.InvalidField(field, "objectid", fmt.Sprintf("Invalid %s ID format", what))
+}
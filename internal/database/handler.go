@@ -0,0 +1,42 @@
+package database
+
+import (
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/joshuatakyi/shop/internal/logging"
+	"github.com/joshuatakyi/shop/internal/middleware"
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/joshuatakyi/shop/internal/services"
+	"github.com/labstack/echo/v4"
+)
+
+// Handler serves the HTTP API. Its dependencies are injected by the caller
+// so the same handlers run against MongoDB in production and an in-memory
+// store in tests and demo mode.
+type Handler struct {
+	Repo     models.ShopCalls
+	Payments *services.PaymentService
+	Auth     *services.AuthService // nil unless native accounts are enabled
+
+	// RateLimits keeps the rate limit buckets; nil keeps them in memory. Share
+	// one store between instances so limits hold across all of them.
+	RateLimits middleware.RateLimitStore
+
+	draining atomic.Bool // Set by BeginDrain when the server starts shutting down
+}
+
+func NewHandler(repo models.ShopCalls, payments *services.PaymentService) *Handler {
+	return &Handler{Repo: repo, Payments: payments}
+}
+
+// requestLogger returns the request's logger (carrying request_id) with the
+// matched route and the authenticated user, so handler logs share the fields
+// of the request log line
+func requestLogger(c echo.Context) *slog.Logger {
+	logger := logging.FromContext(c.Request().Context()).With("route", c.Path())
+	if userId, ok := c.Get("userId").(string); ok && userId != "" {
+		logger = logger.With("userId", userId)
+	}
+	return logger
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// readinessTimeout bounds the store ping so a hung database fails the probe
+// instead of stalling it
+const readinessTimeout = 2 * time.Second
+
+// Liveness reports that the process is up and serving HTTP. It never touches
+// the database, so a database outage does not get the process restarted.
+func (h *Handler) Liveness(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{
+		"status":  "ok",
+		"message": "Server is running",
+	})
+}
+
+// Readiness reports whether the instance should receive traffic: the store must
+// answer a ping and the server must not be draining for shutdown
+func (h *Handler) Readiness(c echo.Context) error {
+	if h.draining.Load() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"status":  "unavailable",
+			"message": "Server is shutting down",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readinessTimeout)
+	defer cancel()
+	if err := h.Repo.Ping(ctx); err != nil {
+		requestLogger(c).Error("Readiness check failed", "error", err)
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"status":  "unavailable",
+			"message": "Database is not reachable",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"status":  "ok",
+		"message": "Server is ready",
+	})
+}
+
+// BeginDrain makes Readiness fail from now on so load balancers stop sending
+// new requests while in-flight ones finish
+func (h *Handler) BeginDrain() {
+	h.draining.Store(true)
+}
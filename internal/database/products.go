@@ -7,61 +7,31 @@ import (
 	"time"
 
 	"github.com/joshuatakyi/shop/internal/models"
-	"github.com/joshuatakyi/shop/internal/server"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func CreateProduct(c echo.Context) error {
-	// Get role from context set by AuthMiddleware
-	// The middleware.AuthMiddleware() function set this when validating the JWT token
-	role, ok := c.Get("role").(string)
-	if !ok {
-		// If role is not found in context, log the error and return 401 Unauthorized
-		c.Logger().Error("Failed to retrieve role from context - user might not be authenticated properly")
-		return c.JSON(401, echo.Map{
-			"success": false,
-			"message": "Authentication required",
-		})
-	}
-
+// CreateProduct adds a product. The /admin group only routes here for roles
+// with the products:write permission.
+func (h *Handler) CreateProduct(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	// Verify user has admin role
-	if role != "admin" {
-		c.Logger().Error("Unauthorized access attempt - user has role: " + role)
-		return c.JSON(403, echo.Map{
-			"success": false,
-			"message": "Forbidden: You do not have permission to perform this action",
-		})
-	}
-
 	// Bind the request body directly to the product struct
 	// We'll remove the double binding that was causing EOF errors
 	var product models.Product
 	if err := c.Bind(&product); err != nil {
-		c.Logger().Error("Failed to bind product data to struct: ", err)
-		return c.JSON(400, echo.Map{
-			"success": false,
-			"message": "Invalid input structure",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Failed to bind product data to struct", "error", err)
+		return models.Invalid("Invalid request body")
 	}
 
 	// Log the received product data for debugging
-	c.Logger().Debug("Received product data: ", product)
+	requestLogger(c).Debug("Received product data", "product", product)
 
 	// No need to set slug here as the AddProduct method will handle it
 
-	shopRepo := models.NewMongoClient(server.Client)
-	id, err := shopRepo.AddProduct(ctx, product)
+	id, err := h.Repo.AddProduct(ctx, product)
 	if err != nil {
-		c.Logger().Error("Failed to add product: ", err)
-		return c.JSON(500, echo.Map{
-			"success": false,
-			"message": "Failed to create product",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to add product: %w", err)
 	}
 
 	// Return response in the format expected by the frontend ApiResponse interface
@@ -75,7 +45,7 @@ func CreateProduct(c echo.Context) error {
 }
 
 // GETPRODUCTS RETRIEVES A LIST OF PRODUCTS WITH OPTIONAL FILTERING
-func ListProducts(c echo.Context) error {
+func (h *Handler) ListProducts(c echo.Context) error {
 	// calculate the time it took for the data to get fetched
 	start := time.Now()
 	ctx := c.Request().Context()
@@ -100,26 +70,11 @@ func ListProducts(c echo.Context) error {
 	}
 
 	// Get products from database
-	shopRepo := models.NewMongoClient(server.Client)
-	products, err := shopRepo.ListProducts(ctx, page, limit)
+	products, err := h.Repo.ListProducts(ctx, page, limit)
 	if err != nil {
-		// Enhanced error logging with more details to help troubleshoot the issue
-		c.Logger().Errorf("Failed to retrieve products: %v", err)
-
-		// Check if the error is related to the accessory_type decoding issue
-		if err.Error() == "failed to decode product: error decoding key accessory_type: SliceDecodeValue can only decode a string into a byte array, got string" {
-			// This is likely a schema mismatch issue in the database
-			c.Logger().Error("Schema mismatch detected with accessory_type field. Check product model definition.")
-			return c.JSON(500, echo.Map{
-				"message": "Data schema error: Issue with accessory_type field format",
-				"error":   "There is a mismatch between the stored data type and the expected type",
-			})
-		}
-
-		return c.JSON(500, echo.Map{
-			"message": "Failed to retrieve products",
-			"error":   err.Error(), // Including the error message helps with debugging
-		})
+		// Decode failures, e.g. a document not matching the Product schema, end
+		// up in the request log with the rest of the error
+		return fmt.Errorf("failed to retrieve products: %w", err)
 	}
 
 	end := time.Now()
@@ -140,35 +95,21 @@ func ListProducts(c echo.Context) error {
 	})
 }
 
-func GetProductByID(c echo.Context) error {
+func (h *Handler) GetProductByID(c echo.Context) error {
 	ctx := c.Request().Context()
 	paramsId := c.Param("id")
 	if paramsId == "" {
-		return c.JSON(400, echo.Map{
-			"message": "Product ID is required",
-		})
+		return models.InvalidField("id", "required", "Product ID is required")
 	}
-	shopRepo := models.NewMongoClient(server.Client)
 	convertedId, err := primitive.ObjectIDFromHex(paramsId)
 	if err != nil {
-		c.Logger().Error("Failed to convert product ID: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid product ID format",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Failed to convert product ID", "productId", paramsId, "error", err)
+		return invalidID("id", "product")
 	}
 
-	product, err := shopRepo.GetProductByID(ctx, convertedId)
+	product, err := h.Repo.GetProductByID(ctx, convertedId)
 	if err != nil {
-		c.Logger().Error("Failed to retrieve product: ", err)
-		if err.Error() == "product not found" {
-			return c.JSON(404, echo.Map{
-				"message": "Product not found",
-			})
-		}
-		return c.JSON(500, echo.Map{
-			"message": "Internal server error",
-		})
+		return fmt.Errorf("failed to retrieve product %s: %w", paramsId, err)
 	}
 
 	return c.JSON(200, echo.Map{
@@ -178,81 +119,44 @@ func GetProductByID(c echo.Context) error {
 	})
 }
 
-func GetProductBySlug(c echo.Context) error {
+func (h *Handler) GetProductBySlug(c echo.Context) error {
 	ctx := c.Request().Context()
 	paramsSlug := c.Param("slug")
 
 	if paramsSlug == "" {
-		c.JSON(400, echo.Map{
-			"message": "Product slug is required",
-		})
-		return nil
+		return models.InvalidField("slug", "required", "Product slug is required")
 	}
 
-	shopRepo := models.NewMongoClient(server.Client)
-	product, err := shopRepo.GetProductBySlug(ctx, paramsSlug)
+	product, err := h.Repo.GetProductBySlug(ctx, paramsSlug)
 	if err != nil {
-		c.Logger().Error("Failed to retrieve product: ", err)
-		if err.Error() == "product not found" {
-			return c.JSON(404, echo.Map{
-				"message": " slug not found",
-				"slug":    paramsSlug,
-			})
-		}
-		return c.JSON(500, echo.Map{
-			"message": "Internal server error",
-		})
+		return fmt.Errorf("failed to retrieve product %q: %w", paramsSlug, err)
 	}
 
 	return c.JSON(200, product)
 }
 
-func UpdateProduct(c echo.Context) error {
+func (h *Handler) UpdateProduct(c echo.Context) error {
 	ctx := c.Request().Context()
 	paramsId := c.Param("id")
 	if paramsId == "" {
-		c.JSON(400, echo.Map{
-			"message": "Product ID is required",
-		})
+		return models.InvalidField("id", "required", "Product ID is required")
 	}
 
 	var product map[string]interface{}
 
-	// get role from cookies
-	role := c.Get("role").(string)
-
-	// Check if the user is authorized to update the product
-	if role != "admin" {
-		c.Logger().Error("Unauthorized access attempt")
-		return c.JSON(403, echo.Map{
-			"message": "Forbidden: You do not have permission to perform this action",
-		})
-	}
-
 	// Bind the request body to the product struct
 	if err := c.Bind(&product); err != nil {
-		c.Logger().Error("Failed to bind product data: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid input",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Failed to bind product data", "error", err)
+		return models.Invalid("Invalid request body")
 	}
 	convertedId, err := primitive.ObjectIDFromHex(paramsId)
 	if err != nil {
-		c.Logger().Error("Failed to convert product ID: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid product ID format",
-			"error":   err.Error(),
-		})
-	}
-	shopRepo := models.NewMongoClient(server.Client)
-	id, err := shopRepo.UpdateProduct(ctx, convertedId, product)
+		requestLogger(c).Warn("Failed to convert product ID", "productId", paramsId, "error", err)
+		return invalidID("id", "product")
+	}
+	id, err := h.Repo.UpdateProduct(ctx, convertedId, product)
 	if err != nil {
-		c.Logger().Error("Failed to update product: ", err)
-		return c.JSON(500, echo.Map{
-			"message": "Failed to update product",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to update product %s: %w", paramsId, err)
 	}
 
 	return c.JSON(200, echo.Map{
@@ -262,66 +166,32 @@ func UpdateProduct(c echo.Context) error {
 }
 
 // DELETEPRODUCT HANDLES THE DELETION OF A PRODUCT
-func DeleteProduct(c echo.Context) error {
+func (h *Handler) DeleteProduct(c echo.Context) error {
 	var requestBody struct {
 		ID string `json:"id"`
 	}
 
 	if err := c.Bind(&requestBody); err != nil {
-		c.Logger().Error("Failed to bind request body: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid request body",
-			"error":   err.Error(),
-		})
-	}
-	// Retrieve role from context
-	role, ok := c.Get("role").(string)
-	if !ok {
-		c.Logger().Error("Failed to retrieve role from context")
-		return c.JSON(500, echo.Map{
-			"message": "Internal server error",
-		})
-	}
-
-	// Check if the user is authorized to delete the product
-	if role != "admin" {
-		c.Logger().Error("Unauthorized access attempt")
-		return c.JSON(403, echo.Map{
-			"message": "Forbidden: You do not have permission to perform this action",
-		})
+		requestLogger(c).Warn("Failed to bind request body", "error", err)
+		return models.Invalid("Invalid request body")
 	}
-
 	// Validate that the product ID is provided
 	if requestBody.ID == "" {
-		return c.JSON(400, echo.Map{
-			"message": "Product ID is required",
-		})
+		return models.InvalidField("id", "required", "Product ID is required")
 	}
 
 	// Convert the product ID to ObjectID and proceed with deletion
 	ctx := c.Request().Context()
 	convertedId, err := primitive.ObjectIDFromHex(requestBody.ID)
 	if err != nil {
-		c.Logger().Error("Failed to convert product ID: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid product ID format",
-			"error":   err.Error(),
-		})
+		requestLogger(c).Warn("Failed to convert product ID", "productId", requestBody.ID, "error", err)
+		return invalidID("id", "product")
 	}
 
 	// Delete the product from the database
-	shopRepo := models.NewMongoClient(server.Client)
-	err = shopRepo.DeleteProduct(ctx, convertedId)
+	err = h.Repo.DeleteProduct(ctx, convertedId)
 	if err != nil {
-		c.Logger().Error("Failed to delete product: ", err)
-		if err.Error() == "product not found" {
-			return c.JSON(404, echo.Map{
-				"message": "Product not found",
-			})
-		}
-		return c.JSON(500, echo.Map{
-			"message": "Failed to delete product",
-		})
+		return fmt.Errorf("failed to delete product %s: %w", requestBody.ID, err)
 	}
 
 	return c.JSON(200, echo.Map{
@@ -330,7 +200,7 @@ func DeleteProduct(c echo.Context) error {
 }
 
 // FilterProducts handles complex product filtering with multiple criteria
-func FilterProducts(c echo.Context) error {
+func (h *Handler) FilterProducts(c echo.Context) error {
 	ctx := c.Request().Context()
 
 	// Initialize default pagination parameters
@@ -464,18 +334,12 @@ func FilterProducts(c echo.Context) error {
 	}
 
 	// Log the filter parameters for debugging
-	c.Logger().Debugf("Filter parameters: %+v", filterParams)
+	requestLogger(c).Debug("Filtering products", "filter", filterParams, "page", page, "limit", limit)
 
 	// Fetch filtered products
-	shopRepo := models.NewMongoClient(server.Client)
-	products, totalCount, err := shopRepo.FilterProducts(ctx, filterParams, page, limit)
+	products, totalCount, err := h.Repo.FilterProducts(ctx, filterParams, page, limit)
 	if err != nil {
-		c.Logger().Errorf("Failed to filter products: %v", err)
-		return c.JSON(500, echo.Map{
-			"success": false,
-			"message": "Failed to retrieve products",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to filter products: %w", err)
 	}
 
 	// Calculate total pages for pagination info
@@ -510,7 +374,7 @@ func FilterProducts(c echo.Context) error {
 	})
 }
 
-func GetSimilarProducts(c echo.Context) error {
+func (h *Handler) GetSimilarProducts(c echo.Context) error {
 	ctx := c.Request().Context()
 	type requestBody struct {
 		Id string `json:"id"` // Changed to string to properly bind from JSON
@@ -518,40 +382,23 @@ func GetSimilarProducts(c echo.Context) error {
 
 	var reqBody requestBody
 	if err := c.Bind(&reqBody); err != nil {
-		return c.JSON(400, echo.Map{
-			"message": "Invalid request body",
-		})
+		return models.Invalid("Invalid request body")
 	}
 
 	// Make sure we have an ID
 	if reqBody.Id == "" {
-		return c.JSON(400, echo.Map{
-			"message": "Product ID is required",
-		})
+		return models.InvalidField("id", "required", "Product ID is required")
 	}
 
 	// Convert the string ID to ObjectID
 	convertedId, err := primitive.ObjectIDFromHex(reqBody.Id)
 	if err != nil {
-		c.Logger().Error("Failed to convert product ID: ", err)
-		return c.JSON(400, echo.Map{
-			"message": "Invalid product ID format",
-			"error":   err.Error(),
-		})
-	}
-	shopRepo := models.NewMongoClient(server.Client)
-	similarProducts, err := shopRepo.GetSimilarProducts(ctx, convertedId)
+		requestLogger(c).Warn("Failed to convert product ID", "productId", reqBody.Id, "error", err)
+		return invalidID("id", "product")
+	}
+	similarProducts, err := h.Repo.GetSimilarProducts(ctx, convertedId)
 	if err != nil {
-		c.Logger().Error("Failed to retrieve similar products: ", err)
-		if err.Error() == "product not found" {
-			return c.JSON(404, echo.Map{
-				"message": "Product not found",
-			})
-		}
-		return c.JSON(500, echo.Map{
-			"message": "Internal server error",
-			"error":   err.Error(),
-		})
+		return fmt.Errorf("failed to retrieve similar products for %s: %w", reqBody.Id, err)
 	}
 
 	return c.JSON(200, echo.Map{
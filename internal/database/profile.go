@@ -0,0 +1,171 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ProfileBody struct {
+	Name  string `json:"name" validate:"max=100"`
+	Phone string `json:"phone" validate:"omitempty,e164"` // e.g. +233241234567
+}
+
+// addressBook is what the address routes return when the default may have moved
+func addressBook(profile *models.UserProfile) echo.Map {
+	return echo.Map{
+		"addresses":          profile.Addresses,
+		"default_address_id": profile.DefaultAddressID,
+	}
+}
+
+// bindAddress reads and validates an address from the request body
+func bindAddress(c echo.Context) (models.Address, error) {
+	var address models.Address
+	if err := c.Bind(&address); err != nil {
+		requestLogger(c).Warn("Failed to bind address", "error", err)
+		return address, models.Invalid("Invalid request body")
+	}
+	if err := models.ValidateAddress(&address); err != nil {
+		return address, err
+	}
+	return address, nil
+}
+
+// addressID reads the :id path parameter
+func addressID(c echo.Context) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return primitive.NilObjectID, invalidID("id", "address")
+	}
+	return id, nil
+}
+
+func (h *Handler) GetProfile(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+	profile, err := h.Repo.GetProfile(c.Request().Context(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to get profile: %w", err)
+	}
+	return c.JSON(200, profile)
+}
+
+func (h *Handler) UpdateProfile(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+	var body ProfileBody
+	if err := c.Bind(&body); err != nil {
+		requestLogger(c).Warn("Failed to bind profile", "error", err)
+		return models.Invalid("Invalid request body")
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	body.Phone = strings.TrimSpace(body.Phone)
+	if err := models.Validate(body); err != nil {
+		return err
+	}
+
+	profile, err := h.Repo.UpdateProfile(c.Request().Context(), userID, body.Name, body.Phone)
+	if err != nil {
+		return fmt.Errorf("failed to update profile: %w", err)
+	}
+	return c.JSON(200, profile)
+}
+
+func (h *Handler) ListAddresses(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+	profile, err := h.Repo.GetProfile(c.Request().Context(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to get addresses: %w", err)
+	}
+	return c.JSON(200, addressBook(profile))
+}
+
+// AddAddress saves a new address. The first one saved, or one sent with
+// is_default, becomes the default.
+func (h *Handler) AddAddress(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+	address, err := bindAddress(c)
+	if err != nil {
+		return err
+	}
+	address.ID = primitive.NewObjectID()
+
+	profile, err := h.Repo.AddAddress(c.Request().Context(), userID, address)
+	if err != nil {
+		return fmt.Errorf("failed to add address: %w", err)
+	}
+	return c.JSON(201, profile.Address(address.ID))
+}
+
+// UpdateAddress replaces a saved address. is_default can make it the default,
+// but not stop it being one: another address has to be made the default
+// instead, so there is always exactly one.
+func (h *Handler) UpdateAddress(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+	id, err := addressID(c)
+	if err != nil {
+		return err
+	}
+	address, err := bindAddress(c)
+	if err != nil {
+		return err
+	}
+	address.ID = id
+
+	profile, err := h.Repo.UpdateAddress(c.Request().Context(), userID, address)
+	if err != nil {
+		return fmt.Errorf("failed to update address: %w", err)
+	}
+	return c.JSON(200, profile.Address(id))
+}
+
+func (h *Handler) SetDefaultAddress(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+	id, err := addressID(c)
+	if err != nil {
+		return err
+	}
+	profile, err := h.Repo.SetDefaultAddress(c.Request().Context(), userID, id)
+	if err != nil {
+		return fmt.Errorf("failed to set default address: %w", err)
+	}
+	return c.JSON(200, addressBook(profile))
+}
+
+// DeleteAddress removes a saved address. Removing the default makes the oldest
+// remaining address the default.
+func (h *Handler) DeleteAddress(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+	id, err := addressID(c)
+	if err != nil {
+		return err
+	}
+	profile, err := h.Repo.DeleteAddress(c.Request().Context(), userID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete address: %w", err)
+	}
+	return c.JSON(200, addressBook(profile))
+}
@@ -3,28 +3,14 @@ package helpers
 import (
 	"context"
 	"fmt"
-	"os"
 	"regexp"
 	"strings"
 
 	"github.com/cloudinary/cloudinary-go/v2"
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader" // Import the correct v2 uploader package for Cloudinary
-	"github.com/joho/godotenv"
-	"github.com/joshuatakyi/shop/internal"
-	"github.com/joshuatakyi/shop/internal/server"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/joshuatakyi/shop/internal/config"
 )
 
-func DoesSlugAlreadyExist(slug string) bool {
-	// Check if the slug already exists in the database
-	Doc, err := server.Client.Database(internal.DbName).Collection(internal.ProductCollection).CountDocuments(context.Background(), bson.M{"slug": slug})
-	if err != nil {
-		return false
-	}
-	// If the count is greater than 0, the slug already exists
-	return Doc > 0
-}
-
 func GenerateSlug(title, description, category string) string {
 	t := regexp.MustCompile(`[^a-zA-Z0-9]+`)
 	slug := fmt.Sprintf("%s-%s-%s", title, description, category)
@@ -34,30 +20,17 @@ func GenerateSlug(title, description, category string) string {
 	return slug
 }
 
-func CloudinaryInstance(imageId string) (bool, error) {
+// CloudinaryInstance deletes the image with the given public ID from Cloudinary
+func CloudinaryInstance(cfg config.CloudinaryConfig, imageId string) (bool, error) {
 	ctx := context.Background()
-	if err := godotenv.Load(".env.local"); err != nil {
-		fmt.Println("Error loading .env file")
+	if cfg.CloudName == "" || cfg.APIKey == "" || cfg.APISecret == "" {
+		return false, fmt.Errorf("cloudinary credentials are not configured")
 	}
 
-	CLOUDINARY_NAME := os.Getenv("CLOUDINARY_NAME")
-	if CLOUDINARY_NAME == "" {
-		fmt.Println("CLOUDINARY_NAME not set in .env file")
-		return false, fmt.Errorf("CLOUDINARY_NAME not set in .env file")
-	}
-	CLOUDINARY_API_KEY := os.Getenv("CLOUDINARY_API_KEY")
-	if CLOUDINARY_API_KEY == "" {
-		fmt.Println("CLOUDINARY_API_KEY not set in .env file")
-		return false, fmt.Errorf("CLOUDINARY_API_KEY not set in .env file")
-	}
-	CLOUDINARY_API_SECRET := os.Getenv("CLOUDINARY_API_SECRET")
-	if CLOUDINARY_API_SECRET == "" {
-		fmt.Println("CLOUDINARY_API_SECRET not set in .env file")
-		return false, fmt.Errorf("CLOUDINARY_API_SECRET not set in .env file")
+	cld, err := cloudinary.NewFromParams(cfg.CloudName, cfg.APIKey, cfg.APISecret)
+	if err != nil {
+		return false, fmt.Errorf("failed to create cloudinary client: %v", err)
 	}
-	// Add your Cloudinary product environment credentials.
-
-	cld, _ := cloudinary.NewFromParams(CLOUDINARY_NAME, CLOUDINARY_API_KEY, CLOUDINARY_API_SECRET)
 
 	resp, err := cld.Upload.Destroy(ctx, uploader.DestroyParams{
 		PublicID:     imageId,
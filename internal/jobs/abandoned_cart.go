@@ -0,0 +1,136 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"github.com/joshuatakyi/shop/internal/metrics"
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/joshuatakyi/shop/internal/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// abandonedCartJob names the worker in the job metrics
+const abandonedCartJob = "abandoned_cart"
+
+// AbandonedCartStore is the part of the repository the abandoned cart worker needs
+type AbandonedCartStore interface {
+	FindAbandonedCarts(ctx context.Context, idleSince, remindedBefore time.Time, limit int64) ([]models.Cart, error)
+	RecordCartEvent(ctx context.Context, event models.CartEvent) error
+	MarkCartReminderSent(ctx context.Context, cartID primitive.ObjectID, sentAt time.Time) error
+}
+
+// AbandonedCartWorker periodically looks for carts that have been left idle
+// and sends their owners a reminder
+type AbandonedCartWorker struct {
+	Store    AbandonedCartStore
+	Notifier notifier.Notifier
+
+	IdleAfter      time.Duration // How long a cart must sit untouched before it counts as abandoned
+	ReminderWindow time.Duration // Minimum time between two reminders for the same cart
+	Interval       time.Duration // How often to scan for abandoned carts
+	BatchSize      int64         // Maximum carts handled per scan
+	CartURL        string        // Link included in the reminder
+	Logger         *slog.Logger
+}
+
+// NewAbandonedCartWorker builds a worker with the timings from cfg. Reminders
+// link to the cart page of the frontend at frontendUrl.
+func NewAbandonedCartWorker(store AbandonedCartStore, n notifier.Notifier, cfg config.AbandonedCartConfig, frontendUrl string) (*AbandonedCartWorker, error) {
+	if cfg.IdleAfter <= 0 || cfg.ReminderWindow <= 0 || cfg.ScanInterval <= 0 {
+		return nil, fmt.Errorf("abandoned cart durations must be positive")
+	}
+
+	return &AbandonedCartWorker{
+		Store:          store,
+		Notifier:       n,
+		IdleAfter:      cfg.IdleAfter,
+		ReminderWindow: cfg.ReminderWindow,
+		Interval:       cfg.ScanInterval,
+		BatchSize:      100,
+		CartURL:        strings.TrimSuffix(frontendUrl, "/") + "/cart",
+		Logger:         slog.Default(),
+	}, nil
+}
+
+// Run scans on every tick until ctx is cancelled
+func (w *AbandonedCartWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		err := w.RunOnce(ctx)
+		metrics.ObserveJobRun(abandonedCartJob, start, err)
+		if err != nil {
+			w.Logger.Error("Abandoned cart scan failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce performs a single scan and sends reminders for the carts it finds
+func (w *AbandonedCartWorker) RunOnce(ctx context.Context) error {
+	now := time.Now()
+	carts, err := w.Store.FindAbandonedCarts(ctx, now.Add(-w.IdleAfter), now.Add(-w.ReminderWindow), w.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, cart := range carts {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		event := models.CartEvent{
+			CartID:      cart.ID,
+			UserID:      cart.UserID,
+			Type:        models.CartEventAbandoned,
+			ItemCount:   len(cart.Items),
+			TotalAmount: cart.TotalAmount,
+			IdleSince:   cart.UpdatedAt,
+		}
+
+		err := w.Notifier.Send(ctx, w.reminderFor(cart))
+		metrics.RecordReminder(err)
+		if err != nil {
+			// Leave reminder_sent_at alone so the next scan tries again
+			event.Error = err.Error()
+		} else {
+			event.Reminded = true
+			if err := w.Store.MarkCartReminderSent(ctx, cart.ID, now); err != nil {
+				w.Logger.Error("Failed to mark reminder sent", "cartId", cart.ID.Hex(), "error", err)
+			}
+		}
+
+		if err := w.Store.RecordCartEvent(ctx, event); err != nil {
+			w.Logger.Error("Failed to record abandoned cart event", "cartId", cart.ID.Hex(), "error", err)
+		}
+	}
+
+	return nil
+}
+
+func (w *AbandonedCartWorker) reminderFor(cart models.Cart) notifier.Message {
+	var body strings.Builder
+	body.WriteString("You left some items in your cart:\n\n")
+	for _, item := range cart.Items {
+		fmt.Fprintf(&body, "- %s x%d\n", item.Title, item.Quantity)
+	}
+	fmt.Fprintf(&body, "\nTotal: %.2f\n\nPick up where you left off: %s\n", cart.TotalAmount, w.CartURL)
+
+	return notifier.Message{
+		To:      cart.Email,
+		Subject: "You left something in your cart",
+		Body:    body.String(),
+	}
+}
@@ -0,0 +1,474 @@
+package memstore
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// cartOwner identifies a cart by either its user or its guest ID
+type cartOwner struct {
+	userID  primitive.ObjectID
+	guestID string
+}
+
+func userCartOwner(userID primitive.ObjectID) cartOwner {
+	return cartOwner{userID: userID}
+}
+
+func guestCartOwner(guestID string) cartOwner {
+	return cartOwner{guestID: guestID}
+}
+
+func (o cartOwner) owns(cart models.Cart) bool {
+	if o.guestID != "" {
+		return cart.GuestID == o.guestID
+	}
+	return cart.GuestID == "" && cart.UserID == o.userID
+}
+
+// findCart returns a copy of the owner's cart. Callers must hold the lock.
+func (s *Store) findCart(owner cartOwner) (models.Cart, bool) {
+	for _, cart := range s.carts {
+		if owner.owns(cart) {
+			return cloneCart(cart), true
+		}
+	}
+	return models.Cart{}, false
+}
+
+func (s *Store) newCart(owner cartOwner) models.Cart {
+	now := s.Now()
+	return models.Cart{
+		ID:        primitive.NewObjectID(),
+		UserID:    owner.userID,
+		GuestID:   owner.guestID,
+		Items:     []models.CartItem{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// saveCart stores a copy of cart. Notices are never stored. Callers must hold the lock.
+func (s *Store) saveCart(cart models.Cart) {
+	cart = cloneCart(cart)
+	cart.Notices = nil
+	s.carts[cart.ID] = cart
+}
+
+func cloneCart(cart models.Cart) models.Cart {
+	cart.Items = append([]models.CartItem{}, cart.Items...)
+	return cart
+}
+
+// productsFor returns the stored products referenced by items. Callers must hold the lock.
+func (s *Store) productsFor(items []models.CartItem) map[primitive.ObjectID]models.Product {
+	products := make(map[primitive.ObjectID]models.Product, len(items))
+	for _, item := range items {
+		if product, ok := s.products[item.ProductID]; ok {
+			products[item.ProductID] = product
+		}
+	}
+	return products
+}
+
+func (s *Store) AddToCart(ctx context.Context, userID primitive.ObjectID, item models.CartItem) error {
+	return s.addToCart(userCartOwner(userID), item)
+}
+
+func (s *Store) AddToGuestCart(ctx context.Context, guestID string, item models.CartItem) error {
+	return s.addToCart(guestCartOwner(guestID), item)
+}
+
+func (s *Store) addToCart(owner cartOwner, item models.CartItem) error {
+	if err := models.Validate(item); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[item.ProductID]
+	if !ok {
+		return models.NotFound("Product not found")
+	}
+	if item.Quantity <= 0 {
+		return models.InvalidField("quantity", "min", "quantity must be at least 1")
+	}
+	if err := models.CheckStock(product, item.Quantity); err != nil {
+		return err
+	}
+
+	item.Price = models.DiscountedPrice(product)
+	item.TotalPrice = math.Round(item.Price*float64(item.Quantity)*100) / 100
+	fillFromProduct(&item, product)
+	if item.ID.IsZero() {
+		item.ID = primitive.NewObjectID()
+	}
+
+	cart, exists := s.findCart(owner)
+	if !exists {
+		cart = s.newCart(owner)
+	}
+
+	itemExists := false
+	for i, cartItem := range cart.Items {
+		if cartItem.ProductID == item.ProductID && cartItem.Color == item.Color && cartItem.Model == item.Model {
+			if err := models.CheckStock(product, cartItem.Quantity+item.Quantity); err != nil {
+				return err
+			}
+			cart.Items[i].Quantity += item.Quantity
+			cart.Items[i].TotalPrice = math.Round(cart.Items[i].Price*float64(cart.Items[i].Quantity)*100) / 100
+			fillFromProduct(&cart.Items[i], product)
+			itemExists = true
+			break
+		}
+	}
+	if !itemExists {
+		cart.Items = append(cart.Items, item)
+	}
+
+	cart.RecalculateTotal()
+	cart.UpdatedAt = s.Now()
+	// New items after a checkout start a fresh cart for reminders
+	cart.CheckedOutAt = nil
+	s.saveCart(cart)
+	return nil
+}
+
+func (s *Store) UpdateCartItem(ctx context.Context, userID primitive.ObjectID, item models.CartItem, actions models.CartActions) error {
+	return s.updateCartItem(userCartOwner(userID), item, actions)
+}
+
+func (s *Store) UpdateGuestCartItem(ctx context.Context, guestID string, item models.CartItem, actions models.CartActions) error {
+	return s.updateCartItem(guestCartOwner(guestID), item, actions)
+}
+
+func (s *Store) updateCartItem(owner cartOwner, item models.CartItem, actions models.CartActions) error {
+	if err := models.Validate(item); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[item.ProductID]
+	if !ok {
+		return models.NotFound("Product not found")
+	}
+
+	cart, exists := s.findCart(owner)
+	if !exists {
+		cart = s.newCart(owner)
+	}
+
+	itemFound := false
+	for i, cartItem := range cart.Items {
+		if cartItem.ProductID != item.ProductID || cartItem.Color != item.Color || cartItem.Model != item.Model {
+			continue
+		}
+		itemFound = true
+
+		if actions.Increment {
+			cart.Items[i].Quantity++
+		} else if actions.Decrement {
+			cart.Items[i].Quantity--
+			if cart.Items[i].Quantity <= 0 {
+				cart.Items = append(cart.Items[:i], cart.Items[i+1:]...)
+				break
+			}
+		} else {
+			cart.Items[i].Quantity = item.Quantity
+		}
+
+		if err := models.CheckStock(product, cart.Items[i].Quantity); err != nil {
+			return err
+		}
+		cart.Items[i].TotalPrice = math.Round(cart.Items[i].Price*float64(cart.Items[i].Quantity)*100) / 100
+		fillFromProduct(&cart.Items[i], product)
+		break
+	}
+
+	if !itemFound {
+		// A missing cart is created with the item as given, a missing line is only added when incrementing
+		if exists && !actions.Increment {
+			return models.NotFound("Item not found in cart")
+		}
+		if actions.Increment {
+			item.Quantity = 1
+		}
+		if err := models.CheckStock(product, item.Quantity); err != nil {
+			return err
+		}
+		item.Price = models.DiscountedPrice(product)
+		item.TotalPrice = math.Round(item.Price*float64(item.Quantity)*100) / 100
+		fillFromProduct(&item, product)
+		cart.Items = append(cart.Items, item)
+	}
+
+	cart.RecalculateTotal()
+	cart.UpdatedAt = s.Now()
+	cart.CheckedOutAt = nil
+	s.saveCart(cart)
+	return nil
+}
+
+func (s *Store) RemoveCartItem(ctx context.Context, userID primitive.ObjectID, cartItemID primitive.ObjectID) error {
+	return s.removeCartItem(userCartOwner(userID), cartItemID)
+}
+
+func (s *Store) RemoveGuestCartItem(ctx context.Context, guestID string, cartItemID primitive.ObjectID) error {
+	return s.removeCartItem(guestCartOwner(guestID), cartItemID)
+}
+
+func (s *Store) removeCartItem(owner cartOwner, cartItemID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cart, exists := s.findCart(owner)
+	if !exists {
+		return models.NotFound("Cart not found")
+	}
+
+	foundIndex := -1
+	for i, item := range cart.Items {
+		if item.ID == cartItemID {
+			foundIndex = i
+			break
+		}
+	}
+	if foundIndex == -1 {
+		return models.NotFound("Item %s not found in cart", cartItemID.Hex())
+	}
+	cart.Items = append(cart.Items[:foundIndex], cart.Items[foundIndex+1:]...)
+
+	// An emptied cart is deleted, like in MongoDB
+	if len(cart.Items) == 0 {
+		delete(s.carts, cart.ID)
+		return nil
+	}
+
+	cart.RecalculateTotal()
+	cart.UpdatedAt = s.Now()
+	s.saveCart(cart)
+	return nil
+}
+
+func (s *Store) ClearCart(ctx context.Context, userID primitive.ObjectID) error {
+	return s.clearCart(userCartOwner(userID))
+}
+
+func (s *Store) ClearGuestCart(ctx context.Context, guestID string) error {
+	return s.clearCart(guestCartOwner(guestID))
+}
+
+func (s *Store) clearCart(owner cartOwner) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cart, exists := s.findCart(owner)
+	if !exists {
+		return models.NotFound("Cart not found")
+	}
+	cart.Items = []models.CartItem{}
+	cart.TotalAmount = 0
+	cart.UpdatedAt = s.Now()
+	s.saveCart(cart)
+	return nil
+}
+
+func (s *Store) GetUserCart(ctx context.Context, userID primitive.ObjectID) (*models.Cart, error) {
+	return s.getCart(userCartOwner(userID))
+}
+
+func (s *Store) GetGuestCart(ctx context.Context, guestID string) (*models.Cart, error) {
+	return s.getCart(guestCartOwner(guestID))
+}
+
+// getCart returns the owner's cart re-validated against the current products,
+// or an empty one that is not stored until the first write
+func (s *Store) getCart(owner cartOwner) (*models.Cart, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cart, exists := s.findCart(owner)
+	if !exists {
+		cart = s.newCart(owner)
+		return &cart, nil
+	}
+
+	// UpdatedAt is left alone as re-validation is not shopper activity
+	if models.ReconcileCart(&cart, s.productsFor(cart.Items)) {
+		s.saveCart(cart)
+	}
+	return &cart, nil
+}
+
+func (s *Store) MergeGuestCart(ctx context.Context, guestID string, userID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	guestCart, exists := s.findCart(guestCartOwner(guestID))
+	if !exists {
+		// Nothing to merge
+		return nil
+	}
+
+	user := userCartOwner(userID)
+	userCart, exists := s.findCart(user)
+	if !exists {
+		userCart = s.newCart(user)
+	}
+
+	models.MergeCartItems(&userCart, guestCart.Items, s.productsFor(guestCart.Items))
+	userCart.UpdatedAt = s.Now()
+	s.saveCart(userCart)
+	delete(s.carts, guestCart.ID)
+	return nil
+}
+
+func (s *Store) SetCartEmail(ctx context.Context, userID primitive.ObjectID, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cart, exists := s.findCart(userCartOwner(userID)); exists {
+		cart.Email = email
+		s.saveCart(cart)
+	}
+	return nil
+}
+
+func (s *Store) MarkCartCheckedOut(ctx context.Context, userID primitive.ObjectID, reference string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cart, exists := s.findCart(userCartOwner(userID))
+	if !exists || cart.PaidReference == reference {
+		return false, nil
+	}
+	now := s.Now()
+	cart.CheckedOutAt = &now
+	cart.PaidReference = reference
+	s.saveCart(cart)
+	return true, nil
+}
+
+func (s *Store) GetCartStats(ctx context.Context) (*models.CartStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := models.CartStats{}
+	totalItems := 0
+	for _, cart := range s.carts {
+		if len(cart.Items) == 0 {
+			continue
+		}
+		stats.ActiveCarts++
+		if cart.GuestID != "" {
+			stats.GuestCarts++
+		}
+		stats.TotalValue += cart.TotalAmount
+		for _, item := range cart.Items {
+			totalItems += item.Quantity
+		}
+	}
+
+	if stats.ActiveCarts > 0 {
+		stats.AverageValue = math.Round(stats.TotalValue/float64(stats.ActiveCarts)*100) / 100
+		stats.AverageItems = math.Round(float64(totalItems)/float64(stats.ActiveCarts)*100) / 100
+	}
+	stats.TotalValue = math.Round(stats.TotalValue*100) / 100
+	return &stats, nil
+}
+
+// Abandoned Cart Operations
+
+func (s *Store) FindAbandonedCarts(ctx context.Context, idleSince, remindedBefore time.Time, limit int64) ([]models.Cart, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var candidates []models.Cart
+	for _, cart := range s.carts {
+		if !cart.UpdatedAt.Before(idleSince) || len(cart.Items) == 0 || cart.Email == "" || cart.CheckedOutAt != nil {
+			continue
+		}
+		if cart.ReminderSentAt != nil && !cart.ReminderSentAt.Before(remindedBefore) {
+			continue
+		}
+		candidates = append(candidates, cloneCart(cart))
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].UpdatedAt.Before(candidates[j].UpdatedAt)
+	})
+	if limit > 0 && int64(len(candidates)) > limit {
+		candidates = candidates[:limit]
+	}
+
+	// Leave out items that can no longer be bought and carts with nothing left
+	abandoned := make([]models.Cart, 0, len(candidates))
+	for _, cart := range candidates {
+		inStock := cart.Items[:0]
+		for _, item := range cart.Items {
+			if product, ok := s.products[item.ProductID]; ok && product.IsAvailable && product.Stock > 0 {
+				inStock = append(inStock, item)
+			}
+		}
+		if len(inStock) == 0 {
+			continue
+		}
+		cart.Items = inStock
+		cart.RecalculateTotal()
+		abandoned = append(abandoned, cart)
+	}
+	return abandoned, nil
+}
+
+func (s *Store) RecordCartEvent(ctx context.Context, event models.CartEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = s.Now()
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *Store) MarkCartReminderSent(ctx context.Context, cartID primitive.ObjectID, sentAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cart, ok := s.carts[cartID]; ok {
+		cart.ReminderSentAt = &sentAt
+		s.carts[cartID] = cart
+	}
+	return nil
+}
+
+// CartEvents returns a copy of every recorded cart event
+func (s *Store) CartEvents() []models.CartEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]models.CartEvent(nil), s.events...)
+}
+
+// fillFromProduct sets the image, title and slug of a cart line when missing
+func fillFromProduct(item *models.CartItem, product models.Product) {
+	if item.Image == "" && len(product.Images) > 0 {
+		item.Image = product.Images[0]
+	}
+	if item.Title == "" {
+		item.Title = product.Title
+	}
+	if item.Slug == "" {
+		item.Slug = product.Slug
+	}
+}
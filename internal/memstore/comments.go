@@ -0,0 +1,103 @@
+package memstore
+
+import (
+	"context"
+
+	"github.com/joshuatakyi/shop/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Comments live on their product, as they do in MongoDB
+
+func (s *Store) AddComment(ctx context.Context, comment models.Comments, userId string, productId primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[productId]
+	if !ok {
+		return models.NotFound("Product not found")
+	}
+
+	now := s.Now()
+	product.Comments = append(append([]models.Comments{}, product.Comments...), models.Comments{
+		ID:        primitive.NewObjectID(),
+		ProductID: productId,
+		UserId:    userId,
+		Comment:   comment.Comment,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	s.products[productId] = product
+	return nil
+}
+
+func (s *Store) GetCommentsByProductID(ctx context.Context, productID primitive.ObjectID) ([]models.Comments, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	product, ok := s.products[productID]
+	if !ok {
+		return nil, models.NotFound("Product not found")
+	}
+	return append([]models.Comments(nil), product.Comments...), nil
+}
+
+func (s *Store) GetCommentByID(ctx context.Context, id primitive.ObjectID) (*models.Comments, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, product := range s.products {
+		for _, comment := range product.Comments {
+			if comment.ID == id {
+				return &comment, nil
+			}
+		}
+	}
+	return nil, models.NotFound("Comment not found")
+}
+
+func (s *Store) UpdateComment(ctx context.Context, id primitive.ObjectID, userId string, comment models.Comments) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	productID, index, ok := s.findComment(id, userId)
+	if !ok {
+		return models.NotFound("Comment not found")
+	}
+
+	product := s.products[productID]
+	product.Comments = append([]models.Comments{}, product.Comments...)
+	product.Comments[index].Comment = comment.Comment
+	product.Comments[index].UpdatedAt = s.Now()
+	s.products[productID] = product
+	return nil
+}
+
+func (s *Store) DeleteComment(ctx context.Context, id primitive.ObjectID, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	productID, index, ok := s.findComment(id, userId)
+	if !ok {
+		return models.NotFound("Comment not found")
+	}
+
+	product := s.products[productID]
+	comments := make([]models.Comments, 0, len(product.Comments)-1)
+	comments = append(comments, product.Comments[:index]...)
+	product.Comments = append(comments, product.Comments[index+1:]...)
+	s.products[productID] = product
+	return nil
+}
+
+// findComment locates a comment written by userId. Callers must hold the lock.
+func (s *Store) findComment(id primitive.ObjectID, userId string) (primitive.ObjectID, int, bool) {
+	for productID, product := range s.products {
+		for i, comment := range product.Comments {
+			if comment.ID == id && comment.UserId == userId {
+				return productID, i, true
+			}
+		}
+	}
+	return primitive.NilObjectID, 0, false
+}
@@ -0,0 +1,325 @@
+package memstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/joshuatakyi/shop/internal/helpers"
+	"github.com/joshuatakyi/shop/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *Store) AddProduct(ctx context.Context, product models.Product) (string, error) {
+	if err := models.Validate(product); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	product.CreatedAt = now
+	product.UpdatedAt = now
+	product.IsAvailable = true
+	product.IsNew = true
+	if product.ID.IsZero() {
+		product.ID = primitive.NewObjectID()
+	}
+
+	if product.Slug == "" {
+		if len(product.Category) == 0 {
+			return "", models.InvalidField("category", "required", "category is required to generate the slug")
+		}
+		description := product.Description[:min(30, len(product.Description))]
+		product.Slug = helpers.GenerateSlug(product.Title, description, product.Category[0])
+	}
+	for _, existing := range s.products {
+		if existing.Slug == product.Slug {
+			return "", models.Conflict("A product with the slug %q already exists", product.Slug)
+		}
+	}
+
+	s.products[product.ID] = product
+	return product.ID.Hex(), nil
+}
+
+func (s *Store) GetProductByID(ctx context.Context, id primitive.ObjectID) (*models.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	product, ok := s.products[id]
+	if !ok {
+		return nil, models.NotFound("Product not found")
+	}
+	return &product, nil
+}
+
+func (s *Store) GetProductBySlug(ctx context.Context, slug string) (*models.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, product := range s.products {
+		if product.Slug == slug {
+			return &product, nil
+		}
+	}
+	return nil, models.NotFound("Product not found")
+}
+
+// UpdateProduct applies the fields in update, keyed by their JSON names, like a
+// MongoDB $set
+func (s *Store) UpdateProduct(ctx context.Context, id primitive.ObjectID, update map[string]interface{}) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[id]
+	if !ok {
+		return "", models.NotFound("Product not found")
+	}
+
+	// Round trip through JSON so the update goes through the same field names and types as the API
+	current, err := json.Marshal(product)
+	if err != nil {
+		return "", fmt.Errorf("failed to update product: %v", err)
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(current, &fields); err != nil {
+		return "", fmt.Errorf("failed to update product: %v", err)
+	}
+	for key, value := range update {
+		fields[key] = value
+	}
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to update product: %v", err)
+	}
+	var updated models.Product
+	if err := json.Unmarshal(merged, &updated); err != nil {
+		return "", fmt.Errorf("failed to update product: %v", err)
+	}
+	updated.ID = id
+
+	s.products[id] = updated
+	return id.Hex(), nil
+}
+
+func (s *Store) DeleteProduct(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.products[id]; !ok {
+		return models.NotFound("Product not found")
+	}
+	delete(s.products, id)
+	return nil
+}
+
+func (s *Store) ListProducts(ctx context.Context, page, limit int) ([]models.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	products := make([]models.Product, 0, len(s.products))
+	for _, product := range s.products {
+		products = append(products, product)
+	}
+	sortProducts(products, "createdAt", -1)
+	return paginate(products, page, limit), nil
+}
+
+func (s *Store) FilterProducts(ctx context.Context, filterParams map[string]interface{}, page, limit int) ([]models.Product, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	products := []models.Product{}
+	for _, product := range s.products {
+		if matchesFilter(product, filterParams) {
+			products = append(products, product)
+		}
+	}
+
+	sortField, sortOrder := "createdAt", -1
+	if field, ok := filterParams["sort_by"].(string); ok && field != "" {
+		sortField, sortOrder = field, 1
+		if dir, ok := filterParams["sort_dir"].(string); ok && dir == "desc" {
+			sortOrder = -1
+		}
+	}
+	sortProducts(products, sortField, sortOrder)
+
+	return paginate(products, page, limit), len(products), nil
+}
+
+// BuildQuery returns the MongoDB query the filter would run, so callers see
+// the same query whichever store they use
+func (s *Store) BuildQuery(ctx context.Context, filter map[string]interface{}) (primitive.M, error) {
+	return models.BuildProductQuery(filter), nil
+}
+
+func (s *Store) GetSimilarProducts(ctx context.Context, productId primitive.ObjectID) ([]models.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	product, ok := s.products[productId]
+	if !ok {
+		return nil, models.NotFound("Product not found")
+	}
+
+	var similar []models.Product
+	for id, other := range s.products {
+		if id == productId {
+			continue
+		}
+		if anyIn(other.Category, product.Category) || anyIn(other.Models, product.Models) {
+			similar = append(similar, other)
+		}
+	}
+	sortProducts(similar, "createdAt", -1)
+	return similar, nil
+}
+
+// matchesFilter mirrors models.BuildProductQuery for a single product
+func matchesFilter(product models.Product, filter map[string]interface{}) bool {
+	for key, value := range filter {
+		switch key {
+		case "category":
+			if !matchesList(product.Category, value) {
+				return false
+			}
+		case "price_min":
+			if price, ok := value.(float64); ok && product.Price < price {
+				return false
+			}
+		case "price_max":
+			if price, ok := value.(float64); ok && product.Price > price {
+				return false
+			}
+		case "tags":
+			if !matchesList(product.Tags, value) {
+				return false
+			}
+		case "models":
+			if !matchesList(product.Models, value) {
+				return false
+			}
+		case "colors":
+			if !matchesList(product.Colors, value) {
+				return false
+			}
+		case "materials":
+			if !matchesList(product.Materials, value) {
+				return false
+			}
+		case "search":
+			if term, ok := value.(string); ok && term != "" && !matchesSearch(product, term) {
+				return false
+			}
+		case "is_available":
+			if want, ok := value.(bool); ok && product.IsAvailable != want {
+				return false
+			}
+		case "is_new":
+			if want, ok := value.(bool); ok && product.IsNew != want {
+				return false
+			}
+		case "is_on_sale":
+			if want, ok := value.(bool); ok && product.IsOnSale != want {
+				return false
+			}
+		case "sort_by", "sort_dir":
+			// Handled by the caller
+		default:
+			// Products have no other filterable fields, so nothing can match
+			return false
+		}
+	}
+	return true
+}
+
+// matchesList reports whether values contains the wanted string or any of the wanted strings
+func matchesList(values []string, wanted interface{}) bool {
+	switch want := wanted.(type) {
+	case []string:
+		return len(want) == 0 || anyIn(values, want)
+	case string:
+		return want == "" || anyIn(values, []string{want})
+	}
+	return true
+}
+
+func anyIn(values, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesSearch matches the full term or any of its words, case-insensitively,
+// against the same fields the MongoDB search looks at
+func matchesSearch(product models.Product, term string) bool {
+	fields := []string{product.Title, product.Description}
+	fields = append(fields, product.Category...)
+	fields = append(fields, product.Tags...)
+	fields = append(fields, product.Models...)
+
+	needles := append(helpers.TokenizeSearchQuery(term), strings.ToLower(term))
+	for _, field := range fields {
+		field = strings.ToLower(field)
+		for _, needle := range needles {
+			if needle != "" && strings.Contains(field, needle) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sortProducts sorts by a product's bson field name. Unknown fields keep the newest first.
+func sortProducts(products []models.Product, field string, order int) {
+	less := func(a, b models.Product) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	switch field {
+	case "price":
+		less = func(a, b models.Product) bool { return a.Price < b.Price }
+	case "discount":
+		less = func(a, b models.Product) bool { return a.Discount < b.Discount }
+	case "title":
+		less = func(a, b models.Product) bool { return a.Title < b.Title }
+	case "rating":
+		less = func(a, b models.Product) bool { return a.Rating < b.Rating }
+	case "stock":
+		less = func(a, b models.Product) bool { return a.Stock < b.Stock }
+	case "createdAt":
+	default:
+		order = -1
+	}
+
+	sort.SliceStable(products, func(i, j int) bool {
+		if order < 0 {
+			return less(products[j], products[i])
+		}
+		return less(products[i], products[j])
+	})
+}
+
+// paginate returns the requested page. A limit of 0 or less returns every
+// product after the skip, like an unset MongoDB limit.
+func paginate(products []models.Product, page, limit int) []models.Product {
+	skip := 0
+	if page > 1 && limit > 0 {
+		skip = (page - 1) * limit
+	}
+	if skip >= len(products) {
+		return []models.Product{}
+	}
+	products = products[skip:]
+	if limit > 0 && limit < len(products) {
+		products = products[:limit]
+	}
+	return products
+}
@@ -0,0 +1,122 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/joshuatakyi/shop/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Profile Operations
+
+func (s *Store) GetProfile(ctx context.Context, userID primitive.ObjectID) (*models.UserProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profile, ok := s.profiles[userID]
+	if !ok {
+		profile = models.UserProfile{ID: userID}
+	}
+	return copyProfile(profile), nil
+}
+
+func (s *Store) UpdateProfile(ctx context.Context, userID primitive.ObjectID, name, phone string) (*models.UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profile := s.profile(userID)
+	profile.Name = name
+	profile.Phone = phone
+	return s.saveProfile(profile), nil
+}
+
+func (s *Store) AddAddress(ctx context.Context, userID primitive.ObjectID, address models.Address) (*models.UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profile := s.profile(userID)
+	if len(profile.Addresses) >= models.MaxAddresses {
+		return nil, models.InvalidField("addresses", "max", fmt.Sprintf("You can save up to %d addresses", models.MaxAddresses))
+	}
+	if address.ID.IsZero() {
+		address.ID = primitive.NewObjectID()
+	}
+	profile.Addresses = append(profile.Addresses, address)
+	if address.IsDefault || profile.DefaultAddressID.IsZero() {
+		profile.DefaultAddressID = address.ID
+	}
+	return s.saveProfile(profile), nil
+}
+
+func (s *Store) UpdateAddress(ctx context.Context, userID primitive.ObjectID, address models.Address) (*models.UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profile := s.profile(userID)
+	i := slices.IndexFunc(profile.Addresses, func(a models.Address) bool { return a.ID == address.ID })
+	if i < 0 {
+		return nil, models.NotFound("Address not found")
+	}
+	profile.Addresses[i] = address
+	if address.IsDefault {
+		profile.DefaultAddressID = address.ID
+	}
+	return s.saveProfile(profile), nil
+}
+
+func (s *Store) SetDefaultAddress(ctx context.Context, userID, addressID primitive.ObjectID) (*models.UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profile := s.profile(userID)
+	if profile.Address(addressID) == nil {
+		return nil, models.NotFound("Address not found")
+	}
+	profile.DefaultAddressID = addressID
+	return s.saveProfile(profile), nil
+}
+
+func (s *Store) DeleteAddress(ctx context.Context, userID, addressID primitive.ObjectID) (*models.UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profile := s.profile(userID)
+	i := slices.IndexFunc(profile.Addresses, func(a models.Address) bool { return a.ID == addressID })
+	if i < 0 {
+		return nil, models.NotFound("Address not found")
+	}
+	profile.Addresses = slices.Delete(profile.Addresses, i, i+1)
+	if profile.DefaultAddressID == addressID {
+		profile.DefaultAddressID = primitive.NilObjectID
+		if len(profile.Addresses) > 0 {
+			profile.DefaultAddressID = profile.Addresses[0].ID
+		}
+	}
+	return s.saveProfile(profile), nil
+}
+
+// profile returns a copy of the user's profile to modify, new if they have
+// none yet. Callers hold the lock.
+func (s *Store) profile(userID primitive.ObjectID) models.UserProfile {
+	profile, ok := s.profiles[userID]
+	if !ok {
+		return models.UserProfile{ID: userID, CreatedAt: s.Now()}
+	}
+	profile.Addresses = slices.Clone(profile.Addresses)
+	return profile
+}
+
+// saveProfile stores the profile and returns a copy of it. Callers hold the lock.
+func (s *Store) saveProfile(profile models.UserProfile) *models.UserProfile {
+	profile.UpdatedAt = s.Now()
+	s.profiles[profile.ID] = profile
+	return copyProfile(profile)
+}
+
+func copyProfile(profile models.UserProfile) *models.UserProfile {
+	profile.Addresses = slices.Clone(profile.Addresses)
+	profile.MarkDefault()
+	return &profile
+}
@@ -0,0 +1,113 @@
+package memstore
+
+import (
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewSeeded returns a store filled with a small demo catalogue
+func NewSeeded() *Store {
+	s := New()
+	now := s.Now()
+
+	for i, product := range demoProducts() {
+		product.ID = primitive.NewObjectID()
+		product.IsAvailable = true
+		// Space out creation times so "newest first" is stable
+		product.CreatedAt = now.Add(-time.Duration(i) * time.Hour)
+		product.UpdatedAt = product.CreatedAt
+		s.products[product.ID] = product
+	}
+	return s
+}
+
+func demoProducts() []models.Product {
+	return []models.Product{
+		{
+			Title:       "MagSafe Clear Case",
+			Description: "Crystal clear case with built-in magnets that never yellows.",
+			Price:       29.99,
+			Slug:        "magsafe-clear-case",
+			Category:    []string{"cases"},
+			Images:      []string{"https://images.example.com/clear-case.jpg"},
+			Tags:        []string{"magsafe", "clear"},
+			IsNew:       true,
+			Models:      []string{"iPhone 15", "iPhone 15 Pro"},
+			Colors:      []string{"clear"},
+			Materials:   []string{"polycarbonate"},
+			Warranty:    12,
+			Details:     []string{"Raised camera lip"},
+			Features:    []string{"MagSafe compatible"},
+			Stock:       40,
+		},
+		{
+			Title:       "Leather Folio Wallet",
+			Description: "Full grain leather folio with three card slots and a cash pocket.",
+			Price:       59.00,
+			Discount:    15,
+			Slug:        "leather-folio-wallet",
+			Category:    []string{"cases", "wallets"},
+			Images:      []string{"https://images.example.com/leather-folio.jpg"},
+			Tags:        []string{"leather", "wallet"},
+			IsOnSale:    true,
+			Models:      []string{"iPhone 15", "Galaxy S24"},
+			Colors:      []string{"brown", "black"},
+			Materials:   []string{"leather"},
+			Warranty:    24,
+			Details:     []string{"Holds three cards"},
+			Features:    []string{"Stand mode"},
+			Stock:       12,
+		},
+		{
+			Title:       "Tempered Glass Screen Protector",
+			Description: "Edge to edge 9H glass with an alignment frame for bubble free fitting.",
+			Price:       14.50,
+			Slug:        "tempered-glass-screen-protector",
+			Category:    []string{"screen-protectors"},
+			Images:      []string{"https://images.example.com/screen-protector.jpg"},
+			Tags:        []string{"glass", "protection"},
+			Models:      []string{"iPhone 15", "iPhone 15 Pro", "Galaxy S24"},
+			Colors:      []string{"clear"},
+			Materials:   []string{"glass"},
+			Details:     []string{"Pack of two"},
+			Features:    []string{"Alignment frame included"},
+			Stock:       100,
+		},
+		{
+			Title:       "20W USB-C Charger",
+			Description: "Compact fast charger with a foldable plug and USB-C power delivery.",
+			Price:       24.00,
+			Discount:    10,
+			Slug:        "20w-usb-c-charger",
+			Category:    []string{"chargers"},
+			Images:      []string{"https://images.example.com/usb-c-charger.jpg"},
+			Tags:        []string{"usb-c", "fast-charging"},
+			IsNew:       true,
+			IsOnSale:    true,
+			Models:      []string{"universal"},
+			Colors:      []string{"white"},
+			Materials:   []string{"plastic"},
+			Warranty:    12,
+			Details:     []string{"Foldable plug"},
+			Features:    []string{"Power delivery"},
+			Stock:       3,
+		},
+		{
+			Title:       "Silicone Sport Band",
+			Description: "Soft silicone watch band with a pin and tuck closure for workouts.",
+			Price:       19.99,
+			Slug:        "silicone-sport-band",
+			Category:    []string{"watch-bands"},
+			Images:      []string{"https://images.example.com/sport-band.jpg"},
+			Tags:        []string{"watch", "sport"},
+			Models:      []string{"Watch 41mm", "Watch 45mm"},
+			Colors:      []string{"black", "red", "blue"},
+			Materials:   []string{"silicone"},
+			Details:     []string{"Two sizes in the box"},
+			Features:    []string{"Sweat resistant"},
+			Stock:       0,
+		},
+	}
+}
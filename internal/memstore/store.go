@@ -0,0 +1,171 @@
+// Package memstore keeps the whole shop in memory. It implements
+// models.ShopCalls with the same rules as the MongoDB repository so handlers
+// can be tested without a database and the API can run in demo mode.
+package memstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Store must keep satisfying ShopCalls
+var _ models.ShopCalls = (*Store)(nil)
+
+// Store is an in-memory models.ShopCalls. It is safe for concurrent use; every
+// value handed in or out is copied so callers never share state with the store.
+type Store struct {
+	mu       sync.RWMutex
+	products map[primitive.ObjectID]models.Product
+	carts    map[primitive.ObjectID]models.Cart
+	events   []models.CartEvent
+	orders   map[primitive.ObjectID]models.Order
+	payments map[primitive.ObjectID]models.Payment
+	reviews  []models.Review
+	users    map[primitive.ObjectID]models.User
+	sessions map[primitive.ObjectID]models.UserSession
+	resets   []models.PasswordReset
+	revoked  map[string]models.RevokedToken
+	profiles map[primitive.ObjectID]models.UserProfile
+
+	// Now returns the current time. Tests can replace it to age carts.
+	Now func() time.Time
+}
+
+// Ping always succeeds; the store lives in the process
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
+// New returns an empty store
+func New() *Store {
+	return &Store{
+		products: map[primitive.ObjectID]models.Product{},
+		carts:    map[primitive.ObjectID]models.Cart{},
+		orders:   map[primitive.ObjectID]models.Order{},
+		payments: map[primitive.ObjectID]models.Payment{},
+		users:    map[primitive.ObjectID]models.User{},
+		sessions: map[primitive.ObjectID]models.UserSession{},
+		revoked:  map[string]models.RevokedToken{},
+		profiles: map[primitive.ObjectID]models.UserProfile{},
+		Now:      time.Now,
+	}
+}
+
+// Order Operations
+
+func (s *Store) CreateOrder(ctx context.Context, order models.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if order.ID.IsZero() {
+		order.ID = primitive.NewObjectID()
+	}
+	now := s.Now()
+	order.CreatedAt = now
+	order.UpdatedAt = now
+	s.orders[order.ID] = order
+	return nil
+}
+
+func (s *Store) GetOrderByID(ctx context.Context, id primitive.ObjectID) (*models.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := s.orders[id]
+	if !ok {
+		return nil, models.NotFound("Order not found")
+	}
+	return &order, nil
+}
+
+func (s *Store) UpdateOrderStatus(ctx context.Context, id primitive.ObjectID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[id]
+	if !ok {
+		return models.NotFound("Order not found")
+	}
+	order.Status = status
+	order.UpdatedAt = s.Now()
+	s.orders[id] = order
+	return nil
+}
+
+func (s *Store) GetUserOrders(ctx context.Context, userID primitive.ObjectID) ([]models.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orders := []models.Order{}
+	for _, order := range s.orders {
+		if order.UserID == userID {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+// Review Operations
+
+func (s *Store) AddReview(ctx context.Context, review models.Review) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if review.ID.IsZero() {
+		review.ID = primitive.NewObjectID()
+	}
+	now := s.Now()
+	review.CreatedAt = now
+	review.UpdatedAt = now
+	s.reviews = append(s.reviews, review)
+	return nil
+}
+
+func (s *Store) GetProductReviews(ctx context.Context, productID primitive.ObjectID) ([]models.Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reviews := []models.Review{}
+	for _, review := range s.reviews {
+		if review.ProductID == productID {
+			reviews = append(reviews, review)
+		}
+	}
+	return reviews, nil
+}
+
+// Payment Operations
+
+func (s *Store) ProcessPayment(ctx context.Context, payment models.Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if payment.ID.IsZero() {
+		payment.ID = primitive.NewObjectID()
+	}
+	now := s.Now()
+	payment.CreatedAt = now
+	payment.UpdatedAt = now
+	s.payments[payment.ID] = payment
+	return nil
+}
+
+func (s *Store) GetPaymentByID(ctx context.Context, id primitive.ObjectID) (*models.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payment, ok := s.payments[id]
+	if !ok {
+		return nil, models.NotFound("Payment not found")
+	}
+	return &payment, nil
+}
+
+// ApplyCoupon always fails as the store has no coupons
+func (s *Store) ApplyCoupon(ctx context.Context, code string, orderID primitive.ObjectID) error {
+	return models.NotFound("Coupon %q not found", code)
+}
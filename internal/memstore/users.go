@@ -0,0 +1,208 @@
+package memstore
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// User Operations
+
+func (s *Store) CreateUser(ctx context.Context, user models.User) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.users {
+		if existing.Email == user.Email {
+			return nil, models.Conflict("An account with this email already exists")
+		}
+	}
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	now := s.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	s.users[user.ID] = user
+	return &user, nil
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, models.NotFound("User not found")
+	}
+	return &user, nil
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, models.NotFound("User not found")
+}
+
+func (s *Store) UpdateUserPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return models.NotFound("User not found")
+	}
+	user.PasswordHash = passwordHash
+	user.UpdatedAt = s.Now()
+	s.users[id] = user
+	return nil
+}
+
+// Session Operations
+
+func (s *Store) CreateSession(ctx context.Context, session models.UserSession) (*models.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+	s.sessions[session.ID] = session
+	return &session, nil
+}
+
+func (s *Store) GetSession(ctx context.Context, id primitive.ObjectID) (*models.UserSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, models.NotFound("Session not found")
+	}
+	return &session, nil
+}
+
+func (s *Store) RotateSession(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, accessTokens []models.IssuedToken, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.RefreshTokenHash != oldHash || session.RevokedAt != nil {
+		return models.Conflict("Refresh token has already been used")
+	}
+	session.RefreshTokenHash = newHash
+	session.AccessTokens = append([]models.IssuedToken(nil), accessTokens...)
+	session.LastUsedAt = usedAt
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *Store) ListUserSessions(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]models.UserSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := []models.UserSession{}
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.Active(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b models.UserSession) int {
+		return b.LastUsedAt.Compare(a.LastUsedAt)
+	})
+	return sessions, nil
+}
+
+func (s *Store) RevokeSession(ctx context.Context, id primitive.ObjectID, revokedAt time.Time) (*models.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, models.NotFound("Session not found")
+	}
+	if session.RevokedAt == nil {
+		session.RevokedAt = &revokedAt
+		s.sessions[id] = session
+	}
+	return &session, nil
+}
+
+func (s *Store) RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, revokedAt time.Time) ([]models.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := []models.UserSession{}
+	for id, session := range s.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &revokedAt
+			s.sessions[id] = session
+			revoked = append(revoked, session)
+		}
+	}
+	return revoked, nil
+}
+
+// Token Denylist Operations
+
+func (s *Store) AddRevokedTokens(ctx context.Context, tokens []models.RevokedToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range tokens {
+		if _, ok := s.revoked[token.JTI]; !ok {
+			s.revoked[token.JTI] = token
+		}
+	}
+	return nil
+}
+
+func (s *Store) ListRevokedTokens(ctx context.Context, since, now time.Time) ([]models.RevokedToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := []models.RevokedToken{}
+	for _, token := range s.revoked {
+		if !token.RevokedAt.Before(since) && now.Before(token.ExpiresAt) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// Password Reset Operations
+
+func (s *Store) CreatePasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reset.ID.IsZero() {
+		reset.ID = primitive.NewObjectID()
+	}
+	s.resets = append(s.resets, reset)
+	return nil
+}
+
+func (s *Store) ConsumePasswordReset(ctx context.Context, tokenHash string, now time.Time) (*models.PasswordReset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, reset := range s.resets {
+		if reset.TokenHash != tokenHash || reset.UsedAt != nil || !now.Before(reset.ExpiresAt) {
+			continue
+		}
+		reset.UsedAt = &now
+		s.resets[i] = reset
+		return &reset, nil
+	}
+	return nil, models.NotFound("Password reset token is invalid or expired")
+}
@@ -0,0 +1,236 @@
+// Package metrics defines the Prometheus metrics the API exposes on /metrics:
+// HTTP traffic per route, MongoDB command timings, Paystack call outcomes, the
+// cart to payment funnel and background job results. The collectors live for
+// the whole process, so every router, repository and job shares them.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shop"
+
+// Funnel stages, in the order a shopper goes through them
+const (
+	StageCartAdd         = "cart_add"
+	StageCheckoutStarted = "checkout_started"
+	StageOrderPaid       = "order_paid"
+	StagePaymentFailed   = "payment_failed"
+)
+
+// Outcomes used by the Paystack, job and reminder metrics
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// registry holds only our collectors plus the Go and process ones, so nothing
+// a dependency registers globally leaks into /metrics
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	mongoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_command_duration_seconds",
+		Help:      "Time taken by MongoDB commands sent by the repository, by command and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"command", "outcome"})
+
+	paystackDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "paystack_request_duration_seconds",
+		Help:      "Time taken by Paystack API calls, by operation and outcome.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"operation", "outcome"})
+
+	funnelEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "funnel_events_total",
+		Help:      "Shoppers reaching each stage from adding to cart to paying. Payment stages count verifications.",
+	}, []string{"stage"})
+
+	jobRuns = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_run_duration_seconds",
+		Help:      "Time taken by background job runs, by job and outcome.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60},
+	}, []string{"job", "outcome"})
+
+	jobLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful run of each background job.",
+	}, []string{"job"})
+
+	abandonedCartReminders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "abandoned_cart_reminders_total",
+		Help:      "Abandoned cart reminders sent, by outcome.",
+	}, []string{"outcome"})
+
+	sessionCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_cache_requests_total",
+		Help:      "Auth.js session lookups, by result: hit, miss or shared with a verification already in flight.",
+	}, []string{"result"})
+
+	sessionVerifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_verifications_total",
+		Help:      "Auth.js session verifications sent to Next.js, by outcome: valid, invalid, error or circuit_open.",
+	}, []string{"outcome"})
+
+	sessionCircuitOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "session_circuit_open",
+		Help:      "1 while the circuit breaker in front of the Next.js session check is open.",
+	})
+
+	rateLimitRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_requests_total",
+		Help:      "Requests checked against a rate limit policy, by policy and result: allowed, limited or error.",
+	}, []string{"policy", "result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		mongoDuration,
+		paystackDuration,
+		funnelEvents,
+		jobRuns,
+		jobLastSuccess,
+		abandonedCartReminders,
+		sessionCache,
+		sessionVerifications,
+		sessionCircuitOpen,
+		rateLimitRequests,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts and times every request by its route pattern, e.g.
+// /api/v1/get_product_by_slug/:slug, so the number of series stays bounded no
+// matter which URLs clients request
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil && !c.Response().Committed {
+				// The error handler has not written the response yet
+				status = http.StatusInternalServerError
+				var httpErr *echo.HTTPError
+				if errors.As(err, &httpErr) {
+					status = httpErr.Code
+				}
+			}
+
+			route := c.Path()
+			if route == "" {
+				// No route matched, e.g. a scanner probing random URLs
+				route = "unmatched"
+			}
+
+			method := c.Request().Method
+			httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+			httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// ObserveMongoCommand records how long a MongoDB command took
+func ObserveMongoCommand(command string, took time.Duration, failed bool) {
+	outcome := OutcomeSuccess
+	if failed {
+		outcome = OutcomeError
+	}
+	mongoDuration.WithLabelValues(command, outcome).Observe(took.Seconds())
+}
+
+// ObservePaystackCall records a Paystack API call that started at start and
+// ended with err
+func ObservePaystackCall(operation string, start time.Time, err error) {
+	paystackDuration.WithLabelValues(operation, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// RecordFunnel counts a shopper reaching stage
+func RecordFunnel(stage string) {
+	funnelEvents.WithLabelValues(stage).Inc()
+}
+
+// ObserveJobRun records a background job run that started at start and ended with err
+func ObserveJobRun(job string, start time.Time, err error) {
+	jobRuns.WithLabelValues(job, outcome(err)).Observe(time.Since(start).Seconds())
+	if err == nil {
+		jobLastSuccess.WithLabelValues(job).SetToCurrentTime()
+	}
+}
+
+// RecordReminder counts an abandoned cart reminder that was sent or failed with err
+func RecordReminder(err error) {
+	abandonedCartReminders.WithLabelValues(outcome(err)).Inc()
+}
+
+// RecordSessionCache counts a session lookup by its cache result
+func RecordSessionCache(result string) {
+	sessionCache.WithLabelValues(result).Inc()
+}
+
+// RecordSessionVerification counts a session verification by its outcome
+func RecordSessionVerification(outcome string) {
+	sessionVerifications.WithLabelValues(outcome).Inc()
+}
+
+// SetSessionCircuitOpen records whether the session circuit breaker is open
+func SetSessionCircuitOpen(open bool) {
+	if open {
+		sessionCircuitOpen.Set(1)
+	} else {
+		sessionCircuitOpen.Set(0)
+	}
+}
+
+// RecordRateLimit counts a request checked against a rate limit policy
+func RecordRateLimit(policy, result string) {
+	rateLimitRequests.WithLabelValues(policy, result).Inc()
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
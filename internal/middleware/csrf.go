@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/logging"
+	"github.com/labstack/echo/v4"
+)
+
+// CSRF tokens are sent back in CSRFHeader and compared with CSRFCookie
+const (
+	CSRFCookie = "csrf-token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// csrfMaxAge is how long the browser keeps the CSRF cookie
+const csrfMaxAge = 12 * time.Hour
+
+// CSRFToken serves the CSRF token the frontend sends back in CSRFHeader with
+// every state-changing request the browser authenticates with a cookie. It
+// runs after OptionalAuth and GuestCartMiddleware: the token is only valid for
+// the signed-in user, or for the guest cart, it was issued to. The token is
+// also set as a cookie; a valid one already set is handed out again, so tabs
+// sharing the cookie do not replace each other's token.
+func CSRFToken(secret []byte) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if len(secret) == 0 {
+			logging.FromContext(c.Request().Context()).Error("CSRF protection is not configured: no signing secret")
+			return echo.NewHTTPError(http.StatusInternalServerError, "CSRF tokens are not available")
+		}
+
+		identity := csrfIdentity(c)
+		token := ""
+		if cookie, err := c.Cookie(CSRFCookie); err == nil && validCSRFToken(cookie.Value, identity, secret) {
+			token = cookie.Value
+		}
+		if token == "" {
+			var err error
+			if token, err = newCSRFToken(identity, secret); err != nil {
+				return err
+			}
+		}
+		c.SetCookie(csrfCookie(c, token, int(csrfMaxAge.Seconds())))
+		c.Response().Header().Set("Cache-Control", "no-store")
+
+		return c.JSON(200, echo.Map{
+			"csrf_token": token,
+			"header":     CSRFHeader,
+		})
+	}
+}
+
+// CSRFProtect runs after AuthMiddleware or GuestCartMiddleware. Requests they
+// identified by a cookie, which the browser attaches to cross-site requests
+// too, must carry the CSRF cookie and the same token in CSRFHeader unless
+// their method is safe. Requests with a Bearer token are not checked: another
+// site cannot make the browser add that header.
+func CSRFProtect(secret []byte) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			viaCookie, _ := c.Get("authCookie").(bool)
+			guestID, _ := c.Get("guestCartId").(string)
+			if !viaCookie && guestID == "" || safeMethod(c.Request().Method) {
+				return next(c)
+			}
+
+			header := c.Request().Header.Get(CSRFHeader)
+			cookie, err := c.Cookie(CSRFCookie)
+			if header == "" || err != nil || subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 || !validCSRFToken(header, csrfIdentity(c), secret) {
+				return echo.NewHTTPError(http.StatusForbidden, "Invalid or missing CSRF token")
+			}
+			return next(c)
+		}
+	}
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// csrfIdentity is who a token is issued to: the signed-in user, else the
+// guest cart. Binding the token to it stops a sibling subdomain, which can
+// set cookies for us, from planting a token it fetched for itself.
+func csrfIdentity(c echo.Context) string {
+	if userId, _ := c.Get("userId").(string); userId != "" {
+		return "user:" + userId
+	}
+	if guestID, _ := c.Get("guestCartId").(string); guestID != "" {
+		return "guest:" + guestID
+	}
+	return ""
+}
+
+// newCSRFToken returns "<nonce>.<signature>"
+func newCSRFToken(identity string, secret []byte) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating CSRF token: %v", err)
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(buf)
+	return nonce + "." + csrfSignature(nonce, identity, secret), nil
+}
+
+// csrfSignature is an HMAC-SHA256 of the nonce and the identity. The prefix
+// keeps it from matching a guest cart signature made with the same secret;
+// the nonce never contains a dot, so the two cannot run into each other.
+func csrfSignature(nonce, identity string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("csrf." + nonce + "." + identity))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func validCSRFToken(token, identity string, secret []byte) bool {
+	nonce, signature, found := strings.Cut(token, ".")
+	if !found || nonce == "" || identity == "" || len(secret) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(csrfSignature(nonce, identity, secret)))
+}
+
+func csrfCookie(c echo.Context, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true, // The frontend reads the token from the response body
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/logging"
+	"github.com/labstack/echo/v4"
+)
+
+// GuestCartCookie is the cookie holding the signed identifier of an anonymous cart
+const GuestCartCookie = "guest-cart"
+
+// guestCartMaxAge is how long the browser keeps the guest cart cookie
+const guestCartMaxAge = 30 * 24 * time.Hour
+
+// GuestCartMerger moves a guest cart into the cart of the user who just signed in
+type GuestCartMerger func(ctx context.Context, guestID, userID string) error
+
+// GuestCartMiddleware makes sure every request carries a signed guest cart
+// cookie, issuing a new one when it is missing or has been tampered with.
+// The verified guest ID is stored in the context under "guestCartId".
+// Requests AuthMiddleware signed in are left alone.
+func GuestCartMiddleware(secret []byte) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if userId, _ := c.Get("userId").(string); userId != "" {
+				return next(c)
+			}
+			if len(secret) == 0 {
+				logging.FromContext(c.Request().Context()).Error("Guest carts are not configured: no signing secret")
+				return echo.NewHTTPError(http.StatusInternalServerError, "Guest carts are not available")
+			}
+
+			guestID := ""
+			if cookie, err := c.Cookie(GuestCartCookie); err == nil {
+				guestID, _ = verifyGuestCartValue(cookie.Value, secret)
+			}
+
+			// Issue a fresh identifier when there is no valid cookie
+			if guestID == "" {
+				var err error
+				guestID, err = newGuestCartID()
+				if err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create guest cart")
+				}
+				c.SetCookie(guestCartCookie(c, signGuestCartID(guestID, secret), int(guestCartMaxAge.Seconds())))
+			}
+
+			c.Set("guestCartId", guestID)
+			return next(c)
+		}
+	}
+}
+
+// MergeGuestCart runs after AuthMiddleware. When an authenticated request still
+// carries a guest cart cookie, the guest cart is merged into the user's cart and
+// the cookie is cleared. Merge failures are logged and retried on the next request.
+func MergeGuestCart(secret []byte, merge GuestCartMerger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userId, ok := c.Get("userId").(string)
+			if !ok || userId == "" {
+				return next(c)
+			}
+
+			cookie, err := c.Cookie(GuestCartCookie)
+			if err != nil || cookie.Value == "" {
+				return next(c)
+			}
+
+			if len(secret) == 0 {
+				return next(c)
+			}
+
+			guestID, err := verifyGuestCartValue(cookie.Value, secret)
+			if err != nil {
+				// A forged or stale cookie is simply dropped
+				c.SetCookie(guestCartCookie(c, "", -1))
+				return next(c)
+			}
+
+			if err := merge(c.Request().Context(), guestID, userId); err != nil {
+				logging.FromContext(c.Request().Context()).Error("Failed to merge guest cart", "userId", userId, "error", err)
+				return next(c)
+			}
+
+			c.SetCookie(guestCartCookie(c, "", -1))
+			return next(c)
+		}
+	}
+}
+
+func newGuestCartID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating guest cart id: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// signGuestCartID returns "<id>.<signature>" where the signature is an HMAC-SHA256 of the id
+func signGuestCartID(guestID string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(guestID))
+	return guestID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyGuestCartValue checks the cookie signature and returns the guest ID it carries
+func verifyGuestCartValue(value string, secret []byte) (string, error) {
+	guestID, _, found := strings.Cut(value, ".")
+	if !found || guestID == "" {
+		return "", fmt.Errorf("malformed guest cart cookie")
+	}
+	if !hmac.Equal([]byte(signGuestCartID(guestID, secret)), []byte(value)) {
+		return "", fmt.Errorf("invalid guest cart signature")
+	}
+	return guestID, nil
+}
+
+func guestCartCookie(c echo.Context, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     GuestCartCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
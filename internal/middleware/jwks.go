@@ -0,0 +1,321 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/logging"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/sync/singleflight"
+)
+
+// minJWKSRefetch limits how often an unknown kid or a failed fetch sends us
+// back to the identity provider, so forged tokens cannot hammer it
+const minJWKSRefetch = time.Minute
+
+// maxJWKSSize caps the JWKS documents we are willing to read
+const maxJWKSSize = 1 << 20
+
+// KeySet holds the public keys of an identity provider, read from a JWKS
+// document at a URL or in a file. Keys are loaded on first use, trusted for
+// the refresh interval and reloaded early when a token names a kid we have
+// not seen, which is how providers roll over to a new key.
+type KeySet struct {
+	url     string
+	file    string
+	refresh time.Duration
+	client  *http.Client
+
+	minRefetch time.Duration // minJWKSRefetch, shorter in tests
+
+	// Requests needing a reload wait on one fetch, made without holding mu,
+	// so tokens whose keys are cached keep being verified meanwhile
+	flights singleflight.Group
+
+	mu          sync.Mutex
+	keys        map[string]publicKey // by kid
+	fetchedAt   time.Time            // last successful load
+	attemptedAt time.Time            // last load, successful or not
+}
+
+// publicKey is a verification key and the algorithm its JWK was restricted to, if any
+type publicKey struct {
+	key crypto.PublicKey
+	alg string
+}
+
+// jwksClient fetches JWKS documents. Like nextAuthClient it records a client span.
+var jwksClient = &http.Client{
+	Timeout:   5 * time.Second,
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
+
+// NewKeySet returns the key set served at url or stored in file, reloaded
+// every refresh. It returns nil when neither is set.
+func NewKeySet(url, file string, refresh time.Duration) *KeySet {
+	if url == "" && file == "" {
+		return nil
+	}
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	return &KeySet{url: url, file: file, refresh: refresh, client: jwksClient, minRefetch: minJWKSRefetch}
+}
+
+// Key returns the key with the given kid for a token signed with alg. A token
+// without a kid is accepted when the set holds a single key.
+func (k *KeySet) Key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	expired := time.Since(k.fetchedAt) > k.refresh && k.loadDue()
+	k.mu.Unlock()
+	if expired {
+		if err := k.load(ctx, false); err != nil {
+			if _, _, loaded := k.lookup(kid); !loaded {
+				return nil, err
+			}
+			// Keep verifying with the keys we have until the provider is back
+			logging.FromContext(ctx).Warn("Failed to refresh JWKS, using cached keys", "error", err)
+		}
+	}
+
+	key, ok, loaded := k.lookup(kid)
+	if !loaded {
+		return nil, fmt.Errorf("JWKS is unavailable, retrying in %s", k.minRefetch)
+	}
+	if !ok {
+		if err := k.load(ctx, false); err != nil {
+			return nil, err
+		}
+		key, ok, _ = k.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("no key with kid %q in JWKS", kid)
+	}
+	if key.alg != "" && key.alg != alg {
+		return nil, fmt.Errorf("key %q is for %s, token is signed with %s", kid, key.alg, alg)
+	}
+	return key.key, nil
+}
+
+// Refresh loads the key set now, e.g. to fail fast at startup
+func (k *KeySet) Refresh(ctx context.Context) error {
+	return k.load(ctx, true)
+}
+
+// loadDue reports whether enough time has passed since the last load attempt.
+// Callers must hold the lock.
+func (k *KeySet) loadDue() bool {
+	return k.attemptedAt.IsZero() || time.Since(k.attemptedAt) >= k.minRefetch
+}
+
+// lookup finds a key by kid, and reports whether any keys are loaded at all
+func (k *KeySet) lookup(kid string) (key publicKey, ok, loaded bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true, true
+		}
+	}
+	key, ok = k.keys[kid]
+	return key, ok, k.keys != nil
+}
+
+// load replaces the keys with the current JWKS document. Concurrent callers
+// share one fetch. Unless forced, it does nothing when the keys were loaded
+// too recently, e.g. by the fetch a caller just missed.
+func (k *KeySet) load(ctx context.Context, force bool) error {
+	_, err, _ := k.flights.Do("jwks", func() (any, error) {
+		k.mu.Lock()
+		if !force && !k.loadDue() {
+			k.mu.Unlock()
+			return nil, nil
+		}
+		k.attemptedAt = time.Now()
+		k.mu.Unlock()
+
+		// The caller's cancellation must not fail the other requests waiting on this fetch
+		data, err := k.read(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parseJWKS(data)
+		if err != nil {
+			return nil, err
+		}
+
+		k.mu.Lock()
+		k.keys = keys
+		k.fetchedAt = time.Now()
+		k.mu.Unlock()
+		return nil, nil
+	})
+	return err
+}
+
+func (k *KeySet) read(ctx context.Context) ([]byte, error) {
+	if k.file != "" {
+		data, err := os.ReadFile(k.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS file: %v", err)
+		}
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS response: %v", err)
+	}
+	return data, nil
+}
+
+// jwk is one key of a JWKS document (RFC 7517). Only the members of the key
+// types we verify with are listed.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS reads the signing keys of a JWKS document. Encryption keys, key
+// types and curves we do not support and RSA keys under 2048 bits are
+// skipped. A malformed key of a type we support, or a document with no usable
+// key, is an error.
+func parseJWKS(data []byte) (map[string]publicKey, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %v", err)
+	}
+
+	keys := make(map[string]publicKey, len(doc.Keys))
+	for _, raw := range doc.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+		key, err := raw.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %v", raw.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		keys[raw.Kid] = publicKey{key: key, alg: raw.Alg}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no usable signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey decodes the key, or returns nil for a key we do not verify with
+func (j jwk) publicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("bad modulus: %v", err)
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("bad exponent")
+		}
+		if n.BitLen() < 2048 {
+			// Too weak to trust, but no reason to distrust the rest of the set
+			return nil, nil
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		var check ecdh.Curve
+		switch j.Crv {
+		case "P-256":
+			curve, check = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, check = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, check = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, nil
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("bad x coordinate: %v", err)
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("bad y coordinate: %v", err)
+		}
+		// Reject points that are not on the curve before trusting the key
+		size := (curve.Params().BitSize + 7) / 8
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		if x.BitLen() > size*8 || y.BitLen() > size*8 {
+			return nil, fmt.Errorf("coordinates too large for %s", j.Crv)
+		}
+		x.FillBytes(point[1 : 1+size])
+		y.FillBytes(point[1+size:])
+		if _, err := check.NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("point is not on %s", j.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("bad Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwkFor encodes the public half of key as a JWK
+func jwkFor(t *testing.T, kid string, key crypto.Signer) map[string]string {
+	t.Helper()
+	enc := base64.RawURLEncoding.EncodeToString
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": enc(pub.N.Bytes()), "e": enc(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": enc(pub.X.FillBytes(make([]byte, 32))), "y": enc(pub.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": enc(pub)}
+	}
+	t.Fatalf("unsupported key type %T", key)
+	return nil
+}
+
+func jwksJSON(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatalf("failed to encode JWKS: %v", err)
+	}
+	return data
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "user-1", "iss": "https://idp.example.com", "aud": "shop-api", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+// jwksServer serves whatever JWKS document is current and counts fetches
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	doc     []byte
+	fetches atomic.Int32
+}
+
+func newJWKSServer(t *testing.T, doc []byte) *jwksServer {
+	s := &jwksServer{doc: doc}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(s.doc)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) serve(doc []byte) {
+	s.mu.Lock()
+	s.doc = doc
+	s.mu.Unlock()
+}
+
+func TestValidateJWTWithJWKSURL(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	srv := newJWKSServer(t, jwksJSON(t, jwkFor(t, "old", oldKey)))
+
+	keys := NewKeySet(srv.URL, "", time.Hour)
+	keys.minRefetch = 0
+	cfg := AuthConfig{Keys: keys, Issuer: "https://idp.example.com", Audience: "shop-api"}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		claims, err := ValidateJWT(ctx, signToken(t, jwt.SigningMethodRS256, "old", oldKey, validClaims()), cfg)
+		if err != nil {
+			t.Fatalf("ValidateJWT failed: %v", err)
+		}
+		if claims["sub"] != "user-1" {
+			t.Errorf("got claims %v, want sub user-1", claims)
+		}
+	}
+	if n := srv.fetches.Load(); n != 1 {
+		t.Errorf("fetched the JWKS %d times, want once while it is fresh", n)
+	}
+
+	// The provider rotates to a new key: an unknown kid triggers a reload
+	srv.serve(jwksJSON(t, jwkFor(t, "new", newKey)))
+	if _, err := ValidateJWT(ctx, signToken(t, jwt.SigningMethodRS256, "new", newKey, validClaims()), cfg); err != nil {
+		t.Fatalf("token signed with the rotated key was rejected: %v", err)
+	}
+	if _, err := ValidateJWT(ctx, signToken(t, jwt.SigningMethodRS256, "old", oldKey, validClaims()), cfg); err == nil {
+		t.Error("token signed with the retired key was accepted")
+	}
+
+	rejected := map[string]jwt.MapClaims{
+		"wrong issuer":   {"sub": "user-1", "iss": "https://evil.example.com", "aud": "shop-api", "exp": time.Now().Add(time.Hour).Unix()},
+		"wrong audience": {"sub": "user-1", "iss": "https://idp.example.com", "aud": "other-api", "exp": time.Now().Add(time.Hour).Unix()},
+		"expired":        {"sub": "user-1", "iss": "https://idp.example.com", "aud": "shop-api", "exp": time.Now().Add(-time.Hour).Unix()},
+	}
+	for name, claims := range rejected {
+		if _, err := ValidateJWT(ctx, signToken(t, jwt.SigningMethodRS256, "new", newKey, claims), cfg); err == nil {
+			t.Errorf("%s: token was accepted", name)
+		}
+	}
+
+	// Without a shared secret, HMAC tokens must not be accepted at all
+	if _, err := ValidateJWT(ctx, signToken(t, jwt.SigningMethodHS256, "", []byte("guess"), validClaims()), cfg); err == nil {
+		t.Error("HS256 token accepted without a JWT secret")
+	}
+}
+
+func TestValidateJWTWithJWKSFile(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, jwksJSON(t, jwkFor(t, "ec", ecKey), jwkFor(t, "ed", edKey)), 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+
+	cfg := AuthConfig{JWTSecret: "shared-secret", Keys: NewKeySet("", file, time.Hour)}
+	ctx := context.Background()
+	tokens := map[string]string{
+		"ES256": signToken(t, jwt.SigningMethodES256, "ec", ecKey, validClaims()),
+		"EdDSA": signToken(t, jwt.SigningMethodEdDSA, "ed", edKey, validClaims()),
+		"HS256": signToken(t, jwt.SigningMethodHS256, "", []byte("shared-secret"), validClaims()),
+	}
+	for alg, token := range tokens {
+		if _, err := ValidateJWT(ctx, token, cfg); err != nil {
+			t.Errorf("%s token rejected: %v", alg, err)
+		}
+	}
+
+	// A kid naming a key of another type must not verify
+	if _, err := ValidateJWT(ctx, signToken(t, jwt.SigningMethodES256, "ed", ecKey, validClaims()), cfg); err == nil {
+		t.Error("ES256 token verified against an Ed25519 key")
+	}
+}
+
+func TestParseJWKSRejectsBadKeys(t *testing.T) {
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	if _, err := parseJWKS(jwksJSON(t, jwkFor(t, "small", small))); err == nil {
+		t.Error("accepted a 1024-bit RSA key")
+	}
+
+	// Keys we cannot use are skipped without losing the rest of the set
+	good, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	unsupported := []map[string]string{
+		jwkFor(t, "small", small),
+		{"kty": "OKP", "kid": "x25519", "crv": "X25519", "x": "AQ"},
+		{"kty": "EC", "kid": "secp256k1", "crv": "secp256k1", "x": "AQ", "y": "AQ"},
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		jwkFor(t, "good", good),
+	}
+	keys, err := parseJWKS(jwksJSON(t, unsupported...))
+	if err != nil {
+		t.Fatalf("parseJWKS failed: %v", err)
+	}
+	if _, ok := keys["good"]; !ok || len(keys) != 1 {
+		t.Errorf("got keys %v, want only the P-256 key", keys)
+	}
+
+	offCurve := map[string]string{"kty": "EC", "kid": "bad", "crv": "P-256", "x": "AQ", "y": "AQ"}
+	if _, err := parseJWKS(jwksJSON(t, offCurve)); err == nil {
+		t.Error("accepted an EC point that is not on the curve")
+	}
+
+	encryption := map[string]string{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQ", "e": "AQAB"}
+	if _, err := parseJWKS(jwksJSON(t, encryption)); err == nil {
+		t.Error("accepted a JWKS with only an encryption key")
+	}
+}
+
+func TestKeySetRefreshDoesNotBlockCachedKeys(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every fetch after the first hangs until released
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		w.Write(jwksJSON(t, jwkFor(t, "known", key)))
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	keys := NewKeySet(srv.URL, "", time.Hour)
+	ctx := context.Background()
+	if _, err := keys.Key(ctx, "known", "ES256"); err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+	// Allow exactly one more reload, however late a caller asks for it
+	keys.attemptedAt = time.Time{}
+
+	// Tokens with unknown kids all wait on one slow reload...
+	var waiting sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		waiting.Add(1)
+		go func() {
+			defer waiting.Done()
+			keys.Key(ctx, "unknown", "ES256")
+		}()
+	}
+	for fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// ...while tokens with a cached key are verified straight away
+	done := make(chan error, 1)
+	go func() {
+		_, err := keys.Key(ctx, "known", "ES256")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Key failed during a reload: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("a cached key waited for the reload")
+	}
+
+	release <- struct{}{}
+	waiting.Wait()
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("fetched the JWKS %d times, want the reloads to share one fetch", n)
+	}
+}
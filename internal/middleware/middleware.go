@@ -1,16 +1,53 @@
 package middleware
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// AuthConfig holds what AuthMiddleware needs to verify a request
+type AuthConfig struct {
+	JWTSecret   string  // Key our own HS256 tokens are signed with
+	Keys        *KeySet // Identity provider keys for RS256, ES256 and EdDSA tokens, nil to reject them
+	Issuer      string  // Required iss claim, if set
+	Audience    string  // Required aud claim, if set
+	NextAuthURL string  // Base URL of the Next.js app that verifies Auth.js sessions
+
+	// Sessions verifies Auth.js sessions with NextAuthURL. Share one between
+	// route groups so they share its cache; nil gets a default one.
+	Sessions *SessionVerifier
+
+	// Denylist refuses revoked tokens by their jti claim; nil accepts every
+	// valid token until it expires
+	Denylist Denylist
+}
+
+// Denylist reports whether a token has been revoked before it expired
+type Denylist interface {
+	IsRevoked(jti string) bool
+}
+
+// Signing algorithms accepted for each kind of key
+var (
+	hmacMethods       = []string{"HS256", "HS384", "HS512"}
+	asymmetricMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// Token types in the "typ" claim of the tokens issued for native accounts.
+// Only access tokens authenticate requests; refresh tokens are only good for
+// getting a new access token.
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
 )
 
 // UserInfo represents the user data returned from Next Auth verification
@@ -21,17 +58,22 @@ type UserInfo struct {
 	Role   string `json:"role"`
 }
 
-func AuthMiddleware() echo.MiddlewareFunc {
+func AuthMiddleware(cfg AuthConfig) echo.MiddlewareFunc {
+	if cfg.Sessions == nil {
+		cfg.Sessions = NewSessionVerifier(cfg.NextAuthURL, SessionCacheConfig{})
+	}
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			token := ""
 			isNextAuthToken := false
+			viaCookie := false
 
 			// Check for next-auth session cookie first
 			sessionCookie, err := c.Cookie("authjs.session-token")
 			if err == nil && sessionCookie.Value != "" {
 				token = sessionCookie.Value
 				isNextAuthToken = true
+				viaCookie = true
 			}
 
 			// If not found, check for our custom auth-token cookie
@@ -39,6 +81,7 @@ func AuthMiddleware() echo.MiddlewareFunc {
 				authCookie, err := c.Cookie("auth-token")
 				if err == nil && authCookie.Value != "" {
 					token = authCookie.Value
+					viaCookie = true
 				}
 			}
 
@@ -54,13 +97,20 @@ func AuthMiddleware() echo.MiddlewareFunc {
 			if token == "" {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 			}
+			// CSRFProtect checks requests the browser authenticated on its own
+			c.Set("authCookie", viaCookie)
 
 			// For Next Auth tokens, we need to handle them differently
 			if isNextAuthToken {
 				// Verify the Next Auth token by calling our Next.js API endpoint
-				userInfo, err := verifyNextAuthToken(c.Request().Header.Get("Cookie"))
+				userInfo, err := cfg.Sessions.Verify(c.Request().Context(), token, c.Request().Header.Get("Cookie"))
+				if errors.Is(err, ErrSessionUnavailable) {
+					// Not the shopper's fault: let them retry rather than sign in again
+					return echo.NewHTTPError(http.StatusServiceUnavailable, "Sessions cannot be verified right now").SetInternal(err)
+				}
 				if err != nil {
-					return echo.NewHTTPError(http.StatusUnauthorized, "Invalid Next Auth token: "+err.Error())
+					// The cause stays in the request log, not the response
+					return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired session").SetInternal(err)
 				}
 
 				// Set user information from Next Auth
@@ -73,9 +123,15 @@ func AuthMiddleware() echo.MiddlewareFunc {
 			}
 
 			// Validate token and extract claims
-			claims, err := ValidateJWT(token)
+			claims, err := ValidateJWT(c.Request().Context(), token, cfg)
 			if err != nil {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid Token: "+err.Error())
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired token").SetInternal(err)
+			}
+			if typ, _ := claims["typ"].(string); typ == TokenTypeRefresh {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired token").SetInternal(fmt.Errorf("refresh token used as an access token"))
+			}
+			if jti, _ := claims["jti"].(string); jti != "" && cfg.Denylist != nil && cfg.Denylist.IsRevoked(jti) {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Token has been revoked")
 			}
 
 			// For standard JWTs, we use the sub claim for userId
@@ -94,28 +150,53 @@ func AuthMiddleware() echo.MiddlewareFunc {
 			if role, ok := claims["role"].(string); ok {
 				c.Set("role", role)
 			}
+
+			// Native account tokens name the session they belong to
+			if sid, ok := claims["sid"].(string); ok {
+				c.Set("sessionId", sid)
+			}
 			// If no errors occurred, proceed to the next handler
 			return next(c)
 		}
 	}
 }
 
-// verifyNextAuthToken calls the Next.js API to verify a Next Auth token and get user info
+// OptionalAuth is AuthMiddleware for routes that also serve anonymous
+// requests: one without credentials, or with ones that are no longer valid,
+// goes on without a userId
+func OptionalAuth(cfg AuthConfig) echo.MiddlewareFunc {
+	auth := AuthMiddleware(cfg)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authenticated := false
+			err := auth(func(c echo.Context) error {
+				authenticated = true
+				return next(c)
+			})(c)
 
-func verifyNextAuthToken(cookies string) (*UserInfo, error) {
-	// Get the Next.js API URL from environment or use default
-	// load frontend URL from environment variable
-	if err := godotenv.Load(".env.local"); err != nil {
-		return nil, fmt.Errorf("error loading .env.local file: %v", err)
+			var httpErr *echo.HTTPError
+			if !authenticated && errors.As(err, &httpErr) && httpErr.Code == http.StatusUnauthorized {
+				c.Set("authCookie", false)
+				return next(c)
+			}
+			return err
+		}
 	}
+}
 
-	nextJsApiUrl := os.Getenv("NEXT_API_URL")
-	if nextJsApiUrl == "" {
-		nextJsApiUrl = "http://localhost:3000"
-	}
+// nextAuthClient calls the Next.js API. Its transport records a client span and
+// forwards the trace context, so session checks show up in the request's trace.
+var nextAuthClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: otelhttp.NewTransport(http.DefaultTransport, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return "Next.js " + r.Method + " " + r.URL.Path
+	})),
+}
 
+// verifyNextAuthToken calls the Next.js API to verify a Next Auth token and get user info
+func verifyNextAuthToken(ctx context.Context, nextJsApiUrl, cookies string) (*UserInfo, error) {
 	// Create the HTTP request
-	req, err := http.NewRequest("POST", nextJsApiUrl+"/api/auth/verify", nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", nextJsApiUrl+"/api/auth/verify", nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
@@ -126,14 +207,16 @@ func verifyNextAuthToken(cookies string) (*UserInfo, error) {
 	}
 
 	// Send the request
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := nextAuthClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error calling Next.js API: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// Check if the request was successful
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, ErrSessionInvalid
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("next.js API returned status: %d", resp.StatusCode)
 	}
@@ -143,30 +226,45 @@ func verifyNextAuthToken(cookies string) (*UserInfo, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
 		return nil, fmt.Errorf("error decoding response: %v", err)
 	}
+	if userInfo.UserId == "" {
+		return nil, ErrSessionInvalid
+	}
 
 	return &userInfo, nil
 }
 
-func ValidateJWT(token string) (map[string]any, error) {
-	// Get JWT secret from environment
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		// Fallback to auth secret if JWT_SECRET is not set
-		jwtSecret = os.Getenv("BETTER_AUTH_SECRET")
-		if jwtSecret == "" {
-			return nil, fmt.Errorf("JWT secret not configured")
-		}
+// ValidateJWT verifies a token and returns its claims. HMAC tokens are checked
+// against cfg.JWTSecret and asymmetric ones against the key named by their kid
+// in cfg.Keys; the issuer and audience are checked when cfg sets them.
+func ValidateJWT(ctx context.Context, token string, cfg AuthConfig) (map[string]any, error) {
+	var methods []string
+	if cfg.JWTSecret != "" {
+		methods = append(methods, hmacMethods...)
+	}
+	if cfg.Keys != nil {
+		methods = append(methods, asymmetricMethods...)
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no JWT secret or JWKS configured")
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods)}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
 	}
 
 	// Parse and validate the JWT token
 	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (any, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		// WithValidMethods has already rejected algorithms we have no key for
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			return []byte(cfg.JWTSecret), nil
 		}
-
-		return []byte(jwtSecret), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		return cfg.Keys.Key(ctx, kid, token.Method.Alg())
+	}, opts...)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %v", err)
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"github.com/joshuatakyi/shop/internal/logging"
+	"github.com/joshuatakyi/shop/internal/metrics"
+	"github.com/labstack/echo/v4"
+)
+
+// Rate limit decisions, as recorded in metrics
+const (
+	rateLimitAllowed = "allowed"
+	rateLimitLimited = "limited"
+	rateLimitError   = "error"
+)
+
+// RateLimitResult is the state of a bucket after taking a request from it
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int           // Requests left in the bucket
+	Reset      time.Duration // Until the bucket is full again
+	RetryAfter time.Duration // Until the next request is allowed, when this one was not
+}
+
+// RateLimitStore keeps the token buckets. MemoryRateLimitStore suits a single
+// instance; several instances should share a store, e.g. one backed by Redis,
+// or each of them lets a client through at the full rate.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, policy config.RateLimitPolicy, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimiter applies named token bucket policies to requests. Requests are
+// counted per signed-in user when AuthMiddleware has run before it, and per
+// client IP otherwise.
+type RateLimiter struct {
+	policies map[string]config.RateLimitPolicy
+	store    RateLimitStore
+	now      func() time.Time
+}
+
+// NewRateLimiter returns a limiter for the configured policies. A nil store
+// gets an in-memory one. When cfg is disabled, the middleware it returns lets
+// every request through.
+func NewRateLimiter(cfg config.RateLimitConfig, store RateLimitStore) *RateLimiter {
+	if !cfg.Enabled {
+		return &RateLimiter{}
+	}
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	return &RateLimiter{policies: cfg.Policies, store: store, now: time.Now}
+}
+
+// Limit returns middleware applying the named policy. Naming a policy that is
+// not configured turns it off for those routes.
+func (l *RateLimiter) Limit(name string) echo.MiddlewareFunc {
+	policy, ok := l.policies[name]
+	if !ok {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+	if policy.Burst == 0 {
+		policy.Burst = policy.Limit
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := name + ":" + rateLimitKey(c)
+			result, err := l.store.Take(c.Request().Context(), key, policy, l.now())
+			if err != nil {
+				// A store outage must not take the API down with it
+				metrics.RecordRateLimit(name, rateLimitError)
+				logging.FromContext(c.Request().Context()).Warn("Rate limit store failed, allowing request", "policy", name, "error", err)
+				return next(c)
+			}
+
+			header := c.Response().Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(policy.Burst))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d;policy=%q", policy.Limit, seconds(policy.Window), policy.Burst, name))
+			if !result.Allowed {
+				metrics.RecordRateLimit(name, rateLimitLimited)
+				header.Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+				return echo.NewHTTPError(http.StatusTooManyRequests, "Too many requests, try again later")
+			}
+			metrics.RecordRateLimit(name, rateLimitAllowed)
+			return next(c)
+		}
+	}
+}
+
+// rateLimitKey identifies who a request counts against
+func rateLimitKey(c echo.Context) string {
+	if userId, ok := c.Get("userId").(string); ok && userId != "" {
+		return "user:" + userId
+	}
+	return "ip:" + c.RealIP()
+}
+
+// seconds rounds d up to whole seconds, as the headers carry
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore keeps token buckets in process memory. Buckets that
+// have refilled are dropped on the next sweep, so idle clients cost nothing.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket is full again if nothing is taken
+}
+
+// sweepInterval is how often the memory store drops refilled buckets
+const sweepInterval = time.Minute
+
+// NewMemoryRateLimitStore returns an empty store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+// Take refills the key's bucket for the time since it was last used, then
+// takes a token from it if there is one
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, policy config.RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	capacity := float64(policy.Burst)
+	perToken := policy.Window / time.Duration(policy.Limit)
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		s.buckets[key] = bucket
+	}
+	if elapsed := now.Sub(bucket.updated); elapsed > 0 {
+		bucket.tokens = math.Min(capacity, bucket.tokens+float64(elapsed)/float64(perToken))
+		bucket.updated = now
+	}
+
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.tokens) * float64(perToken))
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = time.Duration((capacity - bucket.tokens) * float64(perToken))
+	bucket.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep drops the buckets that are full by now. Callers hold the lock.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.full) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
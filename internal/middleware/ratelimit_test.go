@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := config.RateLimitPolicy{Limit: 2, Window: 2 * time.Second, Burst: 3} // One token a second
+	now := time.Unix(1700000000, 0)
+	take := func(key string) RateLimitResult {
+		t.Helper()
+		result, err := store.Take(context.Background(), key, policy, now)
+		if err != nil {
+			t.Fatalf("Take failed: %v", err)
+		}
+		return result
+	}
+
+	for i, remaining := range []int{2, 1, 0} {
+		if result := take("a"); !result.Allowed || result.Remaining != remaining {
+			t.Fatalf("take %d: got %+v, want allowed with %d remaining", i+1, result, remaining)
+		}
+	}
+	result := take("a")
+	if result.Allowed || result.RetryAfter != time.Second || result.Reset != 3*time.Second {
+		t.Fatalf("got %+v, want refused for a second and full in three", result)
+	}
+	if result := take("b"); !result.Allowed {
+		t.Fatalf("another key was refused: %+v", result)
+	}
+
+	// The bucket refills at the policy's rate, up to the burst
+	now = now.Add(1500 * time.Millisecond)
+	if result := take("a"); !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("after 1.5s: got %+v, want allowed with none remaining", result)
+	}
+	now = now.Add(time.Hour)
+	if result := take("a"); !result.Allowed || result.Remaining != 2 {
+		t.Fatalf("after an hour: got %+v, want a full bucket", result)
+	}
+
+	// Refilled buckets are forgotten on the next sweep
+	now = now.Add(time.Hour)
+	take("c")
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.buckets["a"]; ok || len(store.buckets) != 1 {
+		t.Fatalf("got %d buckets after a sweep, want only the new one", len(store.buckets))
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/joshuatakyi/shop/internal/logging"
+	"github.com/labstack/echo/v4"
+)
+
+// Roles a session can carry in its "role" claim. Tokens issued before staff
+// roles existed say "user" for shoppers, which is read as RoleCustomer.
+const (
+	RoleAdmin    = "admin"
+	RoleStaff    = "staff"
+	RoleSupport  = "support"
+	RoleCustomer = "customer"
+)
+
+// Permission is one thing a role may do, named resource:action
+type Permission string
+
+const (
+	PermProductsWrite    Permission = "products:write"
+	PermProductsDelete   Permission = "products:delete"
+	PermCommentsRead     Permission = "comments:read"
+	PermCommentsModerate Permission = "comments:moderate"
+	PermCartsStats       Permission = "carts:stats"
+	PermOrdersRead       Permission = "orders:read"
+	PermOrdersRefund     Permission = "orders:refund"
+	PermUsersManage      Permission = "users:manage"
+)
+
+// rolePermissions is the permission matrix. Customers have no entry: shoppers
+// only ever act on their own cart, comments and payments, which the handlers
+// check by user ID.
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermProductsWrite, PermProductsDelete,
+		PermCommentsRead, PermCommentsModerate,
+		PermCartsStats,
+		PermOrdersRead, PermOrdersRefund,
+		PermUsersManage,
+	},
+	RoleStaff: {
+		PermProductsWrite,
+		PermCommentsRead, PermCommentsModerate,
+		PermCartsStats,
+		PermOrdersRead,
+	},
+	RoleSupport: {
+		PermCommentsRead, PermCommentsModerate,
+		PermOrdersRead, PermOrdersRefund,
+	},
+}
+
+// NormalizeRole maps a role claim to one of the declared roles. Missing and
+// unknown roles are treated as customers so they get no extra permissions.
+func NormalizeRole(role string) string {
+	switch role {
+	case RoleAdmin, RoleStaff, RoleSupport:
+		return role
+	default:
+		return RoleCustomer
+	}
+}
+
+// HasPermission reports whether role grants perm
+func HasPermission(role string, perm Permission) bool {
+	return slices.Contains(rolePermissions[NormalizeRole(role)], perm)
+}
+
+// Can reports whether the request's authenticated role grants perm
+func Can(c echo.Context, perm Permission) bool {
+	role, _ := c.Get("role").(string)
+	return HasPermission(role, perm)
+}
+
+// RequirePermission lets a request through only when its role grants every one
+// of perms. It must run after AuthMiddleware, which sets the role.
+func RequirePermission(perms ...Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if userId, _ := c.Get("userId").(string); userId == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+			}
+			role, _ := c.Get("role").(string)
+			for _, perm := range perms {
+				if !HasPermission(role, perm) {
+					logging.FromContext(c.Request().Context()).Warn("Permission denied", "role", role, "permission", perm, "route", c.Path())
+					return echo.NewHTTPError(http.StatusForbidden, "You do not have permission to perform this action")
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireRole lets a request through only when its role is one of roles. Prefer
+// RequirePermission so routes do not need changing when the matrix does.
+func RequireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if userId, _ := c.Get("userId").(string); userId == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+			}
+			role, _ := c.Get("role").(string)
+			if !slices.Contains(roles, NormalizeRole(role)) {
+				logging.FromContext(c.Request().Context()).Warn("Role denied", "role", role, "route", c.Path())
+				return echo.NewHTTPError(http.StatusForbidden, "You do not have permission to perform this action")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/logging"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxRequestIDLength caps request IDs accepted from clients and proxies
+const maxRequestIDLength = 128
+
+// RequestID gives every request an ID, reusing a well-formed X-Request-ID from
+// the client or a proxy so one ID follows the request across services. The ID
+// is echoed in the response header, stored in the Echo context under
+// "requestId" and attached to the request's logger as request_id, along with
+// trace_id when the request is traced.
+func RequestID(logger *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			requestID := c.Request().Header.Get(echo.HeaderXRequestID)
+			if !validRequestID(requestID) {
+				requestID = newRequestID()
+			}
+
+			c.Set("requestId", requestID)
+			c.Response().Header().Set(echo.HeaderXRequestID, requestID)
+
+			req := c.Request()
+			requestLogger := logger.With("request_id", requestID)
+			if span := trace.SpanContextFromContext(req.Context()); span.IsValid() {
+				requestLogger = requestLogger.With("trace_id", span.TraceID().String())
+			}
+			ctx := logging.WithLogger(req.Context(), requestLogger)
+			c.SetRequest(req.WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// RequestLogger logs one line per request once it has been handled, with the
+// route, status, latency and the user when the request was authenticated.
+// Server errors are logged at error level and client errors at warn.
+func RequestLogger() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// Let the error handler write the response so the status is final
+				c.Error(err)
+			}
+
+			req := c.Request()
+			status := c.Response().Status
+			attrs := []any{
+				"method", req.Method,
+				"route", c.Path(),
+				"path", req.URL.Path,
+				"status", status,
+				"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+				"bytes_out", c.Response().Size,
+				"remote_ip", c.RealIP(),
+			}
+			if userId, ok := c.Get("userId").(string); ok && userId != "" {
+				attrs = append(attrs, "userId", userId)
+			}
+			if err != nil {
+				attrs = append(attrs, "error", err.Error())
+			}
+
+			logger := logging.FromContext(req.Context())
+			switch {
+			case status >= 500:
+				logger.Error("Request failed", attrs...)
+			case status >= 400:
+				logger.Warn("Request rejected", attrs...)
+			default:
+				logger.Info("Request handled", attrs...)
+			}
+			return nil
+		}
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		// Keep IDs safe to echo in headers and log lines
+		if !(r == '-' || r == '_' || r == '.' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand does not fail on supported platforms; fall back to the clock
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/metrics"
+	"golang.org/x/sync/singleflight"
+)
+
+// Errors returned by SessionVerifier.Verify. ErrSessionInvalid means Next.js
+// rejected the session; ErrSessionUnavailable means it could not be asked.
+var (
+	ErrSessionInvalid     = errors.New("session is invalid or expired")
+	ErrSessionUnavailable = errors.New("session verification is unavailable")
+)
+
+// Session cache results and verification outcomes, as recorded in metrics
+const (
+	sessionHit    = "hit"
+	sessionMiss   = "miss"
+	sessionShared = "shared"
+
+	sessionValid       = "valid"
+	sessionInvalid     = "invalid"
+	sessionError       = "error"
+	sessionCircuitOpen = "circuit_open"
+)
+
+// SessionCacheConfig tunes SessionVerifier. Zero values pick the defaults.
+type SessionCacheConfig struct {
+	TTL              time.Duration // How long a verified session is trusted, default 30s
+	Size             int           // Most sessions kept, default 10000
+	FailureThreshold int           // Consecutive failures that open the circuit, default 5
+	Cooldown         time.Duration // How long the circuit stays open before a retry, default 30s
+}
+
+// SessionVerifier verifies Auth.js sessions with the Next.js API. Verified
+// sessions are cached for a short TTL under a hash of their token, concurrent
+// requests with the same token share one call, and a circuit breaker fails
+// fast while Next.js is down instead of making every request wait for the timeout.
+type SessionVerifier struct {
+	url    string
+	verify func(ctx context.Context, url, cookies string) (*UserInfo, error)
+	cfg    SessionCacheConfig
+	now    func() time.Time
+
+	cache   *sessionCache
+	flights singleflight.Group
+
+	mu        sync.Mutex
+	failures  int       // consecutive failed calls
+	openUntil time.Time // the circuit is open until then
+	probing   bool      // a call is testing whether Next.js is back
+}
+
+// NewSessionVerifier returns a verifier calling the Next.js app at url
+func NewSessionVerifier(url string, cfg SessionCacheConfig) *SessionVerifier {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 30 * time.Second
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = 10000
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	return &SessionVerifier{
+		url:    url,
+		verify: verifyNextAuthToken,
+		cfg:    cfg,
+		now:    time.Now,
+		cache:  newSessionCache(cfg.Size),
+	}
+}
+
+// Verify returns the user of the session identified by token. cookies is the
+// request's Cookie header, which Next.js needs to read the session.
+func (v *SessionVerifier) Verify(ctx context.Context, token, cookies string) (*UserInfo, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	if info, ok := v.cache.get(key, v.now()); ok {
+		metrics.RecordSessionCache(sessionHit)
+		return info, nil
+	}
+
+	result, err, shared := v.flights.Do(key, func() (any, error) {
+		// The caller's cancellation must not fail the other requests waiting on this call
+		info, err := v.call(context.WithoutCancel(ctx), cookies)
+		if err != nil {
+			return nil, err
+		}
+		v.cache.add(key, info, v.now().Add(v.cfg.TTL))
+		return info, nil
+	})
+	if shared {
+		metrics.RecordSessionCache(sessionShared)
+	} else {
+		metrics.RecordSessionCache(sessionMiss)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := *result.(*UserInfo)
+	return &info, nil
+}
+
+// call verifies the session with Next.js unless the circuit is open
+func (v *SessionVerifier) call(ctx context.Context, cookies string) (*UserInfo, error) {
+	if !v.allow() {
+		metrics.RecordSessionVerification(sessionCircuitOpen)
+		return nil, fmt.Errorf("%w: circuit open after repeated failures", ErrSessionUnavailable)
+	}
+
+	info, err := v.verify(ctx, v.url, cookies)
+	switch {
+	case err == nil:
+		metrics.RecordSessionVerification(sessionValid)
+		v.record(true)
+		return info, nil
+	case errors.Is(err, ErrSessionInvalid):
+		// Next.js answered, so it is healthy even though the session is not
+		metrics.RecordSessionVerification(sessionInvalid)
+		v.record(true)
+		return nil, err
+	default:
+		metrics.RecordSessionVerification(sessionError)
+		v.record(false)
+		return nil, fmt.Errorf("%w: %v", ErrSessionUnavailable, err)
+	}
+}
+
+// allow reports whether a call may go to Next.js. Once the cooldown is over a
+// single call is let through to probe it; the rest keep failing fast.
+func (v *SessionVerifier) allow() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.openUntil.IsZero() {
+		return true
+	}
+	if v.now().Before(v.openUntil) || v.probing {
+		return false
+	}
+	v.probing = true
+	return true
+}
+
+// record updates the circuit with the result of a call
+func (v *SessionVerifier) record(ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.probing = false
+	if ok {
+		v.failures = 0
+		v.openUntil = time.Time{}
+		metrics.SetSessionCircuitOpen(false)
+		return
+	}
+	v.failures++
+	if v.failures >= v.cfg.FailureThreshold {
+		v.openUntil = v.now().Add(v.cfg.Cooldown)
+		metrics.SetSessionCircuitOpen(true)
+	}
+}
+
+// sessionCache is a size-bounded LRU of verified sessions with per-entry expiry
+type sessionCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+type sessionEntry struct {
+	key     string
+	info    *UserInfo
+	expires time.Time
+}
+
+func newSessionCache(size int) *sessionCache {
+	return &sessionCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *sessionCache) get(key string, now time.Time) (*UserInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*sessionEntry)
+	if !now.Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	info := *entry.info
+	return &info, true
+}
+
+func (c *sessionCache) add(key string, info *UserInfo, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &sessionEntry{key: key, info: info, expires: expires}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&sessionEntry{key: key, info: info, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*sessionEntry).key)
+	}
+}
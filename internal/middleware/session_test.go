@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// fakeSessions answers verifications for the user named by the cookie, or with
+// err, and counts the calls
+type fakeSessions struct {
+	calls atomic.Int32
+	mu    sync.Mutex
+	err   error
+	gate  chan struct{} // when set, calls wait for it to close
+}
+
+func (f *fakeSessions) verify(_ context.Context, _, cookies string) (*UserInfo, error) {
+	f.calls.Add(1)
+	if f.gate != nil {
+		<-f.gate
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &UserInfo{UserId: "user-for-" + cookies, Role: "user"}, nil
+}
+
+func (f *fakeSessions) fail(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
+
+// newTestVerifier returns a verifier backed by fake with a clock the test moves
+func newTestVerifier(cfg SessionCacheConfig) (*SessionVerifier, *fakeSessions, *time.Time) {
+	fake := &fakeSessions{}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewSessionVerifier("http://next.test", cfg)
+	v.verify = fake.verify
+	v.now = func() time.Time { return now }
+	return v, fake, &now
+}
+
+func TestSessionVerifierCaches(t *testing.T) {
+	v, fake, now := newTestVerifier(SessionCacheConfig{TTL: time.Minute})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		info, err := v.Verify(ctx, "token-a", "a")
+		if err != nil || info.UserId != "user-for-a" {
+			t.Fatalf("got %+v, %v, want the user of session a", info, err)
+		}
+	}
+	if n := fake.calls.Load(); n != 1 {
+		t.Errorf("Next.js was called %d times, want once while the session is cached", n)
+	}
+
+	*now = now.Add(time.Minute)
+	if _, err := v.Verify(ctx, "token-a", "a"); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if n := fake.calls.Load(); n != 2 {
+		t.Errorf("Next.js was called %d times, want a new call once the entry expired", n)
+	}
+
+	// Rejected sessions are not cached
+	fake.fail(ErrSessionInvalid)
+	for i := 0; i < 2; i++ {
+		if _, err := v.Verify(ctx, "token-b", "b"); !errors.Is(err, ErrSessionInvalid) {
+			t.Fatalf("got error %v, want ErrSessionInvalid", err)
+		}
+	}
+	if n := fake.calls.Load(); n != 4 {
+		t.Errorf("Next.js was called %d times, want every rejected session checked again", n)
+	}
+}
+
+func TestSessionVerifierSharesConcurrentCalls(t *testing.T) {
+	v, fake, _ := newTestVerifier(SessionCacheConfig{})
+	fake.gate = make(chan struct{})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := v.Verify(context.Background(), "token-a", "a")
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(fake.gate)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+	}
+	if n := fake.calls.Load(); n != 1 {
+		t.Errorf("Next.js was called %d times for one session, want once", n)
+	}
+}
+
+func TestSessionVerifierCircuitBreaker(t *testing.T) {
+	v, fake, now := newTestVerifier(SessionCacheConfig{FailureThreshold: 2, Cooldown: 30 * time.Second})
+	ctx := context.Background()
+
+	fake.fail(errors.New("connection refused"))
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(ctx, "token-a", "a"); !errors.Is(err, ErrSessionUnavailable) {
+			t.Fatalf("got error %v, want ErrSessionUnavailable", err)
+		}
+	}
+	if n := fake.calls.Load(); n != 2 {
+		t.Errorf("Next.js was called %d times, want the open circuit to stop the third call", n)
+	}
+
+	// After the cooldown one probe goes through and closes the circuit
+	fake.fail(nil)
+	*now = now.Add(31 * time.Second)
+	if _, err := v.Verify(ctx, "token-a", "a"); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if _, err := v.Verify(ctx, "token-b", "b"); err != nil {
+		t.Fatalf("Verify failed after the circuit closed: %v", err)
+	}
+	if n := fake.calls.Load(); n != 4 {
+		t.Errorf("Next.js was called %d times, want 4", n)
+	}
+}
+
+func TestSessionCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	v, fake, _ := newTestVerifier(SessionCacheConfig{Size: 2})
+	ctx := context.Background()
+
+	for _, s := range []string{"a", "b", "a", "c", "a", "b"} {
+		if _, err := v.Verify(ctx, "token-"+s, s); err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+	}
+	// a, b miss; a hits; c evicts b; a hits; b misses again
+	if n := fake.calls.Load(); n != 4 {
+		t.Errorf("Next.js was called %d times, want 4", n)
+	}
+}
+
+func TestAuthMiddlewareSessionUnavailable(t *testing.T) {
+	v, fake, _ := newTestVerifier(SessionCacheConfig{})
+	fake.fail(errors.New("connection refused"))
+
+	e := echo.New()
+	handler := AuthMiddleware(AuthConfig{JWTSecret: "secret", Sessions: v})(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "authjs.session-token", Value: "token-a"})
+	err := handler(e.NewContext(req, httptest.NewRecorder()))
+
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want 503 while Next.js is down", err)
+	}
+}
@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// normalizeCartFieldNames moves the cart documents onto snake_case field names.
+// Carts were written with createdat, items.productid, items.totalprice and
+// totalamount. Some old documents carry totalamount alongside total_amount, in
+// which case total_amount is kept.
+var normalizeCartFieldNames = Migration{
+	Version: 1,
+	Name:    "normalize_cart_field_names",
+	Up: func(ctx context.Context, db *mongo.Database, collections config.CollectionNames) error {
+		collectionRef := db.Collection(collections.Carts)
+
+		if err := renameFields(ctx, collectionRef, map[string]string{"createdat": "created_at"}); err != nil {
+			return err
+		}
+		if err := renameArrayFields(ctx, collectionRef, "items", map[string]string{
+			"productid":  "product_id",
+			"totalprice": "total_price",
+		}); err != nil {
+			return err
+		}
+
+		// Carts with only the legacy total have it moved to total_amount, and
+		// where both exist the legacy one is dropped
+		_, err := collectionRef.UpdateMany(ctx,
+			bson.M{"totalamount": bson.M{"$exists": true}, "total_amount": bson.M{"$exists": false}},
+			bson.M{"$rename": bson.M{"totalamount": "total_amount"}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to move totalamount to total_amount: %v", err)
+		}
+		_, err = collectionRef.UpdateMany(ctx,
+			bson.M{"totalamount": bson.M{"$exists": true}},
+			bson.M{"$unset": bson.M{"totalamount": ""}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to remove totalamount: %v", err)
+		}
+		return nil
+	},
+	Down: func(ctx context.Context, db *mongo.Database, collections config.CollectionNames) error {
+		collectionRef := db.Collection(collections.Carts)
+
+		if err := renameFields(ctx, collectionRef, map[string]string{"created_at": "createdat"}); err != nil {
+			return err
+		}
+		return renameArrayFields(ctx, collectionRef, "items", map[string]string{
+			"product_id":  "productid",
+			"total_price": "totalprice",
+		})
+	},
+}
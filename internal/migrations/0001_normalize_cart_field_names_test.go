@@ -0,0 +1,106 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Like the repository tests, this runs against the MongoDB named by
+// MONGODB_URI in a database of its own, and is skipped when it is not set.
+func newTestDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		t.Skip("MONGODB_URI is not set, skipping MongoDB integration test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("failed to connect to MongoDB: %v", err)
+	}
+	db := client.Database("shop_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := db.Drop(ctx); err != nil {
+			t.Errorf("failed to drop test database %s: %v", db.Name(), err)
+		}
+		client.Disconnect(ctx)
+	})
+	return db
+}
+
+func TestNormalizeCartFieldNames(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	collections := config.DefaultCollectionNames()
+	carts := db.Collection(collections.Carts)
+
+	productID := primitive.NewObjectID()
+	_, err := carts.InsertMany(ctx, []any{
+		bson.M{"_id": "legacy", "createdat": "then", "totalamount": 40.0, "items": bson.A{
+			bson.M{"productid": productID, "totalprice": 40.0, "quantity": 2},
+			bson.M{"name": "no product fields", "quantity": 1},
+		}},
+		bson.M{"_id": "both totals", "total_amount": 10.0, "totalamount": 99.0, "items": bson.A{}},
+	})
+	if err != nil {
+		t.Fatalf("failed to insert carts: %v", err)
+	}
+
+	item := func(id string, i int) bson.M {
+		t.Helper()
+		var cart struct {
+			Items []bson.M `bson:"items"`
+		}
+		if err := carts.FindOne(ctx, bson.M{"_id": id}).Decode(&cart); err != nil {
+			t.Fatalf("failed to read cart %s: %v", id, err)
+		}
+		return cart.Items[i]
+	}
+	total := func(id string) bson.M {
+		t.Helper()
+		var cart bson.M
+		if err := carts.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"total_amount": 1, "totalamount": 1})).Decode(&cart); err != nil {
+			t.Fatalf("failed to read cart %s: %v", id, err)
+		}
+		delete(cart, "_id")
+		return cart
+	}
+
+	if err := normalizeCartFieldNames.Up(ctx, db, collections); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if got := item("legacy", 0); got["product_id"] != productID || got["total_price"] != 40.0 || got["productid"] != nil {
+		t.Errorf("got item %v, want its fields renamed", got)
+	}
+	if got := item("legacy", 1); len(got) != 2 {
+		t.Errorf("got item %v, want no null fields added", got)
+	}
+	if got := total("legacy"); len(got) != 1 || got["total_amount"] != 40.0 {
+		t.Errorf("got totals %v, want the legacy total moved to total_amount", got)
+	}
+	if got := total("both totals"); len(got) != 1 || got["total_amount"] != 10.0 {
+		t.Errorf("got totals %v, want total_amount kept and totalamount dropped", got)
+	}
+
+	if err := normalizeCartFieldNames.Down(ctx, db, collections); err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if got := item("legacy", 0); got["productid"] != productID || got["product_id"] != nil {
+		t.Errorf("got item %v, want its fields renamed back", got)
+	}
+	if got := item("legacy", 1); len(got) != 2 {
+		t.Errorf("got item %v, want no null fields added", got)
+	}
+}
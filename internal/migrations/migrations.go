@@ -0,0 +1,214 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migration is a single versioned schema change. Up applies it and Down reverts
+// it. Both resolve collections through the configured names rather than literals.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database, collections config.CollectionNames) error
+	Down    func(ctx context.Context, db *mongo.Database, collections config.CollectionNames) error
+}
+
+// Record is the document stored in the migrations collection for an applied migration
+type Record struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+}
+
+// Status describes a known migration and whether it has been applied
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// All returns every migration in version order. New migrations are appended here.
+func All() []Migration {
+	migrations := []Migration{
+		normalizeCartFieldNames,
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations
+}
+
+// Runner applies and reverts migrations against one database. Applied
+// migrations are recorded in the collections.Migrations collection.
+type Runner struct {
+	db          *mongo.Database
+	collections config.CollectionNames
+	migrations  []Migration
+}
+
+func NewRunner(db *mongo.Database, collections config.CollectionNames, migrations []Migration) (*Runner, error) {
+	seen := map[int]bool{}
+	for _, migration := range migrations {
+		if migration.Version <= 0 {
+			return nil, fmt.Errorf("migration %q has invalid version %d", migration.Name, migration.Version)
+		}
+		if seen[migration.Version] {
+			return nil, fmt.Errorf("duplicate migration version %d", migration.Version)
+		}
+		if migration.Up == nil || migration.Down == nil {
+			return nil, fmt.Errorf("migration %d (%s) must define both Up and Down", migration.Version, migration.Name)
+		}
+		seen[migration.Version] = true
+	}
+	return &Runner{db: db, collections: collections, migrations: migrations}, nil
+}
+
+// applied returns the applied migration records keyed by version
+func (r *Runner) applied(ctx context.Context) (map[int]Record, error) {
+	cursor, err := r.db.Collection(r.collections.Migrations).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode applied migrations: %v", err)
+	}
+	applied := make(map[int]Record, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// Status lists every known migration with its applied state
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(r.migrations))
+	for _, migration := range r.migrations {
+		record, ok := applied[migration.Version]
+		statuses = append(statuses, Status{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// Up applies pending migrations in order up to and including target.
+// A target of 0 applies everything. It returns the migrations that ran.
+func (r *Runner) Up(ctx context.Context, target int) ([]Migration, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, migration := range r.migrations {
+		if target > 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := migration.Up(ctx, r.db, r.collections); err != nil {
+			return ran, fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+		record := Record{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+		if _, err := r.db.Collection(r.collections.Migrations).InsertOne(ctx, record); err != nil {
+			return ran, fmt.Errorf("migration %d (%s) ran but could not be recorded: %v", migration.Version, migration.Name, err)
+		}
+		ran = append(ran, migration)
+	}
+	return ran, nil
+}
+
+// Down reverts the most recently applied migrations, newest first, up to steps of them
+func (r *Runner) Down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(r.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration := r.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err := migration.Down(ctx, r.db, r.collections); err != nil {
+			return reverted, fmt.Errorf("reverting migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+		if _, err := r.db.Collection(r.collections.Migrations).DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+			return reverted, fmt.Errorf("migration %d (%s) reverted but its record could not be removed: %v", migration.Version, migration.Name, err)
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+// renameFields renames top-level fields on every document that still has the old name
+func renameFields(ctx context.Context, collectionRef *mongo.Collection, renames map[string]string) error {
+	for from, to := range renames {
+		_, err := collectionRef.UpdateMany(ctx,
+			bson.M{from: bson.M{"$exists": true}},
+			bson.M{"$rename": bson.M{from: to}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %v", from, to, err)
+		}
+	}
+	return nil
+}
+
+// renameArrayFields renames fields inside every element of an array field. $rename
+// cannot reach into arrays, so this uses an aggregation pipeline update that
+// rebuilds each element: the old keys are always dropped, and the new key takes
+// the value already under the new name, or else the one under the old name.
+// When neither holds a non-null value the new key is left out, rather than
+// given a null.
+func renameArrayFields(ctx context.Context, collectionRef *mongo.Collection, arrayField string, renames map[string]string) error {
+	oldKeys := bson.A{}
+	renamed := bson.M{}
+	for from, to := range renames {
+		oldKeys = append(oldKeys, from)
+		// Prefer a value already stored under the new name
+		renamed[to] = bson.M{"$ifNull": bson.A{"$$item." + to, bson.M{"$ifNull": bson.A{"$$item." + from, "$$REMOVE"}}}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			arrayField: bson.M{"$map": bson.M{
+				"input": "$" + arrayField,
+				"as":    "item",
+				"in": bson.M{"$mergeObjects": bson.A{
+					bson.M{"$arrayToObject": bson.M{"$filter": bson.M{
+						"input": bson.M{"$objectToArray": "$$item"},
+						"as":    "field",
+						"cond":  bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$$field.k", oldKeys}}}},
+					}}},
+					renamed,
+				}},
+			}},
+		}}},
+	}
+
+	_, err := collectionRef.UpdateMany(ctx,
+		bson.M{arrayField + ".0": bson.M{"$exists": true}},
+		pipeline,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rename fields in %s: %v", arrayField, err)
+	}
+	return nil
+}
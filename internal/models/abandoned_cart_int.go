@@ -0,0 +1,165 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FindAbandonedCarts returns carts that have not been touched since idleSince,
+// have a contact email, were never checked out and have not been reminded since
+// remindedBefore. Items whose product is gone or out of stock are left out and
+// carts with nothing left to buy are skipped.
+func (m *MongoClient) FindAbandonedCarts(ctx context.Context, idleSince, remindedBefore time.Time, limit int64) ([]Cart, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	dbRef := m.database()
+	filter := bson.M{
+		"updated_at":     bson.M{"$lt": idleSince},
+		"items.0":        bson.M{"$exists": true},
+		"email":          bson.M{"$exists": true, "$ne": ""},
+		"checked_out_at": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"reminder_sent_at": bson.M{"$exists": false}},
+			{"reminder_sent_at": bson.M{"$lt": remindedBefore}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}}).SetLimit(limit)
+
+	cursor, err := dbRef.Collection(m.collections.Carts).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find abandoned carts: %v", err)
+	}
+	var carts []Cart
+	if err := cursor.All(ctx, &carts); err != nil {
+		return nil, fmt.Errorf("failed to decode abandoned carts: %v", err)
+	}
+
+	// Look up stock for every product across all carts in one query
+	seen := map[primitive.ObjectID]bool{}
+	productIDs := []primitive.ObjectID{}
+	for _, cart := range carts {
+		for _, item := range cart.Items {
+			if !seen[item.ProductID] {
+				seen[item.ProductID] = true
+				productIDs = append(productIDs, item.ProductID)
+			}
+		}
+	}
+	stock := map[primitive.ObjectID]int{}
+	if len(productIDs) > 0 {
+		cursor, err := dbRef.Collection(m.collections.Products).Find(ctx,
+			bson.M{"_id": bson.M{"$in": productIDs}, "is_available": true},
+			options.Find().SetProjection(bson.M{"stock": 1}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find cart products: %v", err)
+		}
+		var products []Product
+		if err := cursor.All(ctx, &products); err != nil {
+			return nil, fmt.Errorf("failed to decode cart products: %v", err)
+		}
+		for _, product := range products {
+			stock[product.ID] = product.Stock
+		}
+	}
+
+	abandoned := make([]Cart, 0, len(carts))
+	for _, cart := range carts {
+		inStock := cart.Items[:0]
+		totalAmount := 0.0
+		for _, item := range cart.Items {
+			if stock[item.ProductID] > 0 {
+				inStock = append(inStock, item)
+				totalAmount += item.TotalPrice
+			}
+		}
+		if len(inStock) == 0 {
+			continue
+		}
+		cart.Items = inStock
+		cart.TotalAmount = math.Round(totalAmount*100) / 100
+		abandoned = append(abandoned, cart)
+	}
+
+	return abandoned, nil
+}
+
+// RecordCartEvent stores an audit event for a cart
+func (m *MongoClient) RecordCartEvent(ctx context.Context, event CartEvent) error {
+	if m.client == nil {
+		return fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	_, err := m.database().Collection(m.collections.CartEvents).InsertOne(ctx, event)
+	if err != nil {
+		return fmt.Errorf("failed to record cart event: %v", err)
+	}
+	return nil
+}
+
+// MarkCartReminderSent stamps the time a reminder went out so the cart is skipped until the window passes
+func (m *MongoClient) MarkCartReminderSent(ctx context.Context, cartID primitive.ObjectID, sentAt time.Time) error {
+	if m.client == nil {
+		return fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	_, err := m.database().Collection(m.collections.Carts).UpdateOne(ctx,
+		bson.M{"_id": cartID},
+		bson.M{"$set": bson.M{"reminder_sent_at": sentAt}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark reminder sent: %v", err)
+	}
+	return nil
+}
+
+// SetCartEmail records where abandoned cart reminders for the user's cart should go
+func (m *MongoClient) SetCartEmail(ctx context.Context, userID primitive.ObjectID, email string) error {
+	if m.client == nil {
+		return fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	_, err := m.database().Collection(m.collections.Carts).UpdateOne(ctx,
+		userCartOwner(userID).filter(),
+		bson.M{"$set": bson.M{"email": email}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set cart email: %v", err)
+	}
+	return nil
+}
+
+// MarkCartCheckedOut flags the user's cart as paid for by the given payment so
+// no more reminders are sent for it. It reports false when the cart was
+// already checked out by that payment, so a payment is only counted once.
+func (m *MongoClient) MarkCartCheckedOut(ctx context.Context, userID primitive.ObjectID, reference string) (bool, error) {
+	if m.client == nil {
+		return false, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	filter := userCartOwner(userID).filter()
+	filter["paid_reference"] = bson.M{"$ne": reference}
+	result, err := m.database().Collection(m.collections.Carts).UpdateOne(ctx,
+		filter,
+		bson.M{"$set": bson.M{"checked_out_at": time.Now(), "paid_reference": reference}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark cart checked out: %v", err)
+	}
+	return result.MatchedCount > 0, nil
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// postalFormat is how a country writes its postal codes
+type postalFormat struct {
+	pattern  *regexp.Regexp // nil when the country has no postal codes
+	optional bool           // Many addresses are written without one
+}
+
+// postalFormats covers the countries we ship to most. Codes are matched after
+// being upper-cased and trimmed.
+var postalFormats = map[string]postalFormat{
+	"NG": {pattern: regexp.MustCompile(`^\d{6}$`), optional: true},
+	"GH": {pattern: regexp.MustCompile(`^[A-Z]{2}-?\d{3,4}-?\d{3,4}$`), optional: true}, // GhanaPost GPS digital address
+	"KE": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	"ZA": {pattern: regexp.MustCompile(`^\d{4}$`)},
+	"CI": {optional: true},
+	"AE": {optional: true},
+	"US": {pattern: regexp.MustCompile(`^\d{5}(-\d{4})?$`)},
+	"CA": {pattern: regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`)},
+	"GB": {pattern: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`)},
+	"DE": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	"FR": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	"NL": {pattern: regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`)},
+	"IN": {pattern: regexp.MustCompile(`^\d{6}$`)},
+}
+
+// anyPostalCode is the loose check for countries not in postalFormats
+var anyPostalCode = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{1,10}$`)
+
+// NormalizeAddress trims every field and upper-cases the country and postal
+// code, so the same address is always stored the same way
+func NormalizeAddress(a *Address) {
+	a.Type = strings.ToLower(strings.TrimSpace(a.Type))
+	a.AddressLine1 = strings.TrimSpace(a.AddressLine1)
+	a.AddressLine2 = strings.TrimSpace(a.AddressLine2)
+	a.City = strings.TrimSpace(a.City)
+	a.State = strings.TrimSpace(a.State)
+	a.PostalCode = strings.ToUpper(strings.TrimSpace(a.PostalCode))
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+}
+
+// ValidateAddress normalizes the address, then checks its fields and that the
+// postal code is in the format of its country
+func ValidateAddress(a *Address) error {
+	NormalizeAddress(a)
+	if err := Validate(a); err != nil {
+		return err
+	}
+
+	format, known := postalFormats[a.Country]
+	switch {
+	case a.PostalCode == "" && known && (format.optional || format.pattern == nil):
+		return nil
+	case a.PostalCode == "":
+		return InvalidField("postal_code", "required", "postal_code is required")
+	case !known && !anyPostalCode.MatchString(a.PostalCode):
+		return InvalidField("postal_code", "postal_code", "postal_code is not a valid postal code")
+	case known && format.pattern != nil && !format.pattern.MatchString(a.PostalCode):
+		return InvalidField("postal_code", "postal_code", fmt.Sprintf("postal_code is not a valid postal code for %s", a.Country))
+	}
+	return nil
+}
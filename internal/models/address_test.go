@@ -0,0 +1,41 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateAddress(t *testing.T) {
+	cases := []struct {
+		country, postalCode string
+		valid               bool
+	}{
+		{"US", "78701", true},
+		{"US", "78701-1234", true},
+		{"US", "7870", false},
+		{"GB", "sw1a 1aa", true},
+		{"GB", "12345", false},
+		{"CA", "K1A 0B1", true},
+		{"NL", "1012AB", true},
+		{"KE", "00100", true},
+		{"KE", "", false},
+		{"NG", "", true}, // Optional in Nigeria
+		{"NG", "10100", false},
+		{"GH", "GA-123-4567", true},
+		{"AE", "", true}, // No postal codes
+		{"BR", "01310-100", true},
+		{"BR", "", false},
+		{"BR", "#1", false},
+		{"XX", "12345", false}, // Not a country
+	}
+	for _, tc := range cases {
+		address := Address{Type: "home", AddressLine1: "1 Main St", City: "City", State: "State", Country: tc.country, PostalCode: tc.postalCode}
+		err := ValidateAddress(&address)
+		if tc.valid && err != nil {
+			t.Errorf("%s %q: got error %v, want valid", tc.country, tc.postalCode, err)
+		}
+		if !tc.valid && !errors.Is(err, ErrValidation) {
+			t.Errorf("%s %q: got error %v, want ErrValidation", tc.country, tc.postalCode, err)
+		}
+	}
+}
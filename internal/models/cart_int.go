@@ -6,36 +6,83 @@ import (
 	"math"
 	"time"
 
-	"github.com/joshuatakyi/shop/internal"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// cartOwner identifies whose cart an operation targets: either an
+// authenticated user or an anonymous guest identified by the signed cart cookie.
+type cartOwner struct {
+	userID  primitive.ObjectID
+	guestID string
+}
+
+func userCartOwner(userID primitive.ObjectID) cartOwner {
+	return cartOwner{userID: userID}
+}
+
+func guestCartOwner(guestID string) cartOwner {
+	return cartOwner{guestID: guestID}
+}
+
+// filter returns the query that selects the owner's cart document
+func (o cartOwner) filter() bson.M {
+	if o.guestID != "" {
+		return bson.M{"guest_id": o.guestID}
+	}
+	return bson.M{"user_id": o.userID}
+}
+
+// newCart returns an empty cart stamped with the owner's identity
+func (o cartOwner) newCart() Cart {
+	now := time.Now()
+	return Cart{
+		ID:        primitive.NewObjectID(),
+		UserID:    o.userID,
+		GuestID:   o.guestID,
+		Items:     []CartItem{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
 func (m *MongoClient) AddToCart(ctx context.Context, userID primitive.ObjectID, item CartItem) error {
+	return m.addToCart(ctx, userCartOwner(userID), item)
+}
+
+// AddToGuestCart adds an item to an anonymous shopper's cart
+func (m *MongoClient) AddToGuestCart(ctx context.Context, guestID string, item CartItem) error {
+	return m.addToCart(ctx, guestCartOwner(guestID), item)
+}
+
+func (m *MongoClient) addToCart(ctx context.Context, owner cartOwner, item CartItem) error {
 	if m.client == nil {
 		return fmt.Errorf("MongoDB client is not initialized %v", m.client)
 	}
-	dbRef := m.client.Database(internal.DbName)
-	cartColRef := dbRef.Collection(internal.CartCollection)
-	if err := validate.Struct(item); err != nil {
-		return fmt.Errorf("validation error: %v", err)
+	dbRef := m.database()
+	cartColRef := dbRef.Collection(m.collections.Carts)
+	if err := Validate(item); err != nil {
+		return err
 	}
 
 	// check if product exists
-	productDb := dbRef.Collection(internal.ProductCollection)
+	productDb := dbRef.Collection(m.collections.Products)
 	var product Product
 	err := productDb.FindOne(ctx, bson.M{"_id": item.ProductID}).Decode(&product)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return NotFound("Product not found")
+		}
 		return fmt.Errorf("failed to find product: %v", err)
 	}
 
 	// check if quantity is greater than 0 and enough stock
 	if item.Quantity <= 0 {
-		return fmt.Errorf("quantity must be greater than 0")
+		return InvalidField("quantity", "min", "quantity must be at least 1")
 	}
-	if item.Quantity > product.Stock {
-		return fmt.Errorf("not enough stock, requested: %d, available: %d", item.Quantity, product.Stock)
+	if err := CheckStock(product, item.Quantity); err != nil {
+		return err
 	}
 
 	// Calculate item's price and total price
@@ -75,19 +122,14 @@ func (m *MongoClient) AddToCart(ctx context.Context, userID primitive.ObjectID,
 	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
 		// Find user's cart or create new one
 		var cart Cart
-		err := cartColRef.FindOne(sessCtx, bson.M{"user_id": userID}).Decode(&cart)
+		err := cartColRef.FindOne(sessCtx, owner.filter()).Decode(&cart)
 
 		// If cart doesn't exist, create a new one
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
-				cart = Cart{
-					ID:          primitive.NewObjectID(),
-					UserID:      userID,
-					Items:       []CartItem{item},
-					TotalAmount: item.TotalPrice, // This is correct as it's the only item
-					CreatedAt:   time.Now(),
-					UpdatedAt:   time.Now(),
-				}
+				cart = owner.newCart()
+				cart.Items = []CartItem{item}
+				cart.TotalAmount = item.TotalPrice // This is correct as it's the only item
 				_, err = cartColRef.InsertOne(sessCtx, cart)
 				return nil, err
 			}
@@ -99,7 +141,10 @@ func (m *MongoClient) AddToCart(ctx context.Context, userID primitive.ObjectID,
 		for i, cartItem := range cart.Items {
 			// Compare by product ID, color, and model to find matching items
 			if cartItem.ProductID == item.ProductID && cartItem.Color == item.Color && cartItem.Model == item.Model {
-				// Update item quantity and total price
+				// Update item quantity and total price; the line as a whole must be in stock
+				if err := CheckStock(product, cartItem.Quantity+item.Quantity); err != nil {
+					return nil, err
+				}
 				cart.Items[i].Quantity += item.Quantity
 				cart.Items[i].TotalPrice = math.Round(cart.Items[i].Price*float64(cart.Items[i].Quantity)*100) / 100 // Added proper rounding
 
@@ -137,12 +182,16 @@ func (m *MongoClient) AddToCart(ctx context.Context, userID primitive.ObjectID,
 		// Update cart in database - ensure we're using the correct field name
 		updateResult, err := cartColRef.UpdateOne(
 			sessCtx,
-			bson.M{"user_id": userID},
-			bson.M{"$set": bson.M{
-				"items":        cart.Items,
-				"total_amount": cart.TotalAmount, // Using consistent field name
-				"updated_at":   cart.UpdatedAt,
-			}},
+			owner.filter(),
+			bson.M{
+				"$set": bson.M{
+					"items":        cart.Items,
+					"total_amount": cart.TotalAmount, // Using consistent field name
+					"updated_at":   cart.UpdatedAt,
+				},
+				// New items after a checkout start a fresh cart for reminders
+				"$unset": bson.M{"checked_out_at": ""},
+			},
 		)
 		if err != nil || updateResult.ModifiedCount == 0 {
 			return nil, fmt.Errorf("failed to update cart: %v", err)
@@ -151,29 +200,40 @@ func (m *MongoClient) AddToCart(ctx context.Context, userID primitive.ObjectID,
 	})
 
 	if err != nil {
-		return fmt.Errorf("transaction failed: %v", err)
+		return fmt.Errorf("transaction failed: %w", err)
 	}
 
 	return nil
 }
 
 func (m *MongoClient) UpdateCartItem(ctx context.Context, userID primitive.ObjectID, item CartItem, actions CartActions) error {
-	// Placeholder implementation
+	return m.updateCartItem(ctx, userCartOwner(userID), item, actions)
+}
+
+// UpdateGuestCartItem applies an increment, decrement or quantity change to a guest cart
+func (m *MongoClient) UpdateGuestCartItem(ctx context.Context, guestID string, item CartItem, actions CartActions) error {
+	return m.updateCartItem(ctx, guestCartOwner(guestID), item, actions)
+}
+
+func (m *MongoClient) updateCartItem(ctx context.Context, owner cartOwner, item CartItem, actions CartActions) error {
 	if m.client == nil {
 		return fmt.Errorf("MongoDB client is not initialized %v", m.client)
 	}
 
-	dbRef := m.client.Database(internal.DbName)
-	cartColRef := dbRef.Collection(internal.CartCollection)
-	if err := validate.Struct(item); err != nil {
-		return fmt.Errorf("validation error: %v", err)
+	dbRef := m.database()
+	cartColRef := dbRef.Collection(m.collections.Carts)
+	if err := Validate(item); err != nil {
+		return err
 	}
 
 	// check if product exists
-	productDb := dbRef.Collection(internal.ProductCollection)
+	productDb := dbRef.Collection(m.collections.Products)
 	var product Product
 	filter := bson.M{"_id": item.ProductID}
 	if err := productDb.FindOne(ctx, filter).Decode(&product); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return NotFound("Product not found")
+		}
 		return fmt.Errorf("failed to find product: %v", err)
 	}
 
@@ -188,8 +248,7 @@ func (m *MongoClient) UpdateCartItem(ctx context.Context, userID primitive.Objec
 	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
 		// Find user's cart
 		var cart Cart
-		filter := bson.M{"user_id": userID}
-		err := cartColRef.FindOne(sessCtx, filter).Decode(&cart)
+		err := cartColRef.FindOne(sessCtx, owner.filter()).Decode(&cart)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				// If cart doesn't exist, create a new one with the item
@@ -197,6 +256,9 @@ func (m *MongoClient) UpdateCartItem(ctx context.Context, userID primitive.Objec
 				if actions.Increment {
 					item.Quantity = 1
 				}
+				if err := CheckStock(product, item.Quantity); err != nil {
+					return nil, err
+				}
 				// Calculate item's price and total price
 				item.Price = product.Price
 				if product.Discount > 0 {
@@ -214,13 +276,9 @@ func (m *MongoClient) UpdateCartItem(ctx context.Context, userID primitive.Objec
 					item.Slug = product.Slug
 				}
 
-				cart = Cart{
-					UserID:      userID,
-					Items:       []CartItem{item},
-					TotalAmount: item.TotalPrice,
-					CreatedAt:   time.Now(),
-					UpdatedAt:   time.Now(),
-				}
+				cart = owner.newCart()
+				cart.Items = []CartItem{item}
+				cart.TotalAmount = item.TotalPrice
 				_, err = cartColRef.InsertOne(sessCtx, cart)
 				return nil, err
 			}
@@ -249,9 +307,8 @@ func (m *MongoClient) UpdateCartItem(ctx context.Context, userID primitive.Objec
 				}
 
 				// Check if requested quantity is available in stock
-				if cart.Items[i].Quantity > product.Stock {
-					return nil, fmt.Errorf("not enough stock, requested: %d, available: %d",
-						cart.Items[i].Quantity, product.Stock)
+				if err := CheckStock(product, cart.Items[i].Quantity); err != nil {
+					return nil, err
 				}
 
 				// Update the total price for this item with proper rounding
@@ -275,6 +332,9 @@ func (m *MongoClient) UpdateCartItem(ctx context.Context, userID primitive.Objec
 			if actions.Increment {
 				// Initialize with quantity 1 for increment action
 				item.Quantity = 1
+				if err := CheckStock(product, item.Quantity); err != nil {
+					return nil, err
+				}
 
 				// Calculate item's price and total price
 				item.Price = product.Price
@@ -296,7 +356,7 @@ func (m *MongoClient) UpdateCartItem(ctx context.Context, userID primitive.Objec
 				cart.Items = append(cart.Items, item)
 				itemFound = true
 			} else {
-				return nil, fmt.Errorf("item not found in cart")
+				return nil, NotFound("Item not found in cart")
 			}
 		}
 
@@ -311,16 +371,16 @@ func (m *MongoClient) UpdateCartItem(ctx context.Context, userID primitive.Objec
 		// Update cart in database with consistent field names
 		_, err = cartColRef.UpdateOne(
 			sessCtx,
-			bson.M{"user_id": userID},
+			owner.filter(),
 			bson.M{
 				"$set": bson.M{
 					"items":        cart.Items,
 					"total_amount": cart.TotalAmount, // Using consistent field name
 					"updated_at":   cart.UpdatedAt,
 				},
-				// Remove the old field if it exists
+				// New activity after a checkout starts a fresh cart for reminders
 				"$unset": bson.M{
-					"totalamount": "", // Remove the incorrect field name
+					"checked_out_at": "",
 				},
 			},
 		)
@@ -329,18 +389,27 @@ func (m *MongoClient) UpdateCartItem(ctx context.Context, userID primitive.Objec
 	})
 
 	if err != nil {
-		return fmt.Errorf("transaction failed: %v", err)
+		return fmt.Errorf("transaction failed: %w", err)
 	}
 
 	return nil
 }
 
 func (m *MongoClient) RemoveCartItem(ctx context.Context, userID primitive.ObjectID, cartItemID primitive.ObjectID) error {
+	return m.removeCartItem(ctx, userCartOwner(userID), cartItemID)
+}
+
+// RemoveGuestCartItem removes a single line from a guest cart by its item ID
+func (m *MongoClient) RemoveGuestCartItem(ctx context.Context, guestID string, cartItemID primitive.ObjectID) error {
+	return m.removeCartItem(ctx, guestCartOwner(guestID), cartItemID)
+}
+
+func (m *MongoClient) removeCartItem(ctx context.Context, owner cartOwner, cartItemID primitive.ObjectID) error {
 	if m.client == nil {
 		return fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	cartColRef := m.client.Database(internal.DbName).Collection(internal.CartCollection)
+	cartColRef := m.database().Collection(m.collections.Carts)
 
 	// Start a session for transaction
 	session, err := m.client.StartSession()
@@ -353,11 +422,11 @@ func (m *MongoClient) RemoveCartItem(ctx context.Context, userID primitive.Objec
 	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
 		// Find user's cart
 		var cart Cart
-		filter := bson.M{"user_id": userID}
+		filter := owner.filter()
 		err := cartColRef.FindOne(sessCtx, filter).Decode(&cart)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
-				return nil, fmt.Errorf("cart not found")
+				return nil, NotFound("Cart not found")
 			}
 			return nil, fmt.Errorf("error finding cart: %v", err)
 		}
@@ -373,7 +442,7 @@ func (m *MongoClient) RemoveCartItem(ctx context.Context, userID primitive.Objec
 
 		// If item not found, return error
 		if foundIndex == -1 {
-			return nil, fmt.Errorf("item with ID %s not found in cart", cartItemID.Hex())
+			return nil, NotFound("Item %s not found in cart", cartItemID.Hex())
 		}
 
 		// Remove item from the slice
@@ -402,9 +471,6 @@ func (m *MongoClient) RemoveCartItem(ctx context.Context, userID primitive.Objec
 						"total_amount": cart.TotalAmount, // Using consistent field name
 						"updated_at":   cart.UpdatedAt,
 					},
-					"$unset": bson.M{
-						"totalamount": "", // Remove the incorrect field name if it exists
-					},
 				},
 			)
 		}
@@ -413,18 +479,27 @@ func (m *MongoClient) RemoveCartItem(ctx context.Context, userID primitive.Objec
 	})
 
 	if err != nil {
-		return fmt.Errorf("transaction failed: %v", err)
+		return fmt.Errorf("transaction failed: %w", err)
 	}
 
 	return nil
 }
 
 func (m *MongoClient) ClearCart(ctx context.Context, userID primitive.ObjectID) error {
+	return m.clearCart(ctx, userCartOwner(userID))
+}
+
+// ClearGuestCart empties a guest cart without deleting the document
+func (m *MongoClient) ClearGuestCart(ctx context.Context, guestID string) error {
+	return m.clearCart(ctx, guestCartOwner(guestID))
+}
+
+func (m *MongoClient) clearCart(ctx context.Context, owner cartOwner) error {
 	if m.client == nil {
 		return fmt.Errorf("MongoDB client is not initialized")
 	}
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.CartCollection)
-	filter := bson.M{"user_id": userID}
+	collectionRef := m.database().Collection(m.collections.Carts)
+	filter := owner.filter()
 
 	// get the items in the cart
 	var cart Cart
@@ -432,13 +507,13 @@ func (m *MongoClient) ClearCart(ctx context.Context, userID primitive.ObjectID)
 	err := collectionRef.FindOne(ctx, filter).Decode(&cart)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return fmt.Errorf("cart not found")
+			return NotFound("Cart not found")
 		}
 		return fmt.Errorf("failed to retrieve cart: %v", err)
 	}
-	// Check if the userID matches the cart's user_id
-	if cart.UserID != userID {
-		return fmt.Errorf("user ID does not match the cart's user ID %v", userID)
+	// Check if the cart belongs to the owner we looked it up for
+	if cart.UserID != owner.userID || cart.GuestID != owner.guestID {
+		return Forbidden("Cart does not belong to the requesting shopper")
 	}
 
 	// Clear the cart - ensure we're using the correct field name
@@ -448,9 +523,6 @@ func (m *MongoClient) ClearCart(ctx context.Context, userID primitive.ObjectID)
 			"total_amount": 0, // Using consistent field name
 			"updated_at":   time.Now(),
 		},
-		"$unset": bson.M{
-			"totalamount": "", // Remove the incorrect field name if it exists
-		},
 	})
 	if err != nil {
 		return fmt.Errorf("failed to clear cart: %v", err)
@@ -459,43 +531,240 @@ func (m *MongoClient) ClearCart(ctx context.Context, userID primitive.ObjectID)
 }
 
 func (m *MongoClient) GetUserCart(ctx context.Context, userID primitive.ObjectID) (*Cart, error) {
+	return m.getCart(ctx, userCartOwner(userID))
+}
+
+// GetGuestCart returns the guest's cart, or an empty one that is not stored
+// when they have none yet
+func (m *MongoClient) GetGuestCart(ctx context.Context, guestID string) (*Cart, error) {
+	return m.getCart(ctx, guestCartOwner(guestID))
+}
+
+func (m *MongoClient) getCart(ctx context.Context, owner cartOwner) (*Cart, error) {
 	if m.client == nil {
 		return nil, fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	cartColRef := m.client.Database(internal.DbName).Collection(internal.CartCollection)
-	filter := bson.M{"user_id": userID}
+	cartColRef := m.database().Collection(m.collections.Carts)
+	filter := owner.filter()
 
 	var cart Cart
 	err := cartColRef.FindOne(ctx, filter).Decode(&cart)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			// Create a new cart with a generated ID when none exists
-			newCart := Cart{
-				ID:          primitive.NewObjectID(), // Explicitly generate a new Object ID
-				UserID:      userID,
-				Items:       []CartItem{},
-				TotalAmount: 0,
-				CreatedAt:   time.Now(),
-				UpdatedAt:   time.Now(),
+			// Reading a cart does not create one, so visitors who never add
+			// anything leave nothing behind; the first write stores it
+			newCart := owner.newCart()
+			return &newCart, nil
+		}
+		return nil, fmt.Errorf("failed to retrieve cart: %v", err)
+	}
+
+	// Check if the cart belongs to the owner we looked it up for
+	if cart.UserID != owner.userID || cart.GuestID != owner.guestID {
+		return nil, Forbidden("Cart does not belong to the requesting shopper")
+	}
+
+	// Bring prices and quantities in line with the current products
+	if err := m.revalidateCart(ctx, &cart); err != nil {
+		return nil, err
+	}
+
+	return &cart, nil
+}
+
+// revalidateCart applies ReconcileCart against the current products and
+// writes the corrected cart back when anything changed
+func (m *MongoClient) revalidateCart(ctx context.Context, cart *Cart) error {
+	if len(cart.Items) == 0 {
+		return nil
+	}
+
+	dbRef := m.database()
+
+	// Load every product in the cart with a single query
+	products, err := m.cartProducts(ctx, cart.Items)
+	if err != nil {
+		return err
+	}
+
+	if !ReconcileCart(cart, products) {
+		return nil
+	}
+
+	// Only write back if the cart has not been modified since we read it, so a
+	// concurrent add or update is never overwritten. UpdatedAt is left alone as
+	// re-validation is not shopper activity.
+	_, err = dbRef.Collection(m.collections.Carts).UpdateOne(ctx,
+		bson.M{"_id": cart.ID, "updated_at": cart.UpdatedAt},
+		bson.M{"$set": bson.M{
+			"items":        cart.Items,
+			"total_amount": cart.TotalAmount,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save re-validated cart: %v", err)
+	}
+
+	return nil
+}
+
+// cartProducts loads the products referenced by items, keyed by ID
+func (m *MongoClient) cartProducts(ctx context.Context, items []CartItem) (map[primitive.ObjectID]Product, error) {
+	products := make(map[primitive.ObjectID]Product, len(items))
+	if len(items) == 0 {
+		return products, nil
+	}
+
+	productIDs := make([]primitive.ObjectID, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	productColRef := m.database().Collection(m.collections.Products)
+	cursor, err := productColRef.Find(ctx, bson.M{"_id": bson.M{"$in": productIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find cart products: %v", err)
+	}
+	var found []Product
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, fmt.Errorf("failed to decode cart products: %v", err)
+	}
+	for _, product := range found {
+		products[product.ID] = product
+	}
+	return products, nil
+}
+
+// MergeGuestCart folds a guest cart into the user's cart after they sign in.
+// Lines for the same product, color and model are combined, quantities are
+// clamped to the product's current stock and prices are refreshed from the
+// product. The guest cart is deleted once the merge has been written.
+func (m *MongoClient) MergeGuestCart(ctx context.Context, guestID string, userID primitive.ObjectID) error {
+	if m.client == nil {
+		return fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	cartColRef := m.database().Collection(m.collections.Carts)
+	guest := guestCartOwner(guestID)
+	user := userCartOwner(userID)
+
+	// Start a session for transaction
+	session, err := m.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		var guestCart Cart
+		if err := cartColRef.FindOne(sessCtx, guest.filter()).Decode(&guestCart); err != nil {
+			if err == mongo.ErrNoDocuments {
+				// Nothing to merge
+				return nil, nil
 			}
+			return nil, fmt.Errorf("error finding guest cart: %v", err)
+		}
 
-			// Insert the new cart into the database
-			_, insertErr := cartColRef.InsertOne(ctx, newCart)
-			if insertErr != nil {
-				return nil, fmt.Errorf("failed to create new cart: %v", insertErr)
+		var userCart Cart
+		userCartExists := true
+		if err := cartColRef.FindOne(sessCtx, user.filter()).Decode(&userCart); err != nil {
+			if err != mongo.ErrNoDocuments {
+				return nil, fmt.Errorf("error finding cart: %v", err)
 			}
+			userCart = user.newCart()
+			userCartExists = false
+		}
 
-			// Return the newly created and persisted cart
-			return &newCart, nil
+		// Load every product referenced by the guest cart in a single query
+		products, err := m.cartProducts(sessCtx, guestCart.Items)
+		if err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("failed to retrieve cart: %v", err)
+
+		MergeCartItems(&userCart, guestCart.Items, products)
+		userCart.UpdatedAt = time.Now()
+
+		if userCartExists {
+			_, err = cartColRef.UpdateOne(sessCtx, user.filter(), bson.M{
+				"$set": bson.M{
+					"items":        userCart.Items,
+					"total_amount": userCart.TotalAmount,
+					"updated_at":   userCart.UpdatedAt,
+				},
+			})
+		} else {
+			_, err = cartColRef.InsertOne(sessCtx, userCart)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to save merged cart: %v", err)
+		}
+
+		if _, err := cartColRef.DeleteOne(sessCtx, guest.filter()); err != nil {
+			return nil, fmt.Errorf("failed to delete guest cart: %v", err)
+		}
+		return nil, nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("transaction failed: %w", err)
 	}
 
-	// Check if the userID matches the cart's user_id
-	if cart.UserID != userID {
-		return nil, fmt.Errorf("user ID does not match the cart's user ID")
+	return nil
+}
+
+// CartStats summarises the carts currently holding items
+type CartStats struct {
+	ActiveCarts  int64   `json:"active_carts" bson:"active_carts"`
+	GuestCarts   int64   `json:"guest_carts" bson:"guest_carts"`
+	TotalValue   float64 `json:"total_value" bson:"total_value"`
+	AverageValue float64 `json:"average_value" bson:"average_value"`
+	AverageItems float64 `json:"average_items" bson:"average_items"`
+}
+
+// GetCartStats aggregates active carts (carts with at least one item), their
+// average value and the average number of units per cart
+func (m *MongoClient) GetCartStats(ctx context.Context) (*CartStats, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	return &cart, nil
+	collectionRef := m.database().Collection(m.collections.Carts)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"items.0": bson.M{"$exists": true}}}},
+		{{Key: "$project", Value: bson.M{
+			"total_amount": 1,
+			"item_count":   bson.M{"$sum": "$items.quantity"},
+			"is_guest":     bson.M{"$cond": bson.A{bson.M{"$ifNull": bson.A{"$guest_id", false}}, 1, 0}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           nil,
+			"active_carts":  bson.M{"$sum": 1},
+			"guest_carts":   bson.M{"$sum": "$is_guest"},
+			"total_value":   bson.M{"$sum": "$total_amount"},
+			"average_value": bson.M{"$avg": "$total_amount"},
+			"average_items": bson.M{"$avg": "$item_count"},
+		}}},
+	}
+
+	cursor, err := collectionRef.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate cart stats: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	stats := CartStats{}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&stats); err != nil {
+			return nil, fmt.Errorf("failed to decode cart stats: %v", err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("cursor error: %v", err)
+	}
+
+	stats.TotalValue = math.Round(stats.TotalValue*100) / 100
+	stats.AverageValue = math.Round(stats.AverageValue*100) / 100
+	stats.AverageItems = math.Round(stats.AverageItems*100) / 100
+	return &stats, nil
 }
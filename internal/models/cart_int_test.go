@@ -0,0 +1,196 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func mustGetUserCart(t *testing.T, m *MongoClient, userID primitive.ObjectID) *Cart {
+	t.Helper()
+	cart, err := m.GetUserCart(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("GetUserCart failed: %v", err)
+	}
+	return cart
+}
+
+func TestAddToCart(t *testing.T) {
+	m := newTestMongoClient(t)
+	requireTransactions(t, m)
+	ctx := context.Background()
+
+	discounted := testProduct("Clear Case", 20, 5)
+	discounted.Discount = 25
+	product := mustAddProduct(t, m, discounted)
+	userID := primitive.NewObjectID()
+
+	// The first add creates the cart and prices the line from the product
+	if err := m.AddToCart(ctx, userID, CartItem{ProductID: product.ID, Quantity: 2, Color: "black"}); err != nil {
+		t.Fatalf("AddToCart failed: %v", err)
+	}
+	cart := mustGetUserCart(t, m, userID)
+	if len(cart.Items) != 1 || cart.Items[0].Price != 15 || cart.TotalAmount != 30 {
+		t.Fatalf("got %+v, want one line of 2 x 15", cart)
+	}
+	if cart.Items[0].Title != product.Title || cart.Items[0].Slug != product.Slug || cart.Items[0].ID.IsZero() {
+		t.Fatalf("line was not filled in from the product: %+v", cart.Items[0])
+	}
+
+	// The same product, color and model is merged into the existing line
+	if err := m.AddToCart(ctx, userID, CartItem{ProductID: product.ID, Quantity: 1, Color: "black"}); err != nil {
+		t.Fatalf("AddToCart failed: %v", err)
+	}
+	// A different color is a separate line
+	if err := m.AddToCart(ctx, userID, CartItem{ProductID: product.ID, Quantity: 1, Color: "red"}); err != nil {
+		t.Fatalf("AddToCart failed: %v", err)
+	}
+	cart = mustGetUserCart(t, m, userID)
+	if len(cart.Items) != 2 || cart.Items[0].Quantity != 3 || cart.TotalAmount != 60 {
+		t.Fatalf("got %+v, want 3 black and 1 red for 60", cart)
+	}
+
+	// Requests for more than the stock are rejected and leave the cart untouched
+	if err := m.AddToCart(ctx, userID, CartItem{ProductID: product.ID, Quantity: 6, Color: "black"}); err == nil {
+		t.Fatal("AddToCart accepted more than the available stock")
+	}
+	// so is an add that only goes over the stock together with the existing line
+	if err := m.AddToCart(ctx, userID, CartItem{ProductID: product.ID, Quantity: 3, Color: "black"}); err == nil {
+		t.Fatal("AddToCart merged a line beyond the available stock")
+	}
+	if err := m.AddToCart(ctx, userID, CartItem{ProductID: primitive.NewObjectID(), Quantity: 1, Color: "black"}); err == nil {
+		t.Fatal("AddToCart accepted an unknown product")
+	}
+	if cart = mustGetUserCart(t, m, userID); cart.TotalAmount != 60 {
+		t.Fatalf("got total %v after rejected adds, want 60", cart.TotalAmount)
+	}
+}
+
+func TestAddToCartConcurrently(t *testing.T) {
+	m := newTestMongoClient(t)
+	requireTransactions(t, m)
+	ctx := context.Background()
+
+	product := mustAddProduct(t, m, testProduct("Clear Case", 10, 100))
+	userID := primitive.NewObjectID()
+	if err := m.AddToCart(ctx, userID, CartItem{ProductID: product.ID, Quantity: 1, Color: "black"}); err != nil {
+		t.Fatalf("AddToCart failed: %v", err)
+	}
+
+	// Write conflicts between the transactions are retried, so no add is lost
+	const adds = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, adds)
+	for range adds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- m.AddToCart(ctx, userID, CartItem{ProductID: product.ID, Quantity: 1, Color: "black"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent AddToCart failed: %v", err)
+		}
+	}
+
+	cart := mustGetUserCart(t, m, userID)
+	if len(cart.Items) != 1 || cart.Items[0].Quantity != adds+1 || cart.TotalAmount != float64(adds+1)*10 {
+		t.Fatalf("got %+v, want a single line of %d", cart, adds+1)
+	}
+}
+
+func TestUpdateCartItem(t *testing.T) {
+	m := newTestMongoClient(t)
+	requireTransactions(t, m)
+	ctx := context.Background()
+
+	product := mustAddProduct(t, m, testProduct("Clear Case", 20, 3))
+	other := mustAddProduct(t, m, testProduct("Leather Wallet", 50, 3))
+	userID := primitive.NewObjectID()
+	line := CartItem{ProductID: product.ID, Quantity: 1, Color: "black"}
+
+	// Incrementing on a missing cart creates it with a single unit
+	if err := m.UpdateCartItem(ctx, userID, line, CartActions{Increment: true}); err != nil {
+		t.Fatalf("UpdateCartItem failed: %v", err)
+	}
+	if err := m.UpdateCartItem(ctx, userID, line, CartActions{Increment: true}); err != nil {
+		t.Fatalf("UpdateCartItem failed: %v", err)
+	}
+	cart := mustGetUserCart(t, m, userID)
+	if len(cart.Items) != 1 || cart.Items[0].Quantity != 2 || cart.TotalAmount != 40 {
+		t.Fatalf("got %+v after two increments, want 2 x 20", cart)
+	}
+
+	// A direct quantity change beyond the stock fails inside the transaction
+	line.Quantity = 4
+	if err := m.UpdateCartItem(ctx, userID, line, CartActions{}); err == nil {
+		t.Fatal("UpdateCartItem accepted more than the available stock")
+	}
+	line.Quantity = 3
+	if err := m.UpdateCartItem(ctx, userID, line, CartActions{}); err != nil {
+		t.Fatalf("UpdateCartItem failed: %v", err)
+	}
+	if cart = mustGetUserCart(t, m, userID); cart.Items[0].Quantity != 3 || cart.TotalAmount != 60 {
+		t.Fatalf("got %+v, want 3 x 20", cart)
+	}
+
+	// Incrementing a sold out product does not add a line for it
+	soldOut := mustAddProduct(t, m, testProduct("Glass Case", 30, 1))
+	if _, err := m.UpdateProduct(ctx, soldOut.ID, map[string]interface{}{"stock": 0}); err != nil {
+		t.Fatalf("UpdateProduct failed: %v", err)
+	}
+	if err := m.UpdateCartItem(ctx, userID, CartItem{ProductID: soldOut.ID, Quantity: 1, Color: "black"}, CartActions{Increment: true}); err == nil {
+		t.Fatal("UpdateCartItem added a sold out product")
+	}
+
+	// Only increments may add a line that is not in the cart yet
+	if err := m.UpdateCartItem(ctx, userID, CartItem{ProductID: other.ID, Quantity: 1, Color: "black"}, CartActions{Decrement: true}); err == nil {
+		t.Fatal("UpdateCartItem decremented a line that is not in the cart")
+	}
+
+	// Decrementing the last unit removes the line
+	line.Quantity = 1
+	for range 3 {
+		if err := m.UpdateCartItem(ctx, userID, line, CartActions{Decrement: true}); err != nil {
+			t.Fatalf("UpdateCartItem failed: %v", err)
+		}
+	}
+	if cart = mustGetUserCart(t, m, userID); len(cart.Items) != 0 || cart.TotalAmount != 0 {
+		t.Fatalf("got %+v, want an empty cart", cart)
+	}
+}
+
+func TestMergeGuestCart(t *testing.T) {
+	m := newTestMongoClient(t)
+	requireTransactions(t, m)
+	ctx := context.Background()
+
+	product := mustAddProduct(t, m, testProduct("Clear Case", 20, 4))
+	userID := primitive.NewObjectID()
+	guestID := "guest-1"
+
+	if err := m.AddToCart(ctx, userID, CartItem{ProductID: product.ID, Quantity: 3, Color: "black"}); err != nil {
+		t.Fatalf("AddToCart failed: %v", err)
+	}
+	if err := m.AddToGuestCart(ctx, guestID, CartItem{ProductID: product.ID, Quantity: 3, Color: "black"}); err != nil {
+		t.Fatalf("AddToGuestCart failed: %v", err)
+	}
+
+	if err := m.MergeGuestCart(ctx, guestID, userID); err != nil {
+		t.Fatalf("MergeGuestCart failed: %v", err)
+	}
+
+	// The merged line is clamped to the stock
+	cart := mustGetUserCart(t, m, userID)
+	if len(cart.Items) != 1 || cart.Items[0].Quantity != 4 || cart.TotalAmount != 80 {
+		t.Fatalf("got %+v, want 4 x 20", cart)
+	}
+	if guest, _ := m.GetGuestCart(ctx, guestID); len(guest.Items) != 0 {
+		t.Fatalf("guest cart still has %d lines after the merge", len(guest.Items))
+	}
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"fmt"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The functions in this file hold the cart pricing and stock rules without
+// touching storage, so every ShopCalls implementation applies them the same way.
+
+// DiscountedPrice returns the product's selling price after its discount, rounded to 2 decimal places
+func DiscountedPrice(product Product) float64 {
+	if product.Discount <= 0 {
+		return product.Price
+	}
+	discountAmount := product.Price * (product.Discount / 100)
+	return math.Round((product.Price-discountAmount)*100) / 100
+}
+
+// RecalculateTotal sets the cart total to the rounded sum of its line totals
+func (c *Cart) RecalculateTotal() {
+	totalAmount := 0.0
+	for _, item := range c.Items {
+		totalAmount += item.TotalPrice
+	}
+	c.TotalAmount = math.Round(totalAmount*100) / 100
+}
+
+// CheckStock returns an OutOfStock error when a cart line would hold more of
+// the product than is in stock
+func CheckStock(product Product, quantity int) error {
+	if quantity > product.Stock {
+		return OutOfStock("Not enough stock for %s: requested %d, available %d", product.Title, quantity, product.Stock)
+	}
+	return nil
+}
+
+// ReconcileCart checks every cart line against the current product: prices
+// are refreshed, quantities are clamped to stock and lines whose product was
+// deleted, disabled or sold out are dropped. Each change is reported in
+// cart.Notices. It returns true when the cart was modified.
+func ReconcileCart(cart *Cart, products map[primitive.ObjectID]Product) bool {
+	changed := false
+	items := make([]CartItem, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		notice := CartNotice{ItemID: item.ID, ProductID: item.ProductID, Title: item.Title}
+
+		product, ok := products[item.ProductID]
+		if !ok || !product.IsAvailable || product.Stock <= 0 {
+			notice.Type = CartNoticeNoLongerAvailable
+			notice.Message = fmt.Sprintf("%s is no longer available and was removed from your cart", item.Title)
+			cart.Notices = append(cart.Notices, notice)
+			changed = true
+			continue
+		}
+
+		if price := DiscountedPrice(product); price != item.Price {
+			if price < item.Price {
+				notice.Type = CartNoticePriceDropped
+				notice.Message = fmt.Sprintf("Price dropped from %.2f to %.2f", item.Price, price)
+			} else {
+				notice.Type = CartNoticePriceIncreased
+				notice.Message = fmt.Sprintf("Price increased from %.2f to %.2f", item.Price, price)
+			}
+			cart.Notices = append(cart.Notices, notice)
+			item.Price = price
+			changed = true
+		}
+
+		if item.Quantity > product.Stock {
+			notice.Type = CartNoticeLowStock
+			notice.Message = fmt.Sprintf("Only %d left, quantity reduced from %d", product.Stock, item.Quantity)
+			cart.Notices = append(cart.Notices, notice)
+			item.Quantity = product.Stock
+			changed = true
+		}
+
+		totalPrice := math.Round(item.Price*float64(item.Quantity)*100) / 100
+		if totalPrice != item.TotalPrice {
+			item.TotalPrice = totalPrice
+			changed = true
+		}
+		items = append(items, item)
+	}
+
+	if changed {
+		cart.Items = items
+		cart.RecalculateTotal()
+	}
+	return changed
+}
+
+// MergeCartItems folds items into cart. Lines for the same product, color and
+// model are combined, quantities are clamped to current stock, prices are
+// refreshed and items whose product is gone, disabled or sold out are skipped.
+func MergeCartItems(cart *Cart, items []CartItem, products map[primitive.ObjectID]Product) {
+	for _, incoming := range items {
+		product, ok := products[incoming.ProductID]
+		if !ok || !product.IsAvailable || product.Stock <= 0 {
+			// Product was deleted, disabled or sold out while it sat in the other cart
+			continue
+		}
+
+		price := DiscountedPrice(product)
+
+		merged := false
+		for i, cartItem := range cart.Items {
+			if cartItem.ProductID == incoming.ProductID && cartItem.Color == incoming.Color && cartItem.Model == incoming.Model {
+				cart.Items[i].Quantity = min(cartItem.Quantity+incoming.Quantity, product.Stock)
+				cart.Items[i].Price = price
+				cart.Items[i].TotalPrice = math.Round(price*float64(cart.Items[i].Quantity)*100) / 100
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			incoming.Quantity = min(incoming.Quantity, product.Stock)
+			incoming.Price = price
+			incoming.TotalPrice = math.Round(price*float64(incoming.Quantity)*100) / 100
+			cart.Items = append(cart.Items, incoming)
+		}
+	}
+
+	cart.RecalculateTotal()
+}
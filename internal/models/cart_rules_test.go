@@ -0,0 +1,27 @@
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMergeCartItems(t *testing.T) {
+	inStock := Product{ID: primitive.NewObjectID(), Title: "Clear Case", Price: 20, Stock: 3, IsAvailable: true}
+	unlisted := Product{ID: primitive.NewObjectID(), Title: "Leather Case", Price: 50, Stock: 5, IsAvailable: false}
+	soldOut := Product{ID: primitive.NewObjectID(), Title: "Glass Case", Price: 30, Stock: 0, IsAvailable: true}
+	products := map[primitive.ObjectID]Product{inStock.ID: inStock, unlisted.ID: unlisted, soldOut.ID: soldOut}
+
+	cart := Cart{Items: []CartItem{{ProductID: inStock.ID, Quantity: 2, Color: "black"}}}
+	MergeCartItems(&cart, []CartItem{
+		{ProductID: inStock.ID, Quantity: 2, Color: "black"},
+		{ProductID: unlisted.ID, Quantity: 1, Color: "black"},
+		{ProductID: soldOut.ID, Quantity: 1, Color: "black"},
+		{ProductID: primitive.NewObjectID(), Quantity: 1, Color: "black"},
+	}, products)
+
+	// Only the listed, in-stock line survives, clamped to the stock
+	if len(cart.Items) != 1 || cart.Items[0].Quantity != 3 || cart.TotalAmount != 60 {
+		t.Fatalf("got %+v, want a single line of 3 x 20", cart)
+	}
+}
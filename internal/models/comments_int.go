@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/joshuatakyi/shop/internal"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func (m *MongoClient) AddComment(ctx context.Context, comment Comments, userId string, productId primitive.ObjectID) error {
@@ -16,7 +16,7 @@ func (m *MongoClient) AddComment(ctx context.Context, comment Comments, userId s
 	}
 
 	// product collection
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 	// check if the product exists
 	filter := bson.M{"_id": productId}
 	update := bson.M{
@@ -40,26 +40,29 @@ func (m *MongoClient) AddComment(ctx context.Context, comment Comments, userId s
 
 	// Check if the product was found and updated
 	if result.MatchedCount == 0 {
-		return fmt.Errorf("product not found")
+		return NotFound("Product not found")
 	}
 
 	return nil
 }
 
 // GET COMMENTS FOR  A SPECIFIC PRODUCT BY ID
-func (m *MongoClient) GetComments(ctx context.Context, productId primitive.ObjectID) ([]Comments, error) {
+func (m *MongoClient) GetCommentsByProductID(ctx context.Context, productId primitive.ObjectID) ([]Comments, error) {
 
 	if m.client == nil {
 		return nil, fmt.Errorf("MongoDB client is not initialized")
 	}
 
 	// product collection
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 	// check if the product exists
 	filter := bson.M{"_id": productId}
 	var product Product
 	err := collectionRef.FindOne(ctx, filter).Decode(&product)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, NotFound("Product not found")
+		}
 		return nil, fmt.Errorf("failed to retrieve product: %v", err)
 	}
 
@@ -72,7 +75,7 @@ func (m *MongoClient) DeleteComment(ctx context.Context, id primitive.ObjectID,
 	}
 
 	// product collection
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 	// check if the product exists
 	filter := bson.M{"comments._id": id, "comments.userId": userId}
 	//  the mongodb pull operator removes from an array all instances of a value or values that match a specified condition
@@ -90,7 +93,7 @@ func (m *MongoClient) DeleteComment(ctx context.Context, id primitive.ObjectID,
 	}
 
 	if result.MatchedCount == 0 {
-		return fmt.Errorf("comment not found")
+		return NotFound("Comment not found")
 	}
 
 	return nil
@@ -102,7 +105,7 @@ func (m *MongoClient) UpdateComment(ctx context.Context, id primitive.ObjectID,
 	}
 
 	// product collection
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 	// check if the product exists
 	filter := bson.M{"comments._id": id, "comments.userId": userId}
 	update := bson.M{
@@ -118,24 +121,27 @@ func (m *MongoClient) UpdateComment(ctx context.Context, id primitive.ObjectID,
 	}
 
 	if result.MatchedCount == 0 {
-		return fmt.Errorf("comment not found")
+		return NotFound("Comment not found")
 	}
 
 	return nil
 }
 
-func (m *MongoClient) GetCommentById(ctx context.Context, id primitive.ObjectID) (*Comments, error) {
+func (m *MongoClient) GetCommentByID(ctx context.Context, id primitive.ObjectID) (*Comments, error) {
 	if m.client == nil {
 		return nil, fmt.Errorf("MongoDB client is not initialized")
 	}
 
 	// product collection
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 	// check if the product exists
 	filter := bson.M{"comments._id": id}
 	var product Product
 	err := collectionRef.FindOne(ctx, filter).Decode(&product)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, NotFound("Comment not found")
+		}
 		return nil, fmt.Errorf("failed to retrieve comment: %v", err)
 	}
 
@@ -145,5 +151,5 @@ func (m *MongoClient) GetCommentById(ctx context.Context, id primitive.ObjectID)
 		}
 	}
 
-	return nil, fmt.Errorf("comment not found")
+	return nil, NotFound("Comment not found")
 }
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCommentPushAndPull(t *testing.T) {
+	m := newTestMongoClient(t)
+	ctx := context.Background()
+	product := mustAddProduct(t, m, testProduct("Clear Case", 20, 5))
+
+	if err := m.AddComment(ctx, Comments{Comment: "Fits perfectly"}, "user-1", product.ID); err != nil {
+		t.Fatalf("AddComment failed: %v", err)
+	}
+	if err := m.AddComment(ctx, Comments{Comment: "Scratches easily"}, "user-2", product.ID); err != nil {
+		t.Fatalf("AddComment failed: %v", err)
+	}
+
+	comments, err := m.GetCommentsByProductID(ctx, product.ID)
+	if err != nil {
+		t.Fatalf("GetCommentsByProductID failed: %v", err)
+	}
+	if len(comments) != 2 {
+		t.Fatalf("got %d comments, want 2", len(comments))
+	}
+	first := comments[0]
+	if first.UserId != "user-1" || first.ProductID != product.ID || first.ID.IsZero() {
+		t.Fatalf("got first comment %+v, want user-1's comment on the product", first)
+	}
+
+	found, err := m.GetCommentByID(ctx, first.ID)
+	if err != nil || found.Comment != "Fits perfectly" {
+		t.Fatalf("GetCommentByID returned %+v, %v", found, err)
+	}
+
+	// Only the author may change a comment
+	if err := m.UpdateComment(ctx, first.ID, "user-3", Comments{Comment: "Hijacked"}); err == nil {
+		t.Fatal("UpdateComment accepted a user who has no comments on the product")
+	}
+	if err := m.UpdateComment(ctx, first.ID, "user-1", Comments{Comment: "Still fits perfectly"}); err != nil {
+		t.Fatalf("UpdateComment failed: %v", err)
+	}
+	if found, _ := m.GetCommentByID(ctx, first.ID); found.Comment != "Still fits perfectly" {
+		t.Fatalf("got comment %q after update", found.Comment)
+	}
+
+	if err := m.DeleteComment(ctx, first.ID, "user-3"); err == nil {
+		t.Fatal("DeleteComment accepted a user who has no comments on the product")
+	}
+	if err := m.DeleteComment(ctx, first.ID, "user-1"); err != nil {
+		t.Fatalf("DeleteComment failed: %v", err)
+	}
+
+	comments, _ = m.GetCommentsByProductID(ctx, product.ID)
+	if len(comments) != 1 || comments[0].UserId != "user-2" {
+		t.Fatalf("got %+v after delete, want only user-2's comment", comments)
+	}
+	if _, err := m.GetCommentByID(ctx, first.ID); err == nil {
+		t.Fatal("deleted comment can still be read")
+	}
+}
+
+func TestAddCommentToMissingProduct(t *testing.T) {
+	m := newTestMongoClient(t)
+	err := m.AddComment(context.Background(), Comments{Comment: "Hello"}, "user-1", primitive.NewObjectID())
+	if err == nil || err.Error() != "product not found" {
+		t.Fatalf("got error %v, want product not found", err)
+	}
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Kinds of domain error returned by the repository. Check for them with
+// errors.Is; anything else that comes back is an internal failure whose
+// details must not reach clients.
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrValidation      = errors.New("validation failed")
+	ErrConflict        = errors.New("conflict")
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+	ErrOutOfStock      = errors.New("out of stock")
+	ErrPaymentFailed   = errors.New("payment failed")
+)
+
+// Error is a domain error. Message is written for the shopper or admin who
+// made the request and is safe to return as is; Fields lists the problems
+// with individual fields when validation failed.
+type Error struct {
+	Kind    error
+	Message string
+	Fields  []FieldError
+}
+
+// FieldError describes one invalid field of a request or document, named as
+// in its JSON form, e.g. "category[0]"
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func newError(kind error, format string, args ...any) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// NotFound reports that the requested document does not exist
+func NotFound(format string, args ...any) error {
+	return newError(ErrNotFound, format, args...)
+}
+
+// Invalid reports a request that cannot be processed as sent
+func Invalid(format string, args ...any) error {
+	return newError(ErrValidation, format, args...)
+}
+
+// InvalidField reports a single invalid field
+func InvalidField(field, rule, message string) error {
+	return &Error{
+		Kind:    ErrValidation,
+		Message: message,
+		Fields:  []FieldError{{Field: field, Rule: rule, Message: message}},
+	}
+}
+
+// Conflict reports a write that clashes with existing data, e.g. a duplicate slug
+func Conflict(format string, args ...any) error {
+	return newError(ErrConflict, format, args...)
+}
+
+// Unauthenticated reports credentials or a token that do not identify anyone,
+// e.g. a wrong password or a revoked refresh token
+func Unauthenticated(format string, args ...any) error {
+	return newError(ErrUnauthenticated, format, args...)
+}
+
+// Forbidden reports an attempt to touch something the caller does not own
+func Forbidden(format string, args ...any) error {
+	return newError(ErrForbidden, format, args...)
+}
+
+// OutOfStock reports a quantity the product's stock cannot cover
+func OutOfStock(format string, args ...any) error {
+	return newError(ErrOutOfStock, format, args...)
+}
+
+// PaymentFailed reports a payment the provider declined or could not process
+func PaymentFailed(format string, args ...any) error {
+	return newError(ErrPaymentFailed, format, args...)
+}
+
+var validate = newValidator()
+
+// newValidator returns a validator that names fields by their JSON names so
+// the details sent to clients match what they sent
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	return v
+}
+
+// Validate checks v against its validate tags and returns a validation error
+// listing every invalid field
+func Validate(v any) error {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return fmt.Errorf("failed to validate %T: %v", v, err)
+	}
+
+	fields := make([]FieldError, 0, len(fieldErrs))
+	for _, fe := range fieldErrs {
+		// Drop the struct name: "Product.category[0]" becomes "category[0]"
+		field := fe.Namespace()
+		if _, rest, ok := strings.Cut(field, "."); ok {
+			field = rest
+		}
+		fields = append(fields, FieldError{Field: field, Rule: fe.Tag(), Message: fieldMessage(field, fe)})
+	}
+	return &Error{Kind: ErrValidation, Message: "Validation failed", Fields: fields}
+}
+
+func fieldMessage(field string, fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return field + " is required"
+	case "min":
+		return fmt.Sprintf("%s must be at least %s%s", field, fe.Param(), lengthUnit(fe.Kind()))
+	case "max":
+		return fmt.Sprintf("%s must be at most %s%s", field, fe.Param(), lengthUnit(fe.Kind()))
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", field, fe.Param())
+	case "url":
+		return field + " must be a valid URL"
+	case "email":
+		return field + " must be a valid email address"
+	case "eq":
+		return fmt.Sprintf("%s must be %s", field, fe.Param())
+	case "iso3166_1_alpha2":
+		return field + " must be a two-letter country code"
+	default:
+		return field + " is invalid"
+	}
+}
+
+// lengthUnit is what min and max count for a field of kind k
+func lengthUnit(k reflect.Kind) string {
+	switch k {
+	case reflect.String:
+		return " characters long"
+	case reflect.Slice, reflect.Map:
+		return " items long"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,416 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// cartTTLIndexName is the name of the TTL index that expires inactive carts
+const cartTTLIndexName = "cart_updated_at_ttl"
+
+// authRecordRetention is how long expired sessions and password resets are
+// kept before their TTL indexes delete them
+const authRecordRetention = 7 * 24 * time.Hour
+
+// IndexSpec declares an index the application relies on
+type IndexSpec struct {
+	Collection    string
+	Name          string
+	Keys          bson.D
+	Unique        bool
+	PartialFilter bson.M        // Only documents matching this filter are indexed
+	ExpireAfter   time.Duration // Set for TTL indexes only
+}
+
+// Index drift states reported by CheckIndexes and EnsureIndexes
+const (
+	IndexMissing    = "missing"    // Declared but not present
+	IndexChanged    = "changed"    // Present under the declared name with different keys or options
+	IndexConflict   = "conflict"   // The declared keys are already indexed under another name
+	IndexUndeclared = "undeclared" // Present but not declared, e.g. created by hand
+)
+
+// IndexDrift is a difference between the declared and the actual indexes
+type IndexDrift struct {
+	Collection string
+	Name       string
+	Status     string
+	Detail     string
+}
+
+func (d IndexDrift) String() string {
+	s := fmt.Sprintf("%s.%s: %s", d.Collection, d.Name, d.Status)
+	if d.Detail != "" {
+		s += " (" + d.Detail + ")"
+	}
+	return s
+}
+
+// IndexReport describes what EnsureIndexes did and what it left alone
+type IndexReport struct {
+	Created []string     // "collection.name" of each index that was created
+	Updated []string     // TTL indexes changed in place and indexes rebuilt on request
+	Dropped []string     // The cart TTL index when cart expiry has been disabled
+	Drift   []IndexDrift // Differences that remain after the run
+}
+
+// DeclaredIndexes returns every index the repository needs. Product indexes
+// follow the filters and sorts FilterProducts uses most: category with the
+// default newest-first sort or a price sort, tags and the storefront flags.
+// Carts are unique per user and per guest; the partial filters leave the other
+// kind of cart out so the missing field does not collide.
+func (m *MongoClient) DeclaredIndexes(cartTTL time.Duration) []IndexSpec {
+	products := m.collections.Products
+	carts := m.collections.Carts
+
+	specs := []IndexSpec{
+		{Collection: products, Name: "products_slug_unique", Keys: bson.D{{Key: "slug", Value: 1}}, Unique: true},
+		{Collection: products, Name: "products_created_at", Keys: bson.D{{Key: "createdAt", Value: -1}}},
+		{Collection: products, Name: "products_category_created_at", Keys: bson.D{{Key: "category", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Collection: products, Name: "products_category_price", Keys: bson.D{{Key: "category", Value: 1}, {Key: "price", Value: 1}}},
+		{Collection: products, Name: "products_tags", Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Collection: products, Name: "products_is_new_created_at", Keys: bson.D{{Key: "is_new", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Collection: products, Name: "products_is_on_sale_created_at", Keys: bson.D{{Key: "is_on_sale", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Collection: products, Name: "products_comments_id", Keys: bson.D{{Key: "comments._id", Value: 1}}},
+
+		{
+			Collection:    carts,
+			Name:          "cart_user_id_unique",
+			Keys:          bson.D{{Key: "user_id", Value: 1}},
+			Unique:        true,
+			PartialFilter: bson.M{"user_id": bson.M{"$exists": true}},
+		},
+		{
+			Collection:    carts,
+			Name:          "cart_guest_id_unique",
+			Keys:          bson.D{{Key: "guest_id", Value: 1}},
+			Unique:        true,
+			PartialFilter: bson.M{"guest_id": bson.M{"$exists": true}},
+		},
+	}
+
+	// Native accounts: emails are unique, sessions are listed and revoked per
+	// user and reset tokens are looked up by hash. Expired sessions and resets
+	// are kept for a week for auditing, then deleted by MongoDB.
+	users := m.collections.Users
+	sessions := m.collections.Sessions
+	resets := m.collections.Resets
+	specs = append(specs,
+		IndexSpec{Collection: users, Name: "users_email_unique", Keys: bson.D{{Key: "email", Value: 1}}, Unique: true},
+		IndexSpec{Collection: sessions, Name: "sessions_user_id", Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_used_at", Value: -1}}},
+		IndexSpec{Collection: sessions, Name: "sessions_expires_at_ttl", Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAfter: authRecordRetention},
+		IndexSpec{Collection: resets, Name: "password_resets_token_hash_unique", Keys: bson.D{{Key: "token_hash", Value: 1}}, Unique: true},
+		IndexSpec{Collection: resets, Name: "password_resets_expires_at_ttl", Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAfter: authRecordRetention},
+	)
+
+	// Denylist entries are read back by revocation time and are useless once
+	// the token has expired; the minute covers clock skew between instances
+	denylist := m.collections.Denylist
+	specs = append(specs,
+		IndexSpec{Collection: denylist, Name: "revoked_tokens_revoked_at", Keys: bson.D{{Key: "revoked_at", Value: 1}}},
+		IndexSpec{Collection: denylist, Name: "revoked_tokens_expires_at_ttl", Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAfter: time.Minute},
+	)
+
+	// Carts that have not been updated for cartTTL are deleted by MongoDB
+	if cartTTL > 0 {
+		specs = append(specs, IndexSpec{
+			Collection:  carts,
+			Name:        cartTTLIndexName,
+			Keys:        bson.D{{Key: "updated_at", Value: 1}},
+			ExpireAfter: cartTTL,
+		})
+	}
+
+	return specs
+}
+
+// indexInfo is an index as listed by the server
+type indexInfo struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	PartialFilter      bson.M `bson:"partialFilterExpression"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+}
+
+// CheckIndexes compares the declared indexes with the ones that exist and
+// returns the differences without changing anything
+func (m *MongoClient) CheckIndexes(ctx context.Context, cartTTL time.Duration) ([]IndexDrift, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	declared := m.DeclaredIndexes(cartTTL)
+	actual, err := m.listIndexes(ctx, declared)
+	if err != nil {
+		return nil, err
+	}
+	return diffIndexes(declared, actual), nil
+}
+
+// EnsureIndexes creates the declared indexes that are missing. It is safe to
+// run on every startup: matching indexes are left alone and a changed cart TTL
+// is applied in place with collMod. Other changed indexes are only dropped and
+// rebuilt when rebuild is set, since rebuilding a large collection or adding a
+// unique constraint should be done deliberately; until then they are reported
+// as drift along with conflicting and undeclared indexes.
+func (m *MongoClient) EnsureIndexes(ctx context.Context, cartTTL time.Duration, rebuild bool) (IndexReport, error) {
+	var report IndexReport
+	if m.client == nil {
+		return report, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	dbRef := m.database()
+	declared := m.DeclaredIndexes(cartTTL)
+	actual, err := m.listIndexes(ctx, declared)
+	if err != nil {
+		return report, err
+	}
+
+	for _, drift := range diffIndexes(declared, actual) {
+		spec, _ := findSpec(declared, drift.Collection, drift.Name)
+		collectionRef := dbRef.Collection(drift.Collection)
+		qualified := drift.Collection + "." + drift.Name
+
+		switch {
+		case drift.Status == IndexMissing:
+			if _, err := collectionRef.Indexes().CreateOne(ctx, spec.model()); err != nil {
+				return report, fmt.Errorf("failed to create index %s: %v", qualified, err)
+			}
+			report.Created = append(report.Created, qualified)
+
+		case drift.Status == IndexChanged && onlyTTLDiffers(spec, actual[drift.Collection][drift.Name]):
+			// The TTL changed since the index was created - update it without rebuilding
+			err := dbRef.RunCommand(ctx, bson.D{
+				{Key: "collMod", Value: drift.Collection},
+				{Key: "index", Value: bson.M{"name": drift.Name, "expireAfterSeconds": int64(spec.ExpireAfter / time.Second)}},
+			}).Err()
+			if err != nil {
+				return report, fmt.Errorf("failed to update TTL of index %s: %v", qualified, err)
+			}
+			report.Updated = append(report.Updated, qualified)
+
+		case drift.Status == IndexChanged && rebuild:
+			if _, err := collectionRef.Indexes().DropOne(ctx, drift.Name); err != nil {
+				return report, fmt.Errorf("failed to drop index %s: %v", qualified, err)
+			}
+			if _, err := collectionRef.Indexes().CreateOne(ctx, spec.model()); err != nil {
+				return report, fmt.Errorf("failed to rebuild index %s: %v", qualified, err)
+			}
+			report.Updated = append(report.Updated, qualified)
+
+		case drift.Status == IndexUndeclared && drift.Collection == m.collections.Carts && drift.Name == cartTTLIndexName:
+			// Expiry disabled - remove the index an earlier run created
+			if _, err := collectionRef.Indexes().DropOne(ctx, drift.Name); err != nil {
+				return report, fmt.Errorf("failed to drop cart TTL index: %v", err)
+			}
+			report.Dropped = append(report.Dropped, qualified)
+
+		default:
+			report.Drift = append(report.Drift, drift)
+		}
+	}
+
+	return report, nil
+}
+
+// listIndexes returns the existing indexes of every collection that has a
+// declared index, keyed by collection and index name
+func (m *MongoClient) listIndexes(ctx context.Context, declared []IndexSpec) (map[string]map[string]indexInfo, error) {
+	actual := map[string]map[string]indexInfo{}
+	for _, spec := range declared {
+		if _, done := actual[spec.Collection]; done {
+			continue
+		}
+		indexes, err := listCollectionIndexes(ctx, m.database().Collection(spec.Collection))
+		if err != nil {
+			return nil, err
+		}
+		actual[spec.Collection] = indexes
+	}
+	return actual, nil
+}
+
+// namespaceNotFound is the server error for listing indexes of a collection
+// that does not exist yet
+const namespaceNotFound = 26
+
+func listCollectionIndexes(ctx context.Context, collectionRef *mongo.Collection) (map[string]indexInfo, error) {
+	cursor, err := collectionRef.Indexes().List(ctx)
+	if err != nil {
+		if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == namespaceNotFound {
+			return map[string]indexInfo{}, nil
+		}
+		return nil, fmt.Errorf("failed to list indexes on %s: %v", collectionRef.Name(), err)
+	}
+	var list []indexInfo
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode indexes on %s: %v", collectionRef.Name(), err)
+	}
+	indexes := make(map[string]indexInfo, len(list))
+	for _, index := range list {
+		indexes[index.Name] = index
+	}
+	return indexes, nil
+}
+
+// diffIndexes returns the drift between the declared indexes and the actual
+// ones, ordered by collection and name. The default _id index is ignored.
+func diffIndexes(declared []IndexSpec, actual map[string]map[string]indexInfo) []IndexDrift {
+	var drift []IndexDrift
+	names := map[string]bool{}
+
+	for _, spec := range declared {
+		names[spec.Collection+"."+spec.Name] = true
+		existing, ok := actual[spec.Collection][spec.Name]
+		if ok {
+			if detail := describeIndexChange(spec, existing); detail != "" {
+				drift = append(drift, IndexDrift{spec.Collection, spec.Name, IndexChanged, detail})
+			}
+			continue
+		}
+
+		// Creating an index whose keys are already indexed under another name fails
+		if other, found := indexWithKeys(actual[spec.Collection], spec.Keys); found {
+			drift = append(drift, IndexDrift{spec.Collection, spec.Name, IndexConflict, "keys already indexed as " + other})
+			continue
+		}
+		drift = append(drift, IndexDrift{spec.Collection, spec.Name, IndexMissing, ""})
+	}
+
+	for collection, indexes := range actual {
+		for name, index := range indexes {
+			if name == "_id_" || names[collection+"."+name] {
+				continue
+			}
+			drift = append(drift, IndexDrift{collection, name, IndexUndeclared, "keys " + formatKeys(index.Key)})
+		}
+	}
+
+	sort.SliceStable(drift, func(i, j int) bool {
+		if drift[i].Collection != drift[j].Collection {
+			return drift[i].Collection < drift[j].Collection
+		}
+		return drift[i].Name < drift[j].Name
+	})
+	return drift
+}
+
+// describeIndexChange explains how an existing index differs from its
+// declaration, or returns "" when it matches
+func describeIndexChange(spec IndexSpec, existing indexInfo) string {
+	var changes []string
+	if !sameKeys(spec.Keys, existing.Key) {
+		changes = append(changes, fmt.Sprintf("keys %s, want %s", formatKeys(existing.Key), formatKeys(spec.Keys)))
+	}
+	if spec.Unique != existing.Unique {
+		changes = append(changes, fmt.Sprintf("unique %t, want %t", existing.Unique, spec.Unique))
+	}
+	// fmt prints maps with sorted keys, so equal filters print the same
+	if fmt.Sprint(map[string]interface{}(spec.PartialFilter)) != fmt.Sprint(map[string]interface{}(existing.PartialFilter)) {
+		changes = append(changes, fmt.Sprintf("partial filter %v, want %v", existing.PartialFilter, spec.PartialFilter))
+	}
+	wantTTL := int64(spec.ExpireAfter / time.Second)
+	switch {
+	case spec.ExpireAfter > 0 && existing.ExpireAfterSeconds == nil:
+		changes = append(changes, fmt.Sprintf("no TTL, want %ds", wantTTL))
+	case spec.ExpireAfter == 0 && existing.ExpireAfterSeconds != nil:
+		changes = append(changes, fmt.Sprintf("TTL %ds, want none", *existing.ExpireAfterSeconds))
+	case existing.ExpireAfterSeconds != nil && *existing.ExpireAfterSeconds != wantTTL:
+		changes = append(changes, fmt.Sprintf("TTL %ds, want %ds", *existing.ExpireAfterSeconds, wantTTL))
+	}
+	return strings.Join(changes, "; ")
+}
+
+// onlyTTLDiffers reports whether the TTL is the only difference, which collMod can fix in place
+func onlyTTLDiffers(spec IndexSpec, existing indexInfo) bool {
+	if spec.ExpireAfter <= 0 || existing.ExpireAfterSeconds == nil {
+		return false
+	}
+	withExistingTTL := spec
+	withExistingTTL.ExpireAfter = time.Duration(*existing.ExpireAfterSeconds) * time.Second
+	return describeIndexChange(withExistingTTL, existing) == ""
+}
+
+func findSpec(specs []IndexSpec, collection, name string) (IndexSpec, bool) {
+	for _, spec := range specs {
+		if spec.Collection == collection && spec.Name == name {
+			return spec, true
+		}
+	}
+	return IndexSpec{}, false
+}
+
+func indexWithKeys(indexes map[string]indexInfo, keys bson.D) (string, bool) {
+	for name, index := range indexes {
+		if sameKeys(keys, index.Key) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// sameKeys compares index keys in order. The server may return directions as
+// any numeric type, and special index types such as "text" as strings.
+func sameKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key {
+			return false
+		}
+		x, y := toInt64(a[i].Value), toInt64(b[i].Value)
+		if x != y || (x == -1 && fmt.Sprint(a[i].Value) != fmt.Sprint(b[i].Value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatKeys(keys bson.D) string {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s:%v", key.Key, key.Value))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// model converts the declaration into the driver's index model
+func (s IndexSpec) model() mongo.IndexModel {
+	opts := options.Index().SetName(s.Name)
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.PartialFilter != nil {
+		opts.SetPartialFilterExpression(s.PartialFilter)
+	}
+	if s.ExpireAfter > 0 {
+		opts.SetExpireAfterSeconds(int32(s.ExpireAfter / time.Second))
+	}
+	return mongo.IndexModel{Keys: s.Keys, Options: opts}
+}
+
+// toInt64 normalises the numeric types the driver may decode index options
+// and key directions into. Anything else is -1.
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return -1
+	}
+}
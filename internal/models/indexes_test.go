@@ -0,0 +1,141 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestDiffIndexes(t *testing.T) {
+	ttl := int64(3600)
+	declared := []IndexSpec{
+		{Collection: "products", Name: "products_slug_unique", Keys: bson.D{{Key: "slug", Value: 1}}, Unique: true},
+		{Collection: "products", Name: "products_tags", Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Collection: "products", Name: "products_created_at", Keys: bson.D{{Key: "createdAt", Value: -1}}},
+		{Collection: "cart", Name: "cart_user_id_unique", Keys: bson.D{{Key: "user_id", Value: 1}}, Unique: true, PartialFilter: bson.M{"user_id": bson.M{"$exists": true}}},
+		{Collection: "cart", Name: cartTTLIndexName, Keys: bson.D{{Key: "updated_at", Value: 1}}, ExpireAfter: 2 * time.Hour},
+	}
+	// As the server lists them: directions come back as int32 and nested documents as bson.M
+	actual := map[string]map[string]indexInfo{
+		"products": {
+			"_id_":                 {Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+			"products_slug_unique": {Name: "products_slug_unique", Key: bson.D{{Key: "slug", Value: int32(1)}}},
+			"tags_1":               {Name: "tags_1", Key: bson.D{{Key: "tags", Value: int32(1)}}},
+			"created_by_hand":      {Name: "created_by_hand", Key: bson.D{{Key: "title", Value: "text"}}},
+		},
+		"cart": {
+			"cart_user_id_unique": {Name: "cart_user_id_unique", Key: bson.D{{Key: "user_id", Value: int32(1)}}, Unique: true, PartialFilter: bson.M{"user_id": bson.M{"$exists": true}}},
+			cartTTLIndexName:      {Name: cartTTLIndexName, Key: bson.D{{Key: "updated_at", Value: int32(1)}}, ExpireAfterSeconds: &ttl},
+		},
+	}
+
+	got := map[string]IndexDrift{}
+	for _, drift := range diffIndexes(declared, actual) {
+		got[drift.Collection+"."+drift.Name] = drift
+	}
+
+	want := map[string]string{
+		"products.products_slug_unique": IndexChanged,
+		"products.products_tags":        IndexConflict,
+		"products.products_created_at":  IndexMissing,
+		"products.tags_1":               IndexUndeclared,
+		"products.created_by_hand":      IndexUndeclared,
+		"cart." + cartTTLIndexName:      IndexChanged,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got drift %v, want %v", got, want)
+	}
+	for name, status := range want {
+		if got[name].Status != status {
+			t.Errorf("%s: got %q, want %q", name, got[name].Status, status)
+		}
+	}
+	if detail := got["products.products_slug_unique"].Detail; !strings.Contains(detail, "unique false") {
+		t.Errorf("got detail %q, want it to explain the unique change", detail)
+	}
+
+	// Only the TTL differs on the cart index, so it can be changed in place
+	spec, _ := findSpec(declared, "cart", cartTTLIndexName)
+	if !onlyTTLDiffers(spec, actual["cart"][cartTTLIndexName]) {
+		t.Error("the TTL change should be applied with collMod")
+	}
+	spec, _ = findSpec(declared, "products", "products_slug_unique")
+	if onlyTTLDiffers(spec, actual["products"]["products_slug_unique"]) {
+		t.Error("a unique change must not be treated as a TTL change")
+	}
+}
+
+func TestEnsureIndexes(t *testing.T) {
+	m := newTestMongoClient(t)
+	ctx := context.Background()
+
+	report, err := m.EnsureIndexes(ctx, time.Hour, false)
+	if err != nil {
+		t.Fatalf("EnsureIndexes failed: %v", err)
+	}
+	if len(report.Created) != len(m.DeclaredIndexes(time.Hour)) || len(report.Drift) != 0 {
+		t.Fatalf("got %+v, want every declared index created on an empty database", report)
+	}
+
+	// A second run has nothing to do
+	if drift, err := m.CheckIndexes(ctx, time.Hour); err != nil || len(drift) != 0 {
+		t.Fatalf("got drift %v, %v after ensuring", drift, err)
+	}
+
+	// A new TTL is applied in place
+	report, err = m.EnsureIndexes(ctx, 2*time.Hour, false)
+	if err != nil || len(report.Updated) != 1 {
+		t.Fatalf("got %+v, %v, want the cart TTL index updated", report, err)
+	}
+
+	// An index changed by hand is reported, and only rebuilt on request
+	products := m.database().Collection(m.collections.Products)
+	if _, err := products.Indexes().DropOne(ctx, "products_tags"); err != nil {
+		t.Fatalf("failed to drop index: %v", err)
+	}
+	_, err = products.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tags", Value: 1}, {Key: "price", Value: 1}},
+		Options: options.Index().SetName("products_tags"),
+	})
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	report, err = m.EnsureIndexes(ctx, 2*time.Hour, false)
+	if err != nil || len(report.Drift) != 1 || report.Drift[0].Status != IndexChanged {
+		t.Fatalf("got %+v, %v, want the changed index reported", report, err)
+	}
+	if report, err = m.EnsureIndexes(ctx, 2*time.Hour, true); err != nil || len(report.Updated) != 1 {
+		t.Fatalf("got %+v, %v, want the changed index rebuilt", report, err)
+	}
+
+	// Disabling cart expiry drops the TTL index
+	if report, err = m.EnsureIndexes(ctx, 0, false); err != nil || len(report.Dropped) != 1 {
+		t.Fatalf("got %+v, %v, want the cart TTL index dropped", report, err)
+	}
+}
+
+func TestCartOwnerIndexes(t *testing.T) {
+	m := newTestMongoClient(t)
+	ctx := context.Background()
+	if _, err := m.EnsureIndexes(ctx, 0, false); err != nil {
+		t.Fatalf("EnsureIndexes failed: %v", err)
+	}
+
+	// Guest carts have no user_id and user carts no guest_id, so neither collides
+	carts := m.database().Collection(m.collections.Carts)
+	for _, owner := range []cartOwner{guestCartOwner("guest-1"), guestCartOwner("guest-2"), userCartOwner(primitive.NewObjectID()), userCartOwner(primitive.NewObjectID())} {
+		if _, err := carts.InsertOne(ctx, owner.newCart()); err != nil {
+			t.Fatalf("failed to insert cart: %v", err)
+		}
+	}
+
+	if _, err := carts.InsertOne(ctx, guestCartOwner("guest-1").newCart()); !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("got %v, want a duplicate key error for a second cart for the same guest", err)
+	}
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The tests in this package run against a real MongoDB pointed to by
+// MONGODB_URI and are skipped when it is not set, e.g.
+//
+//	MONGODB_URI=mongodb://localhost:27017/?replicaSet=rs0 make itest
+//
+// Each test gets its own database, dropped when the test ends.
+
+// newTestMongoClient returns a repository backed by a fresh database
+func newTestMongoClient(t *testing.T) *MongoClient {
+	t.Helper()
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		t.Skip("MONGODB_URI is not set, skipping MongoDB integration test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("failed to connect to MongoDB: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("failed to ping MongoDB: %v", err)
+	}
+
+	dbName := "shop_test_" + primitive.NewObjectID().Hex()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := client.Database(dbName).Drop(ctx); err != nil {
+			t.Errorf("failed to drop test database %s: %v", dbName, err)
+		}
+		client.Disconnect(ctx)
+	})
+
+	return NewMongoClient(client, config.MongoConfig{Database: dbName})
+}
+
+// requireTransactions skips the test unless the server supports multi-document
+// transactions, which need a replica set or a sharded cluster
+func requireTransactions(t *testing.T, m *MongoClient) {
+	t.Helper()
+	var hello bson.M
+	if err := m.client.Database("admin").RunCommand(context.Background(), bson.M{"hello": 1}).Decode(&hello); err != nil {
+		t.Fatalf("failed to run hello: %v", err)
+	}
+	if _, ok := hello["setName"]; ok || hello["msg"] == "isdbgrid" {
+		return
+	}
+	t.Skip("MongoDB is a standalone server, skipping test that needs transactions")
+}
+
+// testProduct returns a product that passes validation
+func testProduct(title string, price float64, stock int) Product {
+	return Product{
+		Title:       title,
+		Description: "A product stored by the MongoDB integration tests",
+		Price:       price,
+		Category:    []string{"cases"},
+		Images:      []string{"https://images.example.com/test.jpg"},
+		Tags:        []string{"test"},
+		Models:      []string{"iPhone 15"},
+		Colors:      []string{"black"},
+		Materials:   []string{"plastic"},
+		Details:     []string{"detail"},
+		Features:    []string{"feature"},
+		Stock:       stock,
+	}
+}
+
+// mustAddProduct stores product and returns it as read back from the database
+func mustAddProduct(t *testing.T, m *MongoClient, product Product) Product {
+	t.Helper()
+	ctx := context.Background()
+	id, err := m.AddProduct(ctx, product)
+	if err != nil {
+		t.Fatalf("failed to add product %q: %v", product.Title, err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	stored, err := m.GetProductByID(ctx, objectID)
+	if err != nil {
+		t.Fatalf("failed to load product %q: %v", product.Title, err)
+	}
+	return *stored
+}
@@ -1,18 +1,22 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"context"
 
-func (m *MongoClient) ProcessPayment(payment Payment) error {
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *MongoClient) ProcessPayment(ctx context.Context, payment Payment) error {
 	// Placeholder implementation
 	return nil
 }
 
-func (m *MongoClient) GetPaymentByID(id primitive.ObjectID) (*Payment, error) {
+func (m *MongoClient) GetPaymentByID(ctx context.Context, id primitive.ObjectID) (*Payment, error) {
 	// Placeholder implementation
 	return nil, nil
 }
 
-func (m *MongoClient) ApplyCoupon(code string, orderID primitive.ObjectID) error {
+func (m *MongoClient) ApplyCoupon(ctx context.Context, code string, orderID primitive.ObjectID) error {
 	// Placeholder implementation
 	return nil
 }
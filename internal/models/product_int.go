@@ -3,11 +3,12 @@ package models
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"regexp"
 	"time"
 
-	"github.com/joshuatakyi/shop/internal"
 	"github.com/joshuatakyi/shop/internal/helpers"
+	"github.com/joshuatakyi/shop/internal/logging"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -20,8 +21,8 @@ func (m *MongoClient) AddProduct(ctx context.Context, product Product) (string,
 		return "", fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	if err := validate.Struct(product); err != nil {
-		return "", fmt.Errorf("failed to vaildate product struct %v", err.Error())
+	if err := Validate(product); err != nil {
+		return "", err
 	}
 	// Set timestamps and default product flags
 	now := time.Now()
@@ -43,13 +44,18 @@ func (m *MongoClient) AddProduct(ctx context.Context, product Product) (string,
 		if len(product.Category) > 0 {
 			product.Slug = helpers.GenerateSlug(product.Title, product.Description[:30], product.Category[0])
 		} else {
-			return "", fmt.Errorf("product category is required for slug generation")
+			return "", InvalidField("category", "required", "category is required to generate the slug")
 		}
 	}
 
-	exist := helpers.DoesSlugAlreadyExist(product.Slug)
+	// Fail early with a clear error; the unique slug index catches the race
+	// between this check and the insert
+	exist, err := m.slugExists(ctx, product.Slug)
+	if err != nil {
+		return "", err
+	}
 	if exist {
-		return "", fmt.Errorf("slug already exists")
+		return "", Conflict("A product with the slug %q already exists", product.Slug)
 	}
 
 	// Set default values for optional fields if they are zero values
@@ -61,24 +67,36 @@ func (m *MongoClient) AddProduct(ctx context.Context, product Product) (string,
 	}
 
 	// Get collection reference
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 
 	// Insert the product into MongoDB
-	_, err := collectionRef.InsertOne(ctx, product)
+	_, err = collectionRef.InsertOne(ctx, product)
 	if err != nil {
-		return "", err
+		if mongo.IsDuplicateKeyError(err) {
+			return "", Conflict("A product with the slug %q already exists", product.Slug)
+		}
+		return "", fmt.Errorf("failed to insert product: %v", err)
 	}
 
 	// Return the inserted ID as string
 	return product.ID.Hex(), nil
 }
 
+// slugExists reports whether a product already uses the slug
+func (m *MongoClient) slugExists(ctx context.Context, slug string) (bool, error) {
+	count, err := m.database().Collection(m.collections.Products).CountDocuments(ctx, bson.M{"slug": slug})
+	if err != nil {
+		return false, fmt.Errorf("failed to check slug: %v", err)
+	}
+	return count > 0, nil
+}
+
 func (m *MongoClient) ListProducts(ctx context.Context, page, limit int) ([]Product, error) {
 	if m.client == nil {
 		return nil, fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 
 	// Initialize products as an empty slice rather than nil
 	products := []Product{}
@@ -117,13 +135,13 @@ func (m *MongoClient) GetProductByID(ctx context.Context, id primitive.ObjectID)
 		return nil, fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 	filter := bson.M{"_id": id}
 	var product Product
 	err := collectionRef.FindOne(ctx, filter).Decode(&product)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("product not found")
+			return nil, NotFound("Product not found")
 		}
 		return nil, fmt.Errorf("failed to retrieve product: %v", err)
 	}
@@ -137,13 +155,13 @@ func (m *MongoClient) GetProductBySlug(ctx context.Context, slug string) (*Produ
 		return nil, fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 	var product Product
 	filter := bson.M{"slug": slug}
 	err := collectionRef.FindOne(ctx, filter).Decode(&product)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("product not found")
+			return nil, NotFound("Product not found")
 		} else {
 			return nil, fmt.Errorf("failed to retrieve product: %v", err)
 		}
@@ -156,13 +174,16 @@ func (m *MongoClient) UpdateProduct(ctx context.Context, id primitive.ObjectID,
 	if m.client == nil {
 		return "", fmt.Errorf("MongoDB client is not initialized")
 	}
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 	filter := bson.M{"_id": id}
 	update := bson.M{"$set": product}
-	_, err := collectionRef.UpdateOne(ctx, filter, update)
+	result, err := collectionRef.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return "", fmt.Errorf("failed to update product: %v", err)
 	}
+	if result.MatchedCount == 0 {
+		return "", NotFound("Product not found")
+	}
 
 	return id.Hex(), nil
 }
@@ -172,14 +193,14 @@ func (m *MongoClient) DeleteProduct(ctx context.Context, id primitive.ObjectID)
 		return fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 	filter := bson.M{"_id": id}
 
 	// Check if the product exists before attempting to delete
 	err := collectionRef.FindOne(ctx, filter).Err()
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return fmt.Errorf("product not found")
+			return NotFound("Product not found")
 		}
 		return fmt.Errorf("failed to check product existence: %v", err)
 	}
@@ -192,7 +213,7 @@ func (m *MongoClient) DeleteProduct(ctx context.Context, id primitive.ObjectID)
 
 	// Ensure a product was actually deleted
 	if result.DeletedCount == 0 {
-		return fmt.Errorf("no product was deleted")
+		return NotFound("Product not found")
 	}
 
 	return nil
@@ -203,7 +224,7 @@ func (m *MongoClient) SearchProducts(ctx context.Context, query string, page, li
 		return nil, fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 
 	// Initialize products as an empty slice rather than nil
 	products := []Product{}
@@ -255,7 +276,7 @@ func (m *MongoClient) SearchProducts(ctx context.Context, query string, page, li
 
 // 	// we are deleting  fro m cloudinary and the database
 // 	// delete from cloudinary
-// 	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+// 	collectionRef := m.database().Collection(m.collections.Products)
 // 	filter := bson.M{"_id": id}
 // 	update := bson.M{"$pull": bson.M{"images": bson.M{"id": imageId}}}
 
@@ -291,6 +312,13 @@ func (m *MongoClient) BuildQuery(ctx context.Context, filter map[string]interfac
 		return nil, fmt.Errorf("MongoDB client is not initialized")
 	}
 
+	return BuildProductQuery(filter), nil
+}
+
+// BuildProductQuery turns the filter parameters collected by the FilterProducts
+// handler into a MongoDB product query. It does not need a connection, so every
+// ShopCalls implementation can return the same query from BuildQuery.
+func BuildProductQuery(filter map[string]interface{}) bson.M {
 	// Initialize the query as an empty bson.M
 	query := bson.M{}
 
@@ -345,12 +373,9 @@ func (m *MongoClient) BuildQuery(ctx context.Context, filter map[string]interfac
 		case "search":
 			// For search, we want to search in title, description, category, tags, etc.
 			if searchTerm, ok := value.(string); ok && searchTerm != "" {
-				// Log the search term for debugging
-				fmt.Printf("Searching for: %s\n", searchTerm)
-
 				// Split search term into words for more flexible matching
 				searchWords := helpers.TokenizeSearchQuery(searchTerm)
-				fmt.Printf("Tokenized search words: %v\n", searchWords)
+				slog.Debug("Searching products", "search", searchTerm, "words", searchWords)
 
 				// Build a more sophisticated search query that handles multiple words
 				if len(searchWords) > 0 {
@@ -439,7 +464,7 @@ func (m *MongoClient) BuildQuery(ctx context.Context, filter map[string]interfac
 		}
 	}
 
-	return query, nil
+	return query
 }
 
 func (m *MongoClient) FilterProducts(ctx context.Context, filterParams map[string]interface{}, page, limit int) ([]Product, int, error) {
@@ -448,17 +473,7 @@ func (m *MongoClient) FilterProducts(ctx context.Context, filterParams map[strin
 		return nil, 0, fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
-
-	// Debug log the filter parameters
-	fmt.Printf("Filter parameters before building query: %+v\n", filterParams)
-
-	// Check if search parameter exists
-	if searchParam, exists := filterParams["search"]; exists {
-		fmt.Printf("Search parameter found: %v\n", searchParam)
-	} else {
-		fmt.Println("No search parameter found in filterParams")
-	}
+	collectionRef := m.database().Collection(m.collections.Products)
 
 	// Build query from filter parameters
 	query, err := m.BuildQuery(ctx, filterParams)
@@ -466,8 +481,7 @@ func (m *MongoClient) FilterProducts(ctx context.Context, filterParams map[strin
 		return nil, 0, fmt.Errorf("failed to build query: %v", err)
 	}
 
-	// Debug log the built query
-	fmt.Printf("Built MongoDB query: %+v\n", query)
+	logging.FromContext(ctx).Debug("Filtering products", "filter", filterParams, "query", query, "page", page, "limit", limit)
 
 	// Set up options for pagination and sorting
 	opts := options.Find()
@@ -522,13 +536,13 @@ func (m *MongoClient) GetSimilarProducts(ctx context.Context, productId primitiv
 		return nil, fmt.Errorf("MongoDB client is not initialized")
 	}
 
-	collectionRef := m.client.Database(internal.DbName).Collection(internal.ProductCollection)
+	collectionRef := m.database().Collection(m.collections.Products)
 
 	// Find the product by ID
 	var product Product
 	if err := collectionRef.FindOne(ctx, bson.M{"_id": productId}).Decode(&product); err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("product not found: %v", err)
+			return nil, NotFound("Product not found")
 		}
 		return nil, fmt.Errorf("failed to find product: %v", err)
 	}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"testing"
+)
+
+func TestAddProductSlugUniqueness(t *testing.T) {
+	m := newTestMongoClient(t)
+	ctx := context.Background()
+
+	first := testProduct("Clear Case", 20, 5)
+	first.Slug = "clear-case"
+	mustAddProduct(t, m, first)
+
+	duplicate := testProduct("Another Clear Case", 25, 5)
+	duplicate.Slug = "clear-case"
+	if _, err := m.AddProduct(ctx, duplicate); err == nil || err.Error() != "slug already exists" {
+		t.Fatalf("got error %v, want slug already exists", err)
+	}
+
+	// A generated slug collides the same way when title, description and category repeat
+	generated := mustAddProduct(t, m, testProduct("Leather Case", 40, 5))
+	if generated.Slug == "" {
+		t.Fatal("no slug was generated")
+	}
+	if _, err := m.AddProduct(ctx, testProduct("Leather Case", 45, 5)); err == nil {
+		t.Fatal("a product with the same generated slug was accepted")
+	}
+}
+
+func TestFilterProductsQuerySemantics(t *testing.T) {
+	m := newTestMongoClient(t)
+
+	clear := testProduct("Clear Case", 20, 5)
+	clear.Tags = []string{"magsafe", "clear"}
+	clear.Colors = []string{"clear"}
+	mustAddProduct(t, m, clear)
+
+	leather := testProduct("Leather Wallet", 60, 5)
+	leather.Category = []string{"cases", "wallets"}
+	leather.Colors = []string{"brown", "black"}
+	leather.Materials = []string{"leather"}
+	mustAddProduct(t, m, leather)
+
+	charger := testProduct("USB-C Charger", 25, 5)
+	charger.Category = []string{"chargers"}
+	charger.Models = []string{"universal"}
+	charger.Colors = []string{"white"}
+	mustAddProduct(t, m, charger)
+
+	// Products are created as available and new; mark one as neither
+	onSale := mustAddProduct(t, m, testProduct("Screen Protector", 10, 5))
+	if _, err := m.UpdateProduct(context.Background(), onSale.ID, map[string]interface{}{"is_on_sale": true, "is_new": false}); err != nil {
+		t.Fatalf("failed to update product: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter map[string]interface{}
+		want   []string
+	}{
+		{"no filter", map[string]interface{}{}, []string{"Clear Case", "Leather Wallet", "Screen Protector", "USB-C Charger"}},
+		{"single category", map[string]interface{}{"category": "chargers"}, []string{"USB-C Charger"}},
+		{"any of several categories", map[string]interface{}{"category": []string{"wallets", "chargers"}}, []string{"Leather Wallet", "USB-C Charger"}},
+		{"minimum price", map[string]interface{}{"price_min": 25.0}, []string{"Leather Wallet", "USB-C Charger"}},
+		{"price range", map[string]interface{}{"price_min": 15.0, "price_max": 30.0}, []string{"Clear Case", "USB-C Charger"}},
+		{"any tag", map[string]interface{}{"tags": []string{"magsafe", "nope"}}, []string{"Clear Case"}},
+		{"model", map[string]interface{}{"models": []string{"universal"}}, []string{"USB-C Charger"}},
+		{"color", map[string]interface{}{"colors": []string{"black"}}, []string{"Leather Wallet", "Screen Protector"}},
+		{"material", map[string]interface{}{"materials": []string{"leather"}}, []string{"Leather Wallet"}},
+		{"boolean", map[string]interface{}{"is_on_sale": true}, []string{"Screen Protector"}},
+		{"filters combine with and", map[string]interface{}{"category": "cases", "price_max": 30.0}, []string{"Clear Case", "Screen Protector"}},
+		{"search by word", map[string]interface{}{"search": "wallet"}, []string{"Leather Wallet"}},
+		{"search is case insensitive and matches any word", map[string]interface{}{"search": "CHARGER leather"}, []string{"Leather Wallet", "USB-C Charger"}},
+		{"search escapes regex characters", map[string]interface{}{"search": "usb-c (charger"}, []string{"USB-C Charger"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			products, total, err := m.FilterProducts(context.Background(), tc.filter, 1, 50)
+			if err != nil {
+				t.Fatalf("FilterProducts failed: %v", err)
+			}
+			got := make([]string, 0, len(products))
+			for _, product := range products {
+				got = append(got, product.Title)
+			}
+			sort.Strings(got)
+			if total != len(tc.want) || len(got) != len(tc.want) {
+				t.Fatalf("got %v (total %d), want %v", got, total, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("got %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterProductsPagination(t *testing.T) {
+	m := newTestMongoClient(t)
+	for _, title := range []string{"Case One", "Case Two", "Case Three"} {
+		mustAddProduct(t, m, testProduct(title, 10, 1))
+	}
+
+	products, total, err := m.FilterProducts(context.Background(), map[string]interface{}{}, 2, 2)
+	if err != nil {
+		t.Fatalf("FilterProducts failed: %v", err)
+	}
+	if total != 3 || len(products) != 1 {
+		t.Fatalf("got %d products of %d, want 1 of 3 on the second page", len(products), total)
+	}
+}
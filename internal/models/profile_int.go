@@ -0,0 +1,137 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Profile Operations
+//
+// The default address is stored as default_address_id rather than a flag on
+// each address, so every update keeps exactly one default without touching
+// the other addresses.
+
+func (m *MongoClient) GetProfile(ctx context.Context, userID primitive.ObjectID) (*UserProfile, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	var profile UserProfile
+	err := m.database().Collection(m.collections.Profiles).FindOne(ctx, bson.M{"_id": userID}).Decode(&profile)
+	if err == mongo.ErrNoDocuments {
+		profile = UserProfile{ID: userID}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve profile: %v", err)
+	}
+	profile.MarkDefault()
+	return &profile, nil
+}
+
+func (m *MongoClient) UpdateProfile(ctx context.Context, userID primitive.ObjectID, name, phone string) (*UserProfile, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set":         bson.M{"name": name, "phone": phone, "updated_at": now},
+		"$setOnInsert": bson.M{"addresses": []Address{}, "created_at": now},
+	}
+	return m.updateProfile(ctx, bson.M{"_id": userID}, update, true)
+}
+
+// AddAddress saves a new address. The first one saved becomes the default.
+func (m *MongoClient) AddAddress(ctx context.Context, userID primitive.ObjectID, address Address) (*UserProfile, error) {
+	if address.ID.IsZero() {
+		address.ID = primitive.NewObjectID()
+	}
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	if address.IsDefault {
+		set["default_address_id"] = address.ID
+	}
+
+	// A full address book does not match, so the upsert tries to insert a
+	// second profile with the same _id and fails as a duplicate
+	filter := bson.M{"_id": userID, fmt.Sprintf("addresses.%d", MaxAddresses-1): bson.M{"$exists": false}}
+	update := bson.M{"$push": bson.M{"addresses": address}, "$set": set, "$setOnInsert": bson.M{"created_at": now}}
+	profile, err := m.updateProfile(ctx, filter, update, true)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, InvalidField("addresses", "max", fmt.Sprintf("You can save up to %d addresses", MaxAddresses))
+	}
+	if err != nil || !profile.DefaultAddressID.IsZero() {
+		return profile, err
+	}
+
+	// Nothing was the default yet
+	return m.moveDefault(ctx, userID,
+		bson.M{"default_address_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"default_address_id": address.ID}},
+	)
+}
+
+// UpdateAddress replaces a saved address, and makes it the default if it is
+// marked as one
+func (m *MongoClient) UpdateAddress(ctx context.Context, userID primitive.ObjectID, address Address) (*UserProfile, error) {
+	set := bson.M{"addresses.$": address, "updated_at": time.Now()}
+	if address.IsDefault {
+		set["default_address_id"] = address.ID
+	}
+	return m.updateProfile(ctx, bson.M{"_id": userID, "addresses._id": address.ID}, bson.M{"$set": set}, false)
+}
+
+func (m *MongoClient) SetDefaultAddress(ctx context.Context, userID, addressID primitive.ObjectID) (*UserProfile, error) {
+	update := bson.M{"$set": bson.M{"default_address_id": addressID, "updated_at": time.Now()}}
+	return m.updateProfile(ctx, bson.M{"_id": userID, "addresses._id": addressID}, update, false)
+}
+
+// DeleteAddress removes a saved address. Deleting the default makes the
+// oldest remaining address the default.
+func (m *MongoClient) DeleteAddress(ctx context.Context, userID, addressID primitive.ObjectID) (*UserProfile, error) {
+	update := bson.M{"$pull": bson.M{"addresses": bson.M{"_id": addressID}}, "$set": bson.M{"updated_at": time.Now()}}
+	profile, err := m.updateProfile(ctx, bson.M{"_id": userID, "addresses._id": addressID}, update, false)
+	if err != nil || profile.DefaultAddressID != addressID {
+		return profile, err
+	}
+
+	next := bson.M{"$unset": bson.M{"default_address_id": ""}}
+	if len(profile.Addresses) > 0 {
+		next = bson.M{"$set": bson.M{"default_address_id": profile.Addresses[0].ID}}
+	}
+	return m.moveDefault(ctx, userID, bson.M{"default_address_id": addressID}, next)
+}
+
+// moveDefault changes the default address if it is still what the condition
+// expects. When another request changed it first, its choice stands.
+func (m *MongoClient) moveDefault(ctx context.Context, userID primitive.ObjectID, condition, update bson.M) (*UserProfile, error) {
+	condition["_id"] = userID
+	profile, err := m.updateProfile(ctx, condition, update, false)
+	if errors.Is(err, ErrNotFound) {
+		return m.GetProfile(ctx, userID)
+	}
+	return profile, err
+}
+
+// updateProfile applies the update and returns the profile after it. Without
+// upsert, a filter that matches nothing means the address was not found.
+func (m *MongoClient) updateProfile(ctx context.Context, filter, update bson.M, upsert bool) (*UserProfile, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetUpsert(upsert)
+	var profile UserProfile
+	err := m.database().Collection(m.collections.Profiles).FindOneAndUpdate(ctx, filter, update, opts).Decode(&profile)
+	if err == mongo.ErrNoDocuments {
+		return nil, NotFound("Address not found")
+	}
+	if err != nil {
+		// Wrapped so AddAddress can tell a full address book apart
+		return nil, fmt.Errorf("failed to update profile: %w", err)
+	}
+	profile.MarkDefault()
+	return &profile, nil
+}
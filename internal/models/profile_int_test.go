@@ -0,0 +1,53 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestProfileAddresses(t *testing.T) {
+	m := newTestMongoClient(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	profile, err := m.GetProfile(ctx, userID)
+	if err != nil || profile.ID != userID || len(profile.Addresses) != 0 {
+		t.Fatalf("GetProfile returned %+v, %v, want an empty profile", profile, err)
+	}
+
+	// The first address becomes the default; a later one only when asked
+	home := Address{ID: primitive.NewObjectID(), Type: "home", AddressLine1: "1 Main St", City: "Accra", State: "Greater Accra", Country: "GH"}
+	work := Address{ID: primitive.NewObjectID(), Type: "work", AddressLine1: "2 Main St", City: "Accra", State: "Greater Accra", Country: "GH"}
+	if profile, err = m.AddAddress(ctx, userID, home); err != nil || profile.DefaultAddressID != home.ID {
+		t.Fatalf("AddAddress returned %+v, %v, want home as the default", profile, err)
+	}
+	work.IsDefault = true
+	if profile, err = m.AddAddress(ctx, userID, work); err != nil || profile.DefaultAddressID != work.ID || profile.Address(home.ID).IsDefault {
+		t.Fatalf("AddAddress returned %+v, %v, want work as the only default", profile, err)
+	}
+
+	home.City = "Kumasi"
+	if profile, err = m.UpdateAddress(ctx, userID, home); err != nil || profile.Address(home.ID).City != "Kumasi" || profile.DefaultAddressID != work.ID {
+		t.Fatalf("UpdateAddress returned %+v, %v", profile, err)
+	}
+	if _, err := m.SetDefaultAddress(ctx, userID, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got error %v for an unknown address, want ErrNotFound", err)
+	}
+
+	// Deleting the default hands it to the remaining address
+	if profile, err = m.DeleteAddress(ctx, userID, work.ID); err != nil || len(profile.Addresses) != 1 || profile.DefaultAddressID != home.ID {
+		t.Fatalf("DeleteAddress returned %+v, %v, want home as the default", profile, err)
+	}
+
+	for i := 1; i < MaxAddresses; i++ {
+		if _, err := m.AddAddress(ctx, userID, Address{Type: "other", AddressLine1: "Somewhere", City: "Accra", State: "Greater Accra", Country: "GH"}); err != nil {
+			t.Fatalf("AddAddress %d failed: %v", i, err)
+		}
+	}
+	if _, err := m.AddAddress(ctx, userID, home); !errors.Is(err, ErrValidation) {
+		t.Fatalf("got error %v for a full address book, want ErrValidation", err)
+	}
+}
@@ -1,13 +1,17 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"context"
 
-func (m *MongoClient) AddReview(review Review) error {
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *MongoClient) AddReview(ctx context.Context, review Review) error {
 	// Placeholder implementation
 	return nil
 }
 
-func (m *MongoClient) GetProductReviews(productID primitive.ObjectID) ([]Review, error) {
+func (m *MongoClient) GetProductReviews(ctx context.Context, productID primitive.ObjectID) ([]Review, error) {
 	// Placeholder implementation
 	return nil, nil
 }
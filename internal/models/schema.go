@@ -2,11 +2,13 @@ package models
 
 import (
 	"context"
+	"fmt"
 	"time"
 
-	"github.com/go-playground/validator/v10"
+	"github.com/joshuatakyi/shop/internal/config"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 type Product struct {
@@ -53,16 +55,59 @@ type Comments struct {
 // 	Height float64 `json:"height,omitempty"` // In mm
 // }
 
+// Address is a shipping address, saved in a user's profile or copied into an
+// order. Whether it is the default is kept on the profile, so exactly one
+// address can be the default; IsDefault is filled in when the profile is read.
 type Address struct {
 	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Type         string             `json:"type" validate:"required,eq=home|eq=work|eq=other"` // home, work, other
-	AddressLine1 string             `json:"address_line1" validate:"required"`
-	AddressLine2 string             `json:"address_line2,omitempty"`
-	City         string             `json:"city" validate:"required"`
-	State        string             `json:"state" validate:"required"`
-	PostalCode   string             `json:"postal_code" validate:"required"`
-	Country      string             `json:"country" validate:"required"`
-	IsDefault    bool               `json:"is_default"`
+	Type         string             `bson:"type" json:"type" validate:"required,eq=home|eq=work|eq=other"` // home, work, other
+	AddressLine1 string             `bson:"address_line1" json:"address_line1" validate:"required,max=200"`
+	AddressLine2 string             `bson:"address_line2,omitempty" json:"address_line2,omitempty" validate:"max=200"`
+	City         string             `bson:"city" json:"city" validate:"required,max=100"`
+	State        string             `bson:"state" json:"state" validate:"required,max=100"`
+	PostalCode   string             `bson:"postal_code,omitempty" json:"postal_code"` // Checked against the country's format by ValidateAddress
+	Country      string             `bson:"country" json:"country" validate:"required,iso3166_1_alpha2"`
+	IsDefault    bool               `bson:"-" json:"is_default"`
+}
+
+// MaxAddresses is how many addresses a profile can hold
+const MaxAddresses = 20
+
+// UserProfile holds what a shopper saves for checkout. Its ID is the user's
+// ID, so Auth.js users get one as well as native accounts.
+type UserProfile struct {
+	ID               primitive.ObjectID `bson:"_id" json:"id"`
+	Name             string             `bson:"name,omitempty" json:"name"`
+	Phone            string             `bson:"phone,omitempty" json:"phone"`
+	Addresses        []Address          `bson:"addresses" json:"addresses"`
+	DefaultAddressID primitive.ObjectID `bson:"default_address_id,omitempty" json:"default_address_id,omitempty"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Address returns the saved address with the ID, or nil
+func (p *UserProfile) Address(id primitive.ObjectID) *Address {
+	for i := range p.Addresses {
+		if p.Addresses[i].ID == id {
+			return &p.Addresses[i]
+		}
+	}
+	return nil
+}
+
+// DefaultAddress returns the default address, or nil when none are saved
+func (p *UserProfile) DefaultAddress() *Address {
+	return p.Address(p.DefaultAddressID)
+}
+
+// MarkDefault sets IsDefault on the default address and clears it on the rest
+func (p *UserProfile) MarkDefault() {
+	if p.Addresses == nil {
+		p.Addresses = []Address{}
+	}
+	for i := range p.Addresses {
+		p.Addresses[i].IsDefault = p.Addresses[i].ID == p.DefaultAddressID
+	}
 }
 
 type Order struct {
@@ -95,16 +140,58 @@ type OrderItem struct {
 
 type Cart struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id,omitempty"`
+	GuestID     string             `json:"guest_id,omitempty" bson:"guest_id,omitempty"` // Set instead of UserID for anonymous carts
 	Items       []CartItem         `json:"items"`
-	TotalAmount float64            `json:"total_amount" bson:"total_amount"` // Explicitly set the BSON tag
-	CreatedAt   time.Time          `json:"created_at" bson:"createdat"`      // Match the createdat field in DB
-	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`     // This one is already correct
+	TotalAmount float64            `json:"total_amount" bson:"total_amount"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	Notices     []CartNotice       `json:"notices,omitempty" bson:"-"` // Changes found while re-validating the cart, never stored
+
+	// Abandoned cart tracking
+	Email          string     `json:"-" bson:"email,omitempty"`                                     // Where cart reminders are sent
+	ReminderSentAt *time.Time `json:"reminder_sent_at,omitempty" bson:"reminder_sent_at,omitempty"` // Last abandoned cart reminder
+	CheckedOutAt   *time.Time `json:"checked_out_at,omitempty" bson:"checked_out_at,omitempty"`     // Set when payment for the cart succeeds
+	PaidReference  string     `json:"-" bson:"paid_reference,omitempty"`                            // Payment reference that last checked the cart out
+}
+
+// CartEventAbandoned is recorded each time the abandoned cart worker picks up a cart
+const CartEventAbandoned = "abandoned"
+
+// CartEvent is an audit record of something that happened to a cart outside a request
+type CartEvent struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CartID      primitive.ObjectID `json:"cart_id" bson:"cart_id"`
+	UserID      primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Type        string             `json:"type" bson:"type"`
+	ItemCount   int                `json:"item_count" bson:"item_count"`
+	TotalAmount float64            `json:"total_amount" bson:"total_amount"`
+	IdleSince   time.Time          `json:"idle_since" bson:"idle_since"`
+	Reminded    bool               `json:"reminded" bson:"reminded"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Cart notice types returned to the UI after re-validating a cart
+const (
+	CartNoticePriceDropped      = "price_dropped"
+	CartNoticePriceIncreased    = "price_increased"
+	CartNoticeLowStock          = "low_stock"
+	CartNoticeNoLongerAvailable = "no_longer_available"
+)
+
+// CartNotice describes a change made to a cart line because the product changed
+type CartNotice struct {
+	Type      string             `json:"type"`
+	ItemID    primitive.ObjectID `json:"item_id"`
+	ProductID primitive.ObjectID `json:"product_id"`
+	Title     string             `json:"title"`
+	Message   string             `json:"message"`
 }
 
 type CartItem struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	ProductID  primitive.ObjectID `json:"product_id" bson:"productid,omitempty" validate:"required"` // Changed field tag to match DB
+	ProductID  primitive.ObjectID `json:"product_id" bson:"product_id,omitempty" validate:"required"`
 	Quantity   int                `json:"quantity" bson:"quantity" validate:"required,min=1"`
 	Color      string             `json:"color,omitempty" bson:"color" validate:"required"`
 	Image      string             `json:"image" bson:"image"`
@@ -112,7 +199,7 @@ type CartItem struct {
 	Title      string             `json:"title" bson:"title"`
 	Price      float64            `json:"price" bson:"price"`
 	Model      string             `json:"model,omitempty" bson:"model"`
-	TotalPrice float64            `json:"total_price" bson:"totalprice"` // Changed field tag to match DB
+	TotalPrice float64            `json:"total_price" bson:"total_price"`
 }
 
 type Review struct {
@@ -142,24 +229,93 @@ type Payment struct {
 	UpdatedAt     time.Time          `json:"updated_at"`
 }
 
+// User is an account the API owns itself when native accounts are enabled.
+// The password is only ever stored as a bcrypt hash.
+type User struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Email        string             `bson:"email" json:"email"` // Lower-cased, unique
+	Name         string             `bson:"name" json:"name"`
+	Role         string             `bson:"role" json:"role"`
+	PasswordHash string             `bson:"password_hash" json:"-"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// UserSession is one signed-in device of a native account. Only a hash of its
+// current refresh token is stored: each refresh replaces it, and presenting an
+// older one revokes the session. Once revoked or expired it cannot be refreshed.
+type UserSession struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID           primitive.ObjectID `bson:"user_id" json:"user_id"`
+	RefreshTokenHash string             `bson:"refresh_token_hash" json:"-"`
+	AccessTokens     []IssuedToken      `bson:"access_tokens,omitempty" json:"-"` // Unexpired access tokens, denylisted when the session is revoked
+	UserAgent        string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	IP               string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt       time.Time          `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt        time.Time          `bson:"expires_at" json:"expires_at"`
+	RevokedAt        *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the session can still be refreshed at now
+func (s UserSession) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// IssuedToken is an access token handed out for a session, kept until it
+// expires so that revoking the session can revoke it too
+type IssuedToken struct {
+	JTI       string    `bson:"jti" json:"jti"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// RevokedToken is an entry of the token denylist. It is only needed until
+// the token would have expired anyway.
+type RevokedToken struct {
+	JTI       string    `bson:"_id" json:"jti"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+	RevokedAt time.Time `bson:"revoked_at" json:"revoked_at"`
+	Reason    string    `bson:"reason" json:"reason"`
+}
+
+// PasswordReset is a single-use password reset token. Like refresh tokens it
+// is stored as a hash, so a leaked database cannot be used to take over accounts.
+type PasswordReset struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
 type CartActions struct {
 	Increment bool `json:"increment"`
 	Decrement bool `json:"decrement"`
 }
+
+// ShopCalls is the storage contract used by the HTTP handlers and background
+// jobs. MongoClient is the production implementation; memstore.Store keeps
+// everything in memory for tests and demo mode.
 type ShopCalls interface {
+	// Ping reports whether the store can serve requests, for readiness checks
+	Ping(ctx context.Context) error
+
 	// Product Operations
 	AddProduct(ctx context.Context, product Product) (string, error)
 	GetProductByID(ctx context.Context, id primitive.ObjectID) (*Product, error)
 	GetProductBySlug(ctx context.Context, slug string) (*Product, error)
 	UpdateProduct(ctx context.Context, id primitive.ObjectID, product map[string]interface{}) (string, error)
 	DeleteProduct(ctx context.Context, id primitive.ObjectID) error
-	ListProducts(ctx context.Context, page, limit int, filter map[string]interface{}) ([]Product, int64, error)
+	ListProducts(ctx context.Context, page, limit int) ([]Product, error)
+	FilterProducts(ctx context.Context, filterParams map[string]interface{}, page, limit int) ([]Product, int, error)
 	BuildQuery(ctx context.Context, filter map[string]interface{}) (primitive.M, error)
-	GetSimilarProducts(ctx context.Context, product primitive.ObjectID) ([]Product, error)
+	GetSimilarProducts(ctx context.Context, productId primitive.ObjectID) ([]Product, error)
+
 	// Comment Operations
 	AddComment(ctx context.Context, comment Comments, userId string, productId primitive.ObjectID) error
 	GetCommentsByProductID(ctx context.Context, productID primitive.ObjectID) ([]Comments, error)
-	UpdateComment(ctx context.Context, comment Comments) (string, error)
+	UpdateComment(ctx context.Context, id primitive.ObjectID, userId string, comment Comments) error
 	GetCommentByID(ctx context.Context, id primitive.ObjectID) (*Comments, error)
 	DeleteComment(ctx context.Context, id primitive.ObjectID, userId string) error
 
@@ -169,6 +325,53 @@ type ShopCalls interface {
 	UpdateCartItem(ctx context.Context, userID primitive.ObjectID, item CartItem, actions CartActions) error
 	RemoveCartItem(ctx context.Context, userID primitive.ObjectID, cartItemID primitive.ObjectID) error
 	ClearCart(ctx context.Context, userID primitive.ObjectID) error
+	SetCartEmail(ctx context.Context, userID primitive.ObjectID, email string) error
+	MarkCartCheckedOut(ctx context.Context, userID primitive.ObjectID, reference string) (bool, error)
+	GetCartStats(ctx context.Context) (*CartStats, error)
+
+	// Guest Cart Operations
+	GetGuestCart(ctx context.Context, guestID string) (*Cart, error)
+	AddToGuestCart(ctx context.Context, guestID string, item CartItem) error
+	UpdateGuestCartItem(ctx context.Context, guestID string, item CartItem, actions CartActions) error
+	RemoveGuestCartItem(ctx context.Context, guestID string, cartItemID primitive.ObjectID) error
+	ClearGuestCart(ctx context.Context, guestID string) error
+	MergeGuestCart(ctx context.Context, guestID string, userID primitive.ObjectID) error
+
+	// Abandoned Cart Operations
+	FindAbandonedCarts(ctx context.Context, idleSince, remindedBefore time.Time, limit int64) ([]Cart, error)
+	RecordCartEvent(ctx context.Context, event CartEvent) error
+	MarkCartReminderSent(ctx context.Context, cartID primitive.ObjectID, sentAt time.Time) error
+
+	// User Operations
+	CreateUser(ctx context.Context, user User) (*User, error)
+	GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	UpdateUserPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error
+
+	// Session Operations
+	CreateSession(ctx context.Context, session UserSession) (*UserSession, error)
+	GetSession(ctx context.Context, id primitive.ObjectID) (*UserSession, error)
+	RotateSession(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, accessTokens []IssuedToken, usedAt time.Time) error
+	ListUserSessions(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]UserSession, error)
+	RevokeSession(ctx context.Context, id primitive.ObjectID, revokedAt time.Time) (*UserSession, error)
+	RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, revokedAt time.Time) ([]UserSession, error)
+
+	// Token Denylist Operations
+	AddRevokedTokens(ctx context.Context, tokens []RevokedToken) error
+	ListRevokedTokens(ctx context.Context, since, now time.Time) ([]RevokedToken, error)
+
+	// Password Reset Operations
+	CreatePasswordReset(ctx context.Context, reset PasswordReset) error
+	ConsumePasswordReset(ctx context.Context, tokenHash string, now time.Time) (*PasswordReset, error)
+
+	// Profile Operations
+	// A user who has saved nothing yet gets an empty profile rather than ErrNotFound
+	GetProfile(ctx context.Context, userID primitive.ObjectID) (*UserProfile, error)
+	UpdateProfile(ctx context.Context, userID primitive.ObjectID, name, phone string) (*UserProfile, error)
+	AddAddress(ctx context.Context, userID primitive.ObjectID, address Address) (*UserProfile, error)
+	UpdateAddress(ctx context.Context, userID primitive.ObjectID, address Address) (*UserProfile, error)
+	SetDefaultAddress(ctx context.Context, userID, addressID primitive.ObjectID) (*UserProfile, error)
+	DeleteAddress(ctx context.Context, userID, addressID primitive.ObjectID) (*UserProfile, error)
 
 	// Order Operations
 	CreateOrder(ctx context.Context, order Order) error
@@ -186,15 +389,37 @@ type ShopCalls interface {
 
 	// Coupon Operations
 	// ValidateCoupon(code string, amount float64) (*Coupon, error)
-	ApplyCoupon(code string, orderID primitive.ObjectID) error
+	ApplyCoupon(ctx context.Context, code string, orderID primitive.ObjectID) error
 }
 
-func NewMongoClient(client *mongo.Client) *MongoClient {
-	return &MongoClient{client: client}
+// MongoClient must keep satisfying ShopCalls
+var _ ShopCalls = (*MongoClient)(nil)
+
+// NewMongoClient returns a repository that keeps its collections in the
+// database named by cfg, so several environments or storefronts can share a
+// cluster by using different database names or collection prefixes
+func NewMongoClient(client *mongo.Client, cfg config.MongoConfig) *MongoClient {
+	return &MongoClient{client: client, dbName: cfg.Database, collections: cfg.ResolvedCollections()}
 }
 
 type MongoClient struct {
-	client *mongo.Client
+	client      *mongo.Client
+	dbName      string
+	collections config.CollectionNames
 }
 
-var validate = validator.New()
+// Ping checks that the primary is reachable
+func (m *MongoClient) Ping(ctx context.Context) error {
+	if m.client == nil {
+		return fmt.Errorf("MongoDB client is not initialized")
+	}
+	if err := m.client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("failed to ping MongoDB: %v", err)
+	}
+	return nil
+}
+
+// database returns the database holding the shop's collections
+func (m *MongoClient) database() *mongo.Database {
+	return m.client.Database(m.dbName)
+}
@@ -0,0 +1,311 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateUser stores a new account. The email is unique: a second account with
+// the same address is a conflict.
+func (m *MongoClient) CreateUser(ctx context.Context, user User) (*User, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	now := time.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	collectionRef := m.database().Collection(m.collections.Users)
+	if _, err := collectionRef.InsertOne(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, Conflict("An account with this email already exists")
+		}
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+	return &user, nil
+}
+
+func (m *MongoClient) GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	return m.findUser(ctx, bson.M{"_id": id})
+}
+
+func (m *MongoClient) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return m.findUser(ctx, bson.M{"email": email})
+}
+
+func (m *MongoClient) findUser(ctx context.Context, filter bson.M) (*User, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	var user User
+	err := m.database().Collection(m.collections.Users).FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, NotFound("User not found")
+		}
+		return nil, fmt.Errorf("failed to retrieve user: %v", err)
+	}
+	return &user, nil
+}
+
+func (m *MongoClient) UpdateUserPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+	if m.client == nil {
+		return fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{"password_hash": passwordHash, "updated_at": time.Now()}}
+	result, err := m.database().Collection(m.collections.Users).UpdateByID(ctx, id, update)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return NotFound("User not found")
+	}
+	return nil
+}
+
+// Session Operations
+
+func (m *MongoClient) CreateSession(ctx context.Context, session UserSession) (*UserSession, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+	if _, err := m.database().Collection(m.collections.Sessions).InsertOne(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+	return &session, nil
+}
+
+func (m *MongoClient) GetSession(ctx context.Context, id primitive.ObjectID) (*UserSession, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	var session UserSession
+	err := m.database().Collection(m.collections.Sessions).FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, NotFound("Session not found")
+		}
+		return nil, fmt.Errorf("failed to retrieve session: %v", err)
+	}
+	return &session, nil
+}
+
+// RotateSession replaces the session's refresh token hash, provided it is
+// still oldHash and the session is not revoked. Anything else means the old
+// token was already used and is reported as a conflict.
+func (m *MongoClient) RotateSession(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, accessTokens []IssuedToken, usedAt time.Time) error {
+	if m.client == nil {
+		return fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	filter := bson.M{"_id": id, "refresh_token_hash": oldHash, "revoked_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{
+		"refresh_token_hash": newHash,
+		"access_tokens":      accessTokens,
+		"last_used_at":       usedAt,
+	}}
+	result, err := m.database().Collection(m.collections.Sessions).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to rotate session: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return Conflict("Refresh token has already been used")
+	}
+	return nil
+}
+
+// ListUserSessions returns the user's sessions that are neither revoked nor
+// expired at now, most recently used first
+func (m *MongoClient) ListUserSessions(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]UserSession, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "last_used_at", Value: -1}})
+	cursor, err := m.database().Collection(m.collections.Sessions).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+	sessions := []UserSession{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode sessions: %v", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes one session and returns it with the access tokens it
+// was given last. A revoked session cannot be rotated, so no token issued for
+// it is missing. Revoking it again keeps the first time.
+func (m *MongoClient) RevokeSession(ctx context.Context, id primitive.ObjectID, revokedAt time.Time) (*UserSession, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	session, err := m.revokeOpenSession(ctx, bson.M{"_id": id}, revokedAt)
+	if err == mongo.ErrNoDocuments {
+		// Already revoked, or not a session at all
+		return m.GetSession(ctx, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke session: %v", err)
+	}
+	return session, nil
+}
+
+// RevokeUserSessions revokes every session of the user still open and returns
+// them. They are revoked one at a time so each comes back with the access
+// tokens it held when it was revoked, even if it was refreshed meanwhile.
+func (m *MongoClient) RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, revokedAt time.Time) ([]UserSession, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	revoked := []UserSession{}
+	for {
+		session, err := m.revokeOpenSession(ctx, bson.M{"user_id": userID}, revokedAt)
+		if err == mongo.ErrNoDocuments {
+			return revoked, nil
+		}
+		if err != nil {
+			return revoked, fmt.Errorf("failed to revoke sessions: %v", err)
+		}
+		revoked = append(revoked, *session)
+	}
+}
+
+// revokeOpenSession revokes a session matching filter that is not revoked yet
+// and returns it as revoked
+func (m *MongoClient) revokeOpenSession(ctx context.Context, filter bson.M, revokedAt time.Time) (*UserSession, error) {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var session UserSession
+	err := m.database().Collection(m.collections.Sessions).FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"revoked_at": revokedAt}}, opts,
+	).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Token Denylist Operations
+
+// AddRevokedTokens stores denylist entries. Revoking a token twice keeps the first entry.
+func (m *MongoClient) AddRevokedTokens(ctx context.Context, tokens []RevokedToken) error {
+	if m.client == nil {
+		return fmt.Errorf("MongoDB client is not initialized")
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(tokens))
+	for i, token := range tokens {
+		docs[i] = token
+	}
+	_, err := m.database().Collection(m.collections.Denylist).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil && !isOnlyDuplicateKeys(err) {
+		return fmt.Errorf("failed to revoke tokens: %v", err)
+	}
+	return nil
+}
+
+// ListRevokedTokens returns the entries revoked since the given time whose
+// tokens have not expired by now
+func (m *MongoClient) ListRevokedTokens(ctx context.Context, since, now time.Time) ([]RevokedToken, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	filter := bson.M{"revoked_at": bson.M{"$gte": since}, "expires_at": bson.M{"$gt": now}}
+	cursor, err := m.database().Collection(m.collections.Denylist).Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revoked tokens: %v", err)
+	}
+	tokens := []RevokedToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode revoked tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+// isOnlyDuplicateKeys reports whether every write of an unordered bulk insert
+// failed because the document was already there
+func isOnlyDuplicateKeys(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+// Password Reset Operations
+
+func (m *MongoClient) CreatePasswordReset(ctx context.Context, reset PasswordReset) error {
+	if m.client == nil {
+		return fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	if reset.ID.IsZero() {
+		reset.ID = primitive.NewObjectID()
+	}
+	if _, err := m.database().Collection(m.collections.Resets).InsertOne(ctx, reset); err != nil {
+		return fmt.Errorf("failed to create password reset: %v", err)
+	}
+	return nil
+}
+
+// ConsumePasswordReset marks the unused, unexpired reset with the token hash as
+// used and returns it. The update is atomic so a token works only once even
+// when two requests race with it.
+func (m *MongoClient) ConsumePasswordReset(ctx context.Context, tokenHash string, now time.Time) (*PasswordReset, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("MongoDB client is not initialized")
+	}
+
+	filter := bson.M{
+		"token_hash": tokenHash,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var reset PasswordReset
+	err := m.database().Collection(m.collections.Resets).
+		FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"used_at": now}}, opts).
+		Decode(&reset)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, NotFound("Password reset token is invalid or expired")
+		}
+		return nil, fmt.Errorf("failed to use password reset: %v", err)
+	}
+	return &reset, nil
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUsersAndSessions(t *testing.T) {
+	m := newTestMongoClient(t)
+	ctx := context.Background()
+	// The unique email index is what turns a second sign-up into a conflict
+	if _, err := m.EnsureIndexes(ctx, 0, false); err != nil {
+		t.Fatalf("EnsureIndexes failed: %v", err)
+	}
+
+	user, err := m.CreateUser(ctx, User{Email: "shopper@example.com", Role: "customer", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := m.CreateUser(ctx, User{Email: "shopper@example.com"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("got error %v for a duplicate email, want ErrConflict", err)
+	}
+	if found, err := m.GetUserByEmail(ctx, "shopper@example.com"); err != nil || found.ID != user.ID {
+		t.Fatalf("GetUserByEmail returned %+v, %v", found, err)
+	}
+
+	now := time.Now().Truncate(time.Millisecond)
+	var sessions []*UserSession
+	for i := 0; i < 2; i++ {
+		session, err := m.CreateSession(ctx, UserSession{UserID: user.ID, RefreshTokenHash: "refresh-hash", CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)})
+		if err != nil {
+			t.Fatalf("CreateSession failed: %v", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	// A refresh token rotates once; the second use of the old one conflicts
+	issued := []IssuedToken{{JTI: "access-jti", ExpiresAt: now.Add(time.Minute)}}
+	if err := m.RotateSession(ctx, sessions[1].ID, "refresh-hash", "next-hash", issued, now); err != nil {
+		t.Fatalf("RotateSession failed: %v", err)
+	}
+	if err := m.RotateSession(ctx, sessions[1].ID, "refresh-hash", "other-hash", nil, now); !errors.Is(err, ErrConflict) {
+		t.Fatalf("got error %v for a rotated token, want ErrConflict", err)
+	}
+	if rotated, err := m.GetSession(ctx, sessions[1].ID); err != nil || rotated.RefreshTokenHash != "next-hash" || len(rotated.AccessTokens) != 1 {
+		t.Fatalf("GetSession returned %+v, %v, want the rotated session", rotated, err)
+	}
+
+	if revoked, err := m.RevokeSession(ctx, sessions[0].ID, now); err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("RevokeSession returned %+v, %v, want the revoked session", revoked, err)
+	}
+	open, err := m.ListUserSessions(ctx, user.ID, now)
+	if err != nil || len(open) != 1 || open[0].ID != sessions[1].ID {
+		t.Fatalf("ListUserSessions returned %+v, %v, want only the second session", open, err)
+	}
+	// The session comes back with the access token its rotation stored
+	if revoked, err := m.RevokeUserSessions(ctx, user.ID, now); err != nil || len(revoked) != 1 || len(revoked[0].AccessTokens) != 1 {
+		t.Fatalf("RevokeUserSessions returned %+v, %v, want the second session", revoked, err)
+	}
+
+	// Revoking a token twice keeps one entry, and expired ones are not listed
+	revoked := []RevokedToken{
+		{JTI: "access-jti", ExpiresAt: now.Add(time.Minute), RevokedAt: now, Reason: "logout"},
+		{JTI: "stale-jti", ExpiresAt: now.Add(-time.Minute), RevokedAt: now, Reason: "logout"},
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.AddRevokedTokens(ctx, revoked); err != nil {
+			t.Fatalf("AddRevokedTokens failed: %v", err)
+		}
+	}
+	if listed, err := m.ListRevokedTokens(ctx, now.Add(-time.Minute), now); err != nil || len(listed) != 1 || listed[0].JTI != "access-jti" {
+		t.Fatalf("ListRevokedTokens returned %+v, %v, want only access-jti", listed, err)
+	}
+
+	// A reset token works once
+	reset := PasswordReset{UserID: user.ID, TokenHash: "token-hash", ExpiresAt: now.Add(time.Hour), CreatedAt: now}
+	if err := m.CreatePasswordReset(ctx, reset); err != nil {
+		t.Fatalf("CreatePasswordReset failed: %v", err)
+	}
+	if used, err := m.ConsumePasswordReset(ctx, "token-hash", now); err != nil || used.UserID != user.ID {
+		t.Fatalf("ConsumePasswordReset returned %+v, %v", used, err)
+	}
+	if _, err := m.ConsumePasswordReset(ctx, "token-hash", now); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got error %v for a used token, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,18 @@
+package notifier
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogNotifier writes messages to the standard logger instead of delivering them
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) Send(ctx context.Context, msg Message) error {
+	slog.Default().Info("Notification", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
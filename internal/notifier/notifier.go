@@ -0,0 +1,33 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joshuatakyi/shop/internal/config"
+)
+
+// Message is a single notification addressed to one recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to shoppers. Implementations must be safe for concurrent use.
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New picks the notifier named by cfg.Kind ("smtp" or "log").
+// The log notifier is the default so local development never sends real mail.
+func New(cfg config.NotifierConfig) (Notifier, error) {
+	switch cfg.Kind {
+	case "", "log":
+		return NewLogNotifier(), nil
+	case "smtp":
+		return NewSMTPNotifier(cfg.SMTP)
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", cfg.Kind)
+	}
+}
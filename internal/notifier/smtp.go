@@ -0,0 +1,69 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/joshuatakyi/shop/internal/config"
+)
+
+// SMTPNotifier sends messages as plain text email through an SMTP relay
+type SMTPNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// NewSMTPNotifier builds a notifier from the SMTP settings
+func NewSMTPNotifier(cfg config.SMTPConfig) (*SMTPNotifier, error) {
+	n := &SMTPNotifier{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		From:     cfg.From,
+	}
+	if n.Port == "" {
+		n.Port = "587"
+	}
+	if n.Host == "" || n.From == "" {
+		return nil, fmt.Errorf("SMTP_HOST and SMTP_FROM must be set to use the smtp notifier")
+	}
+	return n, nil
+}
+
+func (n *SMTPNotifier) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", n.From)
+	fmt.Fprintf(&body, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&body, "Subject: %s\r\n", msg.Subject)
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	body.WriteString(msg.Body)
+
+	// net/smtp has no context support, so run the send and give up when ctx is done
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(net.JoinHostPort(n.Host, n.Port), auth, n.From, []string{msg.To}, []byte(body.String()))
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("error sending email: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
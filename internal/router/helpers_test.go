@@ -0,0 +1,349 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joshuatakyi/shop/internal/config"
+	"github.com/joshuatakyi/shop/internal/database"
+	"github.com/joshuatakyi/shop/internal/memstore"
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/joshuatakyi/shop/internal/notifier"
+	"github.com/joshuatakyi/shop/internal/services"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testJWTSecret = "test-jwt-secret"
+
+// fixture is a router wired to an in-memory store and a fake Paystack, with
+// a few products and users to run requests against
+type fixture struct {
+	e        *echo.Echo
+	handler  *database.Handler
+	store    *memstore.Store
+	paystack *httptest.Server
+	payments *paystackLog  // Transactions the fake Paystack was asked to start
+	logs     *bytes.Buffer // JSON log lines written while serving requests
+	mail     *mailbox      // Messages sent by the account service
+
+	product models.Product // in stock
+	soldOut models.Product // stock 0
+
+	userID  primitive.ObjectID
+	otherID primitive.ObjectID
+	adminID primitive.ObjectID
+
+	// Set by setup helpers such as withComment and withCartItem
+	commentID  primitive.ObjectID
+	cartItemID primitive.ObjectID
+}
+
+// newFixture builds the router. Options can change the config first, e.g. to
+// turn on rate limits.
+func newFixture(t *testing.T, options ...func(*config.Config)) *fixture {
+	t.Helper()
+	f := &fixture{
+		store:    memstore.New(),
+		userID:   primitive.NewObjectID(),
+		otherID:  primitive.NewObjectID(),
+		adminID:  primitive.NewObjectID(),
+		logs:     &bytes.Buffer{},
+		mail:     &mailbox{},
+		payments: &paystackLog{},
+	}
+	f.product = f.addProduct(t, "Clear Case", 20, 10)
+	f.soldOut = f.addProduct(t, "Leather Case", 50, 1)
+	// Validation rejects a zero stock on creation, so sell the last one afterwards
+	if _, err := f.store.UpdateProduct(context.Background(), f.soldOut.ID, map[string]interface{}{"stock": 0}); err != nil {
+		t.Fatalf("failed to sell out product: %v", err)
+	}
+
+	f.payments.payer = f.userID.Hex()
+	f.paystack = newFakePaystack(t, f.payments)
+	payments := &services.PaymentService{SecretKey: "sk_test", PublicKey: "pk_test", BaseURL: f.paystack.URL}
+
+	cfg := &config.Config{
+		Env:         config.EnvTest,
+		FrontendURL: "http://localhost:3000",
+		Auth: config.AuthConfig{
+			JWTSecret:        testJWTSecret,
+			GuestCartSecret:  "test-guest-cart-secret",
+			CSRFSecret:       "test-csrf-secret",
+			NativeUsers:      true,
+			AccessTokenTTL:   15 * time.Minute,
+			RefreshTokenTTL:  24 * time.Hour,
+			PasswordResetTTL: time.Hour,
+		},
+	}
+	for _, option := range options {
+		option(cfg)
+	}
+	f.handler = database.NewHandler(f.store, payments)
+	auth, err := services.NewAuthService(cfg.Auth, cfg.FrontendURL, f.store, f.mail)
+	if err != nil {
+		t.Fatalf("failed to configure accounts: %v", err)
+	}
+	auth.PasswordCost = bcrypt.MinCost
+	f.handler.Auth = auth
+	logger := slog.New(slog.NewJSONHandler(f.logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	f.e = Router(f.handler, cfg, logger)
+	f.e.Logger.SetOutput(io.Discard)
+	return f
+}
+
+func (f *fixture) addProduct(t *testing.T, title string, price float64, stock int) models.Product {
+	t.Helper()
+	ctx := context.Background()
+	id, err := f.store.AddProduct(ctx, models.Product{
+		Title:       title,
+		Description: "A product used by the router tests to exercise the API",
+		Price:       price,
+		Category:    []string{"cases"},
+		Images:      []string{"https://images.example.com/" + strings.ToLower(strings.ReplaceAll(title, " ", "-")) + ".jpg"},
+		Tags:        []string{"test"},
+		Models:      []string{"iPhone 15"},
+		Colors:      []string{"black"},
+		Materials:   []string{"plastic"},
+		Details:     []string{"detail"},
+		Features:    []string{"feature"},
+		Stock:       stock,
+	})
+	if err != nil {
+		t.Fatalf("failed to add product: %v", err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	product, err := f.store.GetProductByID(ctx, objectID)
+	if err != nil {
+		t.Fatalf("failed to load product: %v", err)
+	}
+	return *product
+}
+
+// token mints a JWT signed with the test secret. An empty role or email is left out of the claims.
+func token(t *testing.T, userID primitive.ObjectID, email, role string, expiresIn time.Duration) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"sub": userID.Hex(),
+		"exp": time.Now().Add(expiresIn).Unix(),
+	}
+	if email != "" {
+		claims["email"] = email
+	}
+	if role != "" {
+		claims["role"] = role
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+// authHeader returns the Authorization header for one of the fixture's identities:
+// "user", "other", "admin", "staff", "support", "expired" or "forged". Anything
+// else sends no header.
+func (f *fixture) authHeader(t *testing.T, who string) string {
+	switch who {
+	case "user":
+		return "Bearer " + token(t, f.userID, "user@example.com", "user", time.Hour)
+	case "other":
+		return "Bearer " + token(t, f.otherID, "other@example.com", "user", time.Hour)
+	case "admin":
+		return "Bearer " + token(t, f.adminID, "admin@example.com", "admin", time.Hour)
+	case "staff", "support":
+		return "Bearer " + token(t, primitive.NewObjectID(), who+"@example.com", who, time.Hour)
+	case "expired":
+		return "Bearer " + token(t, f.userID, "user@example.com", "user", -time.Hour)
+	case "forged":
+		forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": f.adminID.Hex(), "role": "admin"}).SignedString([]byte("wrong-secret"))
+		return "Bearer " + forged
+	}
+	return ""
+}
+
+// do sends a request through the router. Headers are given as name/value pairs.
+func (f *fixture) do(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		if headers[i+1] != "" {
+			req.Header.Add(headers[i], headers[i+1])
+		}
+	}
+	rec := httptest.NewRecorder()
+	f.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// logLines decodes the JSON log lines written so far
+func (f *fixture) logLines(t *testing.T) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	dec := json.NewDecoder(bytes.NewReader(f.logs.Bytes()))
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatalf("failed to decode log line: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// cookie returns the named cookie set by the response
+// csrf fetches a CSRF token as a browser sending cookies would. It returns
+// the token and the cookies to send it with: those given, plus the ones the
+// response set, such as a new guest cart.
+func (f *fixture) csrf(t *testing.T, cookies string) (token, withCookies string) {
+	t.Helper()
+	rec := f.do("GET", "/api/v1/csrf", "", "Cookie", cookies)
+	var issued struct {
+		Token string `json:"csrf_token"`
+	}
+	decode(t, rec, &issued)
+	if issued.Token == "" {
+		t.Fatalf("no CSRF token was issued: %s", rec.Body.String())
+	}
+	jar := []string{}
+	if cookies != "" {
+		jar = append(jar, cookies)
+	}
+	for _, c := range rec.Result().Cookies() {
+		jar = append(jar, c.Name+"="+c.Value)
+	}
+	return issued.Token, strings.Join(jar, "; ")
+}
+
+func cookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, into any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), into); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// Paystack references understood by the fake server
+const (
+	refSuccess = "ref_success"
+	refFailed  = "ref_failed"
+	refPending = "ref_pending"
+)
+
+// paystackLog keeps the transactions sent to the fake Paystack
+type paystackLog struct {
+	mu           sync.Mutex
+	transactions []services.PaystackTransactionRequest
+	payer        string // user_id in the metadata of verified transactions
+}
+
+func (l *paystackLog) last() (services.PaystackTransactionRequest, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.transactions) == 0 {
+		return services.PaystackTransactionRequest{}, false
+	}
+	return l.transactions[len(l.transactions)-1], true
+}
+
+// newFakePaystack impersonates the Paystack initialize and verify endpoints
+func newFakePaystack(t *testing.T, log *paystackLog) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /transaction/initialize", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"status": false, "message": "Invalid key"})
+			return
+		}
+		var payload services.PaystackTransactionRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Amount <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"status": false, "message": "Invalid payload"})
+			return
+		}
+		log.mu.Lock()
+		log.transactions = append(log.transactions, payload)
+		log.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":  true,
+			"message": "Authorization URL created",
+			"data": map[string]any{
+				"authorization_url": "https://checkout.paystack.test/abc",
+				"access_code":       "abc",
+				"reference":         refSuccess,
+			},
+		})
+	})
+
+	mux.HandleFunc("GET /transaction/verify/{reference}", func(w http.ResponseWriter, r *http.Request) {
+		statuses := map[string]string{refSuccess: "success", refFailed: "failed", refPending: "pending"}
+		status, ok := statuses[r.PathValue("reference")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]any{"status": false, "message": "Transaction reference not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":  true,
+			"message": "Verification successful",
+			"data": map[string]any{
+				"status":    status,
+				"reference": r.PathValue("reference"),
+				"metadata":  map[string]any{"user_id": log.payer},
+			},
+		})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// mailbox is a notifier that keeps what it sends
+type mailbox struct {
+	mu   sync.Mutex
+	sent []notifier.Message
+}
+
+func (m *mailbox) Send(ctx context.Context, msg notifier.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *mailbox) messages() []notifier.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]notifier.Message(nil), m.sent...)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -2,29 +2,50 @@
 package router
 
 import (
-	"fmt"
-	"os"
+	"log/slog"
+	"strings"
 
+	"github.com/joshuatakyi/shop/internal/config"
 	"github.com/joshuatakyi/shop/internal/database"
+	"github.com/joshuatakyi/shop/internal/logging"
+	"github.com/joshuatakyi/shop/internal/metrics"
 	"github.com/joshuatakyi/shop/internal/middleware"
-	"github.com/joshuatakyi/shop/internal/models"
 	"github.com/labstack/echo/v4"
 	echomiddleware "github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 )
 
-func Router() *echo.Echo {
+// Router builds the Echo instance serving the API with the given handlers.
+// Requests are logged to logger, one structured line each.
+func Router(h *database.Handler, cfg *config.Config, logger *slog.Logger) *echo.Echo {
 	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	// Every error, from handlers, middleware or Echo itself, is written as one JSON envelope
+	e.HTTPErrorHandler = database.HTTPErrorHandler
+	e.IPExtractor = clientIPExtractor(cfg, logger)
 
 	// Middleware
-	e.Use(echomiddleware.Logger())
-	e.Use(echomiddleware.Recover())
+	// Tracing comes first so the request's span covers everything else and the
+	// request ID middleware can log its trace ID. Probes and scrapes are not traced.
+	e.Use(otelecho.Middleware(cfg.Tracing.ServiceName, otelecho.WithSkipper(func(c echo.Context) bool {
+		path := c.Request().URL.Path
+		return path == "/metrics" || strings.HasPrefix(path, "/api/v1/health")
+	})))
+	e.Use(middleware.RequestID(logger))
+	e.Use(metrics.Middleware())
+	e.Use(middleware.RequestLogger())
+	e.Use(echomiddleware.RecoverWithConfig(echomiddleware.RecoverConfig{
+		LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
+			logging.FromContext(c.Request().Context()).Error("Recovered from panic", "route", c.Path(), "error", err, "stack", string(stack))
+			return err
+		},
+	}))
 	// Remove the default CORS middleware as we're using a custom configuration below
 
-	frontendUrl := os.Getenv("NEXT_API_URL")
-	if frontendUrl == "" {
-		frontendUrl = "http://localhost:3000" // Default to localhost if not set
-		fmt.Println("NEXT_API_URL not set, using default:", frontendUrl)
-	}
+	frontendUrl := cfg.FrontendURL
+	guestCartSecret := []byte(cfg.Auth.GuestCartSecret)
+	csrfSecret := []byte(cfg.Auth.CSRFSecret)
 
 	e.Use(echomiddleware.CORSWithConfig(echomiddleware.CORSConfig{
 		AllowOrigins:     []string{frontendUrl},                                                                             // Allow specific origin (frontend URL)
@@ -32,52 +53,160 @@ func Router() *echo.Echo {
 		AllowHeaders:     []string{"Content-Type", "Authorization", "Accept", "Origin", "X-Requested-With", "X-CSRF-Token"}, // Extended allowed headers
 		AllowCredentials: true,                                                                                              // Allow credentials (cookies, authorization headers, etc.)
 		MaxAge:           86400,                                                                                             // Cache preflight requests for 24 hours
+		// Let the frontend read the rate limit headers and back off
+		ExposeHeaders: []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
 	}))
+
+	// Prometheus scrapes this outside the versioned API; keep it off the public
+	// internet at the load balancer
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+
 	v1 := e.Group("/api/v1")
 
-	// healthcheck
-	v1.GET("/health", func(c echo.Context) error {
-		return c.JSON(200, map[string]string{
-			"status":  "ok",
-			"message": "Server is running",
-		})
-	})
+	// Health checks: liveness only says the process is up, readiness also
+	// pings the database and fails while the server drains for shutdown
+	v1.GET("/health", h.Liveness)
+	v1.GET("/health/live", h.Liveness)
+	v1.GET("/health/ready", h.Readiness)
+
+	// Rate limits: every route registered below counts against the API-wide
+	// policy by client IP, so probes above are never limited. Routes that are
+	// expensive or worth abusing add a tighter policy of their own.
+	limiter := middleware.NewRateLimiter(cfg.RateLimit, h.RateLimits)
+	v1.Use(limiter.Limit(config.RateLimitAPI))
 
 	// Public routes for products
-	v1.GET("/products", database.ListProducts)
-	v1.GET("/filter_products", database.FilterProducts) // New endpoint for filtered product queries
-	v1.GET("/get_product_by_slug/:slug", database.GetProductBySlug)
-	v1.GET("/get_product_by_id/:id", database.GetProductByID)
-	v1.POST("/get_similar_products", database.GetSimilarProducts)
+	v1.GET("/products", h.ListProducts)
+	v1.GET("/filter_products", h.FilterProducts, limiter.Limit(config.RateLimitSearch)) // New endpoint for filtered product queries
+	v1.GET("/get_product_by_slug/:slug", h.GetProductBySlug)
+	v1.GET("/get_product_by_id/:id", h.GetProductByID)
+	v1.POST("/get_similar_products", h.GetSimilarProducts)
+
+	// Guest cart routes - anonymous shoppers are identified by a signed cart cookie
+	// and their cart is merged into the user's cart once they sign in
+	guest := v1.Group("/guest")
+	guest.Use(middleware.GuestCartMiddleware(guestCartSecret))
+	// The guest cart is merged into the shopper's own at sign-in, so another
+	// site must not be able to fill it
+	guest.Use(middleware.CSRFProtect(csrfSecret))
+	{
+		guest.POST("/add_to_cart", h.AddToCart)
+		guest.GET("/get_cart", h.GetUserCart)
+		guest.PATCH("/update_cart", h.UpdateCart)
+		guest.DELETE("/clear_cart", h.ClearCart)
+		guest.DELETE("/remove_from_cart", h.RemoveCartItem)
+	}
+
+	authConfig := middleware.AuthConfig{
+		JWTSecret:   cfg.Auth.JWTSecret,
+		Keys:        middleware.NewKeySet(cfg.Auth.JWKSURL, cfg.Auth.JWKSFile, cfg.Auth.JWKSRefresh),
+		Issuer:      cfg.Auth.Issuer,
+		Audience:    cfg.Auth.Audience,
+		NextAuthURL: cfg.FrontendURL,
+		Sessions: middleware.NewSessionVerifier(cfg.FrontendURL, middleware.SessionCacheConfig{
+			TTL:  cfg.Auth.SessionCacheTTL,
+			Size: cfg.Auth.SessionCacheSize,
+		}),
+	}
+	if h.Auth != nil {
+		// Access tokens of revoked sessions stop working before they expire
+		authConfig.Denylist = h.Auth.Denylist
+	}
+
+	// Browsers fetch the token CSRFProtect expects, for the user their cookie
+	// signs in or else for their guest cart, and fetch it again after signing in
+	v1.GET("/csrf", middleware.CSRFToken(csrfSecret), middleware.OptionalAuth(authConfig), middleware.GuestCartMiddleware(guestCartSecret))
+
+	// ACCOUNT ROUTES
+	// Only served when the API owns user accounts instead of the Next.js app
+	if cfg.Auth.NativeUsers {
+		// Guessing passwords and mailing reset links are limited by client IP
+		authLimit := limiter.Limit(config.RateLimitAuth)
+		account := v1.Group("/auth")
+		account.POST("/register", h.Register, authLimit)
+		account.POST("/login", h.Login, authLimit)
+		account.POST("/refresh", h.RefreshToken, authLimit)
+		account.POST("/logout", h.Logout)
+		account.POST("/forgot_password", h.ForgotPassword, authLimit)
+		account.POST("/reset_password", h.ResetPassword, authLimit)
+
+		signedIn := account.Group("", middleware.AuthMiddleware(authConfig), middleware.CSRFProtect(csrfSecret))
+		signedIn.POST("/logout_all", h.LogoutEverywhere)
+		signedIn.GET("/sessions", h.ListSessions)
+		signedIn.DELETE("/sessions/:id", h.RevokeSession)
+	}
+
+	// ADMIN ROUTES
+	// Only staff roles get into the group at all; each route then checks the
+	// permission it needs against the matrix in middleware/rbac.go
+	admin := v1.Group("/admin")
+	admin.Use(middleware.AuthMiddleware(authConfig))
+	admin.Use(middleware.CSRFProtect(csrfSecret))
+	admin.Use(middleware.RequireRole(middleware.RoleAdmin, middleware.RoleStaff, middleware.RoleSupport))
+	{
+		admin.POST("/create_product", h.CreateProduct, middleware.RequirePermission(middleware.PermProductsWrite))
+		admin.PATCH("/update_product/:id", h.UpdateProduct, middleware.RequirePermission(middleware.PermProductsWrite))
+		admin.DELETE("/delete_product", h.DeleteProduct, middleware.RequirePermission(middleware.PermProductsDelete))
+		admin.GET("/cart_stats", h.GetCartStats, middleware.RequirePermission(middleware.PermCartsStats))
+		admin.GET("/get_comments/:id", h.GetComments, middleware.RequirePermission(middleware.PermCommentsRead))
+	}
 
 	// PROTECTED ROUTES
 	protected := v1.Group("/protected")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(authConfig))
+	protected.Use(middleware.CSRFProtect(csrfSecret))
+	protected.Use(middleware.MergeGuestCart(guestCartSecret, h.MergeGuestCart))
 
 	{
-		// admin routes
-		protected.POST("/create_product", database.CreateProduct)
-		protected.PATCH("/update_product/:id", database.UpdateProduct)
-		protected.DELETE("/delete_product", database.DeleteProduct)
-
 		// comment routes
-		protected.GET("/verify", database.VerifySession)
-		protected.POST("/add_comment/:id", database.AddComment)
-		protected.GET("/get_comments/:id", database.GetComments)
-		protected.PATCH("/delete_comment", database.DeleteComment)
+		protected.GET("/verify", h.VerifySession)
+		protected.POST("/add_comment/:id", h.AddComment, limiter.Limit(config.RateLimitComments))
+		protected.PATCH("/delete_comment", h.DeleteComment)
 
 		// Cart routes
-		protected.POST("/add_to_cart", database.AddToCart)
-		protected.GET("/get_cart", database.GetUserCart)
-		protected.PATCH("/update_cart", database.UpdateCart)
-		protected.DELETE("/clear_cart", database.ClearCart)
-		protected.DELETE("/remove_from_cart", database.RemoveCartItem)
+		protected.POST("/add_to_cart", h.AddToCart)
+		protected.GET("/get_cart", h.GetUserCart)
+		protected.PATCH("/update_cart", h.UpdateCart)
+		protected.DELETE("/clear_cart", h.ClearCart)
+		protected.DELETE("/remove_from_cart", h.RemoveCartItem)
+
+		// Profile and address book
+		protected.GET("/profile", h.GetProfile)
+		protected.PATCH("/profile", h.UpdateProfile)
+		protected.GET("/addresses", h.ListAddresses)
+		protected.POST("/addresses", h.AddAddress)
+		protected.PUT("/addresses/:id", h.UpdateAddress)
+		protected.POST("/addresses/:id/default", h.SetDefaultAddress)
+		protected.DELETE("/addresses/:id", h.DeleteAddress)
 
 		// payment routes
-		protected.POST("/checkout", models.InitializeCheckout)
-		protected.GET("/verifyPayment", models.VerifyTransaction)
+		// Route limits run after AuthMiddleware, so they count per user
+		checkoutLimit := limiter.Limit(config.RateLimitCheckout)
+		protected.POST("/checkout", h.InitializeCheckout, checkoutLimit)
+		protected.GET("/verifyPayment", h.VerifyTransaction, checkoutLimit)
 
 	}
 
 	return e
 }
+
+// clientIPExtractor decides where c.RealIP(), and so rate limits and session
+// records, take the client IP from. Headers a client can set are only read
+// when the connection comes from a trusted proxy; the rightmost address in
+// X-Forwarded-For that is not one of them is the client.
+func clientIPExtractor(cfg *config.Config, logger *slog.Logger) echo.IPExtractor {
+	ranges, err := cfg.ProxyRanges()
+	if err != nil {
+		// Validate refuses this at startup
+		logger.Error("Ignoring trusted proxies", "error", err)
+	}
+	if len(ranges) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, ipRange := range ranges {
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
@@ -0,0 +1,1080 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"github.com/joshuatakyi/shop/internal/database"
+	"github.com/joshuatakyi/shop/internal/middleware"
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/joshuatakyi/shop/internal/services"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// routeCase is one request against a fresh fixture. Path and body may use the
+// placeholders {product}, {soldOut}, {slug}, {missing}, {comment} and {item}.
+type routeCase struct {
+	name   string
+	method string
+	path   string
+	body   string
+	as     string // identity passed to fixture.authHeader
+	setup  func(t *testing.T, f *fixture)
+	want   int
+	check  func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder)
+}
+
+func runRouteCases(t *testing.T, cases []routeCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			if tc.setup != nil {
+				tc.setup(t, f)
+			}
+
+			r := strings.NewReplacer(
+				"{product}", f.product.ID.Hex(),
+				"{soldOut}", f.soldOut.ID.Hex(),
+				"{slug}", f.product.Slug,
+				"{missing}", primitive.NewObjectID().Hex(),
+				"{comment}", f.commentID.Hex(),
+				"{item}", f.cartItemID.Hex(),
+			)
+			rec := f.do(tc.method, r.Replace(tc.path), r.Replace(tc.body), "Authorization", f.authHeader(t, tc.as))
+			if rec.Code != tc.want {
+				t.Fatalf("%s %s: got status %d, want %d, body: %s", tc.method, tc.path, rec.Code, tc.want, rec.Body.String())
+			}
+			if tc.check != nil {
+				tc.check(t, f, rec)
+			}
+		})
+	}
+}
+
+// withComment adds a comment by the fixture user to the in-stock product
+func withComment(t *testing.T, f *fixture) {
+	t.Helper()
+	ctx := context.Background()
+	if err := f.store.AddComment(ctx, models.Comments{Comment: "Great case"}, f.userID.Hex(), f.product.ID); err != nil {
+		t.Fatalf("failed to add comment: %v", err)
+	}
+	comments, _ := f.store.GetCommentsByProductID(ctx, f.product.ID)
+	f.commentID = comments[0].ID
+}
+
+// withCartItem puts two of the in-stock product in the fixture user's cart
+func withCartItem(t *testing.T, f *fixture) {
+	t.Helper()
+	ctx := context.Background()
+	if err := f.store.AddToCart(ctx, f.userID, models.CartItem{ProductID: f.product.ID, Quantity: 2, Color: "black"}); err != nil {
+		t.Fatalf("failed to add cart item: %v", err)
+	}
+	cart, _ := f.store.GetUserCart(ctx, f.userID)
+	f.cartItemID = cart.Items[0].ID
+}
+
+func userCart(t *testing.T, f *fixture) *models.Cart {
+	t.Helper()
+	cart, err := f.store.GetUserCart(context.Background(), f.userID)
+	if err != nil {
+		t.Fatalf("failed to load cart: %v", err)
+	}
+	return cart
+}
+
+// errorCode checks that the response is an error envelope with the given code
+func errorCode(code string) func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		var body database.ErrorBody
+		decode(t, rec, &body)
+		if body.Error.Code != code {
+			t.Errorf("got error code %q, want %q: %s", body.Error.Code, code, rec.Body.String())
+		}
+	}
+}
+
+func TestPublicRoutes(t *testing.T) {
+	runRouteCases(t, []routeCase{
+		{name: "health", method: "GET", path: "/api/v1/health", want: 200},
+		{name: "liveness", method: "GET", path: "/api/v1/health/live", want: 200},
+		{name: "readiness", method: "GET", path: "/api/v1/health/ready", want: 200},
+		{
+			name: "list products", method: "GET", path: "/api/v1/products", want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var body struct{ Count int }
+				decode(t, rec, &body)
+				if body.Count != 2 {
+					t.Errorf("got %d products, want 2", body.Count)
+				}
+			},
+		},
+		{
+			name: "filter by search", method: "GET", path: "/api/v1/filter_products?search=clear", want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var body struct {
+					Data struct {
+						TotalCount int              `json:"totalCount"`
+						Products   []models.Product `json:"products"`
+					}
+				}
+				decode(t, rec, &body)
+				if body.Data.TotalCount != 1 || body.Data.Products[0].ID != f.product.ID {
+					t.Errorf("got %+v, want only %s", body.Data, f.product.Title)
+				}
+			},
+		},
+		{
+			name: "filter by price", method: "GET", path: "/api/v1/filter_products?min_price=30&max_price=60", want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var body struct {
+					Data struct {
+						TotalCount int `json:"totalCount"`
+					}
+				}
+				decode(t, rec, &body)
+				if body.Data.TotalCount != 1 {
+					t.Errorf("got %d products, want 1", body.Data.TotalCount)
+				}
+			},
+		},
+		{name: "product by slug", method: "GET", path: "/api/v1/get_product_by_slug/{slug}", want: 200},
+		{name: "unknown slug", method: "GET", path: "/api/v1/get_product_by_slug/nope", want: 404},
+		{name: "product by id", method: "GET", path: "/api/v1/get_product_by_id/{product}", want: 200},
+		{name: "malformed product id", method: "GET", path: "/api/v1/get_product_by_id/nope", want: 400},
+		{name: "unknown product id", method: "GET", path: "/api/v1/get_product_by_id/{missing}", want: 404},
+		{name: "similar products", method: "POST", path: "/api/v1/get_similar_products", body: `{"id":"{product}"}`, want: 200},
+		{name: "similar products without id", method: "POST", path: "/api/v1/get_similar_products", body: `{}`, want: 400},
+		{name: "similar products with malformed id", method: "POST", path: "/api/v1/get_similar_products", body: `{"id":"nope"}`, want: 400},
+		{name: "similar products for unknown product", method: "POST", path: "/api/v1/get_similar_products", body: `{"id":"{missing}"}`, want: 404},
+	})
+}
+
+func TestReadinessWhileDraining(t *testing.T) {
+	f := newFixture(t)
+	f.handler.BeginDrain()
+
+	// Load balancers stop routing to a draining instance, but it is still alive
+	if rec := f.do("GET", "/api/v1/health/ready", ""); rec.Code != 503 {
+		t.Fatalf("got %d from readiness while draining, want 503", rec.Code)
+	}
+	if rec := f.do("GET", "/api/v1/health/live", ""); rec.Code != 200 {
+		t.Fatalf("got %d from liveness while draining, want 200", rec.Code)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	f := newFixture(t)
+
+	// A request without an ID gets one
+	rec := f.do("GET", "/api/v1/products", "")
+	generated := rec.Header().Get(echo.HeaderXRequestID)
+	if generated == "" {
+		t.Fatal("got no X-Request-ID, want a generated one")
+	}
+
+	// An ID from a proxy is kept, and a malformed one replaced
+	rec = f.do("GET", "/api/v1/get_product_by_slug/"+f.product.Slug, "", echo.HeaderXRequestID, "edge-1234")
+	if got := rec.Header().Get(echo.HeaderXRequestID); got != "edge-1234" {
+		t.Fatalf("got X-Request-ID %q, want the one sent", got)
+	}
+	rec = f.do("GET", "/api/v1/products", "", echo.HeaderXRequestID, "bad id\r\n")
+	if got := rec.Header().Get(echo.HeaderXRequestID); got == "" || strings.Contains(got, " ") {
+		t.Fatalf("got X-Request-ID %q, want a generated one", got)
+	}
+
+	// Every request is logged once with its ID and route
+	var requests []map[string]any
+	for _, line := range f.logLines(t) {
+		if line["msg"] == "Request handled" {
+			requests = append(requests, line)
+		}
+	}
+	if len(requests) != 3 {
+		t.Fatalf("got %d request log lines, want 3", len(requests))
+	}
+	if requests[0]["request_id"] != generated || requests[1]["request_id"] != "edge-1234" {
+		t.Errorf("got request IDs %v and %v, want %q and edge-1234", requests[0]["request_id"], requests[1]["request_id"], generated)
+	}
+	if requests[1]["route"] != "/api/v1/get_product_by_slug/:slug" || requests[1]["status"] != float64(200) {
+		t.Errorf("got route %v and status %v logged", requests[1]["route"], requests[1]["status"])
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	f := newFixture(t)
+
+	f.do("GET", "/api/v1/get_product_by_slug/"+f.product.Slug, "")
+	f.do("GET", "/no/such/page", "")
+	body := `{"product_id":"` + f.product.ID.Hex() + `","quantity":1,"color":"black"}`
+	if rec := f.do("POST", "/api/v1/protected/add_to_cart", body, "Authorization", f.authHeader(t, "user")); rec.Code != 201 {
+		t.Fatalf("got %d adding to cart, want 201", rec.Code)
+	}
+
+	rec := f.do("GET", "/metrics", "")
+	if rec.Code != 200 {
+		t.Fatalf("got %d from /metrics, want 200", rec.Code)
+	}
+	// Requests are labelled by route pattern, never by the raw URL
+	for _, want := range []string{
+		`shop_http_requests_total{method="GET",route="/api/v1/get_product_by_slug/:slug",status="200"}`,
+		`shop_http_requests_total{method="GET",route="unmatched",status="404"}`,
+		`shop_http_request_duration_seconds_bucket{method="POST",route="/api/v1/protected/add_to_cart"`,
+		`shop_funnel_events_total{stage="cart_add"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+	if strings.Contains(rec.Body.String(), f.product.Slug) {
+		t.Error("metrics contain a product slug from a request URL")
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	f := newFixture(t)
+
+	rec := f.do("POST", "/api/v1/admin/create_product", `{"title":"Only a title","price":-1}`,
+		"Authorization", f.authHeader(t, "admin"), echo.HeaderXRequestID, "envelope-test")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+	var body database.ErrorBody
+	decode(t, rec, &body)
+	if body.Error.Code != database.CodeValidationFailed || body.Error.RequestID != "envelope-test" {
+		t.Errorf("got %+v, want validation_failed for request envelope-test", body.Error)
+	}
+	var priceErr *models.FieldError
+	for i, d := range body.Error.Details {
+		if d.Field == "price" {
+			priceErr = &body.Error.Details[i]
+		}
+	}
+	if priceErr == nil || priceErr.Rule != "min" {
+		t.Errorf("got details %+v, want a min rule on price", body.Error.Details)
+	}
+
+	// Errors raised by Echo itself use the same envelope
+	rec = f.do("GET", "/api/v1/no_such_route", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("got status %d, want 404", rec.Code)
+	}
+	decode(t, rec, &body)
+	if body.Error.Code != database.CodeNotFound || body.Error.RequestID == "" {
+		t.Errorf("got %+v, want not_found with a request ID", body.Error)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	runRouteCases(t, []routeCase{
+		{name: "no token", method: "GET", path: "/api/v1/protected/verify", want: 401, check: errorCode("unauthorized")},
+		{name: "expired token", method: "GET", path: "/api/v1/protected/verify", as: "expired", want: 401},
+		{name: "token signed with another secret", method: "GET", path: "/api/v1/protected/verify", as: "forged", want: 401},
+		{
+			name: "valid token", method: "GET", path: "/api/v1/protected/verify", as: "user", want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var body struct {
+					UserID string `json:"userId"`
+					Role   string `json:"role"`
+				}
+				decode(t, rec, &body)
+				if body.UserID != f.userID.Hex() || body.Role != "user" {
+					t.Errorf("got %+v, want user %s", body, f.userID.Hex())
+				}
+			},
+		},
+	})
+}
+
+func TestAdminProductRoutes(t *testing.T) {
+	newProduct := `{"title":"Glass Protector","description":"Tempered glass screen protector for phones","price":9.5,
+		"category":["protectors"],"images":["https://images.example.com/glass.jpg"],"tags":["glass"],"models":["iPhone 15"],
+		"colors":["clear"],"materials":["glass"],"details":["two pack"],"features":["9H"],"stock":5}`
+
+	runRouteCases(t, []routeCase{
+		{name: "create as user", method: "POST", path: "/api/v1/admin/create_product", body: newProduct, as: "user", want: 403},
+		{name: "create with malformed body", method: "POST", path: "/api/v1/admin/create_product", body: `{"title":`, as: "admin", want: 400},
+		{name: "create with missing fields", method: "POST", path: "/api/v1/admin/create_product", body: `{"title":"Only a title"}`, as: "admin", want: 400, check: errorCode("validation_failed")},
+		{
+			name: "create", method: "POST", path: "/api/v1/admin/create_product", body: newProduct, as: "admin", want: 201,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				products, _ := f.store.ListProducts(context.Background(), 1, 0)
+				if len(products) != 3 {
+					t.Errorf("got %d products, want 3", len(products))
+				}
+			},
+		},
+		{name: "update as user", method: "PATCH", path: "/api/v1/admin/update_product/{product}", body: `{"price":15}`, as: "user", want: 403},
+		{name: "update with malformed id", method: "PATCH", path: "/api/v1/admin/update_product/nope", body: `{"price":15}`, as: "admin", want: 400},
+		{
+			name: "update", method: "PATCH", path: "/api/v1/admin/update_product/{product}", body: `{"price":15}`, as: "admin", want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				product, _ := f.store.GetProductByID(context.Background(), f.product.ID)
+				if product.Price != 15 {
+					t.Errorf("got price %v, want 15", product.Price)
+				}
+			},
+		},
+		{name: "delete as user", method: "DELETE", path: "/api/v1/admin/delete_product", body: `{"id":"{product}"}`, as: "user", want: 403},
+		{name: "delete without id", method: "DELETE", path: "/api/v1/admin/delete_product", body: `{}`, as: "admin", want: 400},
+		{name: "delete unknown product", method: "DELETE", path: "/api/v1/admin/delete_product", body: `{"id":"{missing}"}`, as: "admin", want: 404},
+		{
+			name: "delete", method: "DELETE", path: "/api/v1/admin/delete_product", body: `{"id":"{product}"}`, as: "admin", want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if _, err := f.store.GetProductByID(context.Background(), f.product.ID); err == nil {
+					t.Error("product still exists after delete")
+				}
+			},
+		},
+		{name: "cart stats as user", method: "GET", path: "/api/v1/admin/cart_stats", as: "user", want: 403},
+		{
+			name: "cart stats", method: "GET", path: "/api/v1/admin/cart_stats", as: "admin", setup: withCartItem, want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var body struct{ Stats models.CartStats }
+				decode(t, rec, &body)
+				if body.Stats.ActiveCarts != 1 || body.Stats.TotalValue != 40 {
+					t.Errorf("got %+v, want one cart worth 40", body.Stats)
+				}
+			},
+		},
+	})
+}
+
+func TestAdminRoles(t *testing.T) {
+	newProduct := `{"title":"Glass Protector","description":"Tempered glass screen protector for phones","price":9.5,
+		"category":["protectors"],"images":["https://images.example.com/glass.jpg"],"tags":["glass"],"models":["iPhone 15"],
+		"colors":["clear"],"materials":["glass"],"details":["two pack"],"features":["9H"],"stock":5}`
+
+	runRouteCases(t, []routeCase{
+		{name: "anonymous", method: "GET", path: "/api/v1/admin/cart_stats", want: 401},
+		{name: "customer", method: "GET", path: "/api/v1/admin/cart_stats", as: "user", want: 403, check: errorCode("forbidden")},
+		{name: "staff creates product", method: "POST", path: "/api/v1/admin/create_product", body: newProduct, as: "staff", want: 201},
+		{name: "staff deletes product", method: "DELETE", path: "/api/v1/admin/delete_product", body: `{"id":"{product}"}`, as: "staff", want: 403},
+		{name: "staff reads cart stats", method: "GET", path: "/api/v1/admin/cart_stats", as: "staff", want: 200},
+		{name: "support creates product", method: "POST", path: "/api/v1/admin/create_product", body: newProduct, as: "support", want: 403},
+		{name: "support reads comments", method: "GET", path: "/api/v1/admin/get_comments/{product}", as: "support", setup: withComment, want: 200},
+		{name: "support reads cart stats", method: "GET", path: "/api/v1/admin/cart_stats", as: "support", want: 403},
+	})
+}
+
+func TestCommentRoutes(t *testing.T) {
+	runRouteCases(t, []routeCase{
+		{
+			name: "add comment", method: "POST", path: "/api/v1/protected/add_comment/{product}", body: `{"comment":"Fits well"}`, as: "user", want: 201,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				comments, _ := f.store.GetCommentsByProductID(context.Background(), f.product.ID)
+				if len(comments) != 1 || comments[0].UserId != f.userID.Hex() {
+					t.Errorf("got comments %+v, want one by the user", comments)
+				}
+			},
+		},
+		{name: "add comment with malformed product id", method: "POST", path: "/api/v1/protected/add_comment/nope", body: `{"comment":"Fits well"}`, as: "user", want: 400},
+		{name: "add comment to unknown product", method: "POST", path: "/api/v1/protected/add_comment/{missing}", body: `{"comment":"Fits well"}`, as: "user", want: 404, check: errorCode("not_found")},
+		{name: "list comments as user", method: "GET", path: "/api/v1/admin/get_comments/{product}", as: "user", want: 403},
+		{name: "list comments with malformed id", method: "GET", path: "/api/v1/admin/get_comments/nope", as: "admin", want: 400},
+		{name: "list comments", method: "GET", path: "/api/v1/admin/get_comments/{product}", as: "admin", setup: withComment, want: 200},
+		{name: "delete comment without id", method: "PATCH", path: "/api/v1/protected/delete_comment", body: `{}`, as: "user", want: 400},
+		{name: "delete unknown comment", method: "PATCH", path: "/api/v1/protected/delete_comment", body: `{"id":"{missing}"}`, as: "user", want: 404},
+		{name: "delete someone else's comment", method: "PATCH", path: "/api/v1/protected/delete_comment", body: `{"id":"{comment}"}`, as: "other", setup: withComment, want: 403},
+		{name: "delete comment", method: "PATCH", path: "/api/v1/protected/delete_comment", body: `{"id":"{comment}"}`, as: "user", setup: withComment, want: 200},
+		{name: "moderator deletes someone else's comment", method: "PATCH", path: "/api/v1/protected/delete_comment", body: `{"id":"{comment}"}`, as: "support", setup: withComment, want: 200},
+	})
+}
+
+func TestCartRoutes(t *testing.T) {
+	runRouteCases(t, []routeCase{
+		{name: "add without token", method: "POST", path: "/api/v1/protected/add_to_cart", body: `{"product_id":"{product}","quantity":1,"color":"black"}`, want: 401},
+		{
+			name: "add", method: "POST", path: "/api/v1/protected/add_to_cart", body: `{"product_id":"{product}","quantity":3,"color":"black"}`, as: "user", want: 201,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				cart := userCart(t, f)
+				if len(cart.Items) != 1 || cart.TotalAmount != 60 {
+					t.Errorf("got %+v, want one line worth 60", cart)
+				}
+				if cart.Email != "user@example.com" {
+					t.Errorf("got cart email %q, want the user's email", cart.Email)
+				}
+			},
+		},
+		{
+			name: "get without cart", method: "GET", path: "/api/v1/protected/get_cart", as: "user", want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				// Reading an empty cart does not store one
+				rec = f.do("DELETE", "/api/v1/protected/clear_cart", "", "Authorization", f.authHeader(t, "user"))
+				if rec.Code != http.StatusNotFound {
+					t.Errorf("clear after reading: got %d, want 404 as no cart was stored", rec.Code)
+				}
+			},
+		},
+		{name: "add with malformed product id", method: "POST", path: "/api/v1/protected/add_to_cart", body: `{"product_id":"nope","quantity":1,"color":"black"}`, as: "user", want: 400},
+		{name: "add without quantity", method: "POST", path: "/api/v1/protected/add_to_cart", body: `{"product_id":"{product}","quantity":0,"color":"black"}`, as: "user", want: 400, check: errorCode("validation_failed")},
+		{name: "add more than in stock", method: "POST", path: "/api/v1/protected/add_to_cart", body: `{"product_id":"{product}","quantity":11,"color":"black"}`, as: "user", want: 409, check: errorCode("out_of_stock")},
+		{name: "add sold out product", method: "POST", path: "/api/v1/protected/add_to_cart", body: `{"product_id":"{soldOut}","quantity":1,"color":"black"}`, as: "user", want: 409, check: errorCode("out_of_stock")},
+		{
+			name: "add onto a line beyond the stock", method: "POST", path: "/api/v1/protected/add_to_cart", body: `{"product_id":"{product}","quantity":9,"color":"black"}`, as: "user", setup: withCartItem, want: 409,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if cart := userCart(t, f); cart.Items[0].Quantity != 2 {
+					t.Errorf("got quantity %d, want the line left at 2", cart.Items[0].Quantity)
+				}
+			},
+		},
+		{
+			name: "get", method: "GET", path: "/api/v1/protected/get_cart", as: "user", setup: withCartItem, want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var cart models.Cart
+				decode(t, rec, &cart)
+				if cart.UserID != f.userID || len(cart.Items) != 1 {
+					t.Errorf("got %+v, want the user's cart with one line", cart)
+				}
+			},
+		},
+		{
+			name: "increment", method: "PATCH", path: "/api/v1/protected/update_cart", body: `{"product_id":"{product}","quantity":1,"color":"black","action":"increment"}`, as: "user", setup: withCartItem, want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if cart := userCart(t, f); cart.Items[0].Quantity != 3 {
+					t.Errorf("got quantity %d, want 3", cart.Items[0].Quantity)
+				}
+			},
+		},
+		{name: "increment sold out product", method: "PATCH", path: "/api/v1/protected/update_cart", body: `{"product_id":"{soldOut}","quantity":1,"color":"black","action":"increment"}`, as: "user", setup: withCartItem, want: 409, check: errorCode("out_of_stock")},
+		{name: "increment sold out product without cart", method: "PATCH", path: "/api/v1/protected/update_cart", body: `{"product_id":"{soldOut}","quantity":1,"color":"black","action":"increment"}`, as: "user", want: 409, check: errorCode("out_of_stock")},
+		{name: "update line not in cart", method: "PATCH", path: "/api/v1/protected/update_cart", body: `{"product_id":"{product}","quantity":1,"color":"red","action":"decrement"}`, as: "user", setup: withCartItem, want: 404},
+		{name: "remove without id", method: "DELETE", path: "/api/v1/protected/remove_from_cart", body: `{}`, as: "user", setup: withCartItem, want: 400},
+		{name: "remove with malformed id", method: "DELETE", path: "/api/v1/protected/remove_from_cart", body: `{"id":"nope"}`, as: "user", setup: withCartItem, want: 400},
+		{name: "remove unknown line", method: "DELETE", path: "/api/v1/protected/remove_from_cart", body: `{"id":"{missing}"}`, as: "user", setup: withCartItem, want: 404},
+		{
+			name: "remove", method: "DELETE", path: "/api/v1/protected/remove_from_cart", body: `{"id":"{item}"}`, as: "user", setup: withCartItem, want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if cart := userCart(t, f); len(cart.Items) != 0 {
+					t.Errorf("got %d lines, want none", len(cart.Items))
+				}
+			},
+		},
+		{name: "clear without cart", method: "DELETE", path: "/api/v1/protected/clear_cart", as: "user", want: 404},
+		{
+			name: "clear", method: "DELETE", path: "/api/v1/protected/clear_cart", as: "user", setup: withCartItem, want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if cart := userCart(t, f); len(cart.Items) != 0 || cart.TotalAmount != 0 {
+					t.Errorf("got %+v, want an empty cart", cart)
+				}
+			},
+		},
+	})
+}
+
+func TestCheckoutRoutes(t *testing.T) {
+	runRouteCases(t, []routeCase{
+		{name: "checkout without token", method: "POST", path: "/api/v1/protected/checkout", body: `{"amount":40,"email":"user@example.com"}`, want: 401},
+		{name: "checkout without amount", method: "POST", path: "/api/v1/protected/checkout", body: `{"amount":0,"email":"user@example.com"}`, as: "user", want: 400},
+		{name: "checkout without email", method: "POST", path: "/api/v1/protected/checkout", body: `{"amount":40}`, as: "user", want: 400},
+		{
+			name: "checkout", method: "POST", path: "/api/v1/protected/checkout", body: `{"amount":40,"email":"user@example.com"}`, as: "user", want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var body struct {
+					AuthorizationURL string `json:"authorization_url"`
+					Reference        string `json:"reference"`
+				}
+				decode(t, rec, &body)
+				if body.AuthorizationURL == "" || body.Reference != refSuccess {
+					t.Errorf("got %+v, want the fake Paystack transaction", body)
+				}
+			},
+		},
+		{name: "verify without reference", method: "GET", path: "/api/v1/protected/verifyPayment", as: "user", want: 400},
+		{name: "verify unknown reference", method: "GET", path: "/api/v1/protected/verifyPayment?reference=nope", as: "user", want: 402, check: errorCode("payment_failed")},
+		{
+			name: "verify successful payment", method: "GET", path: "/api/v1/protected/verifyPayment?reference=" + refSuccess, as: "user", setup: withCartItem, want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var body struct{ Status string }
+				decode(t, rec, &body)
+				if body.Status != "success" {
+					t.Errorf("got status %q, want success", body.Status)
+				}
+				if cart := userCart(t, f); cart.CheckedOutAt == nil {
+					t.Error("cart was not marked checked out")
+				}
+			},
+		},
+		{
+			name: "verify failed payment", method: "GET", path: "/api/v1/protected/verifyPayment?reference=" + refFailed, as: "user", setup: withCartItem, want: 200,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var body struct{ Status string }
+				decode(t, rec, &body)
+				if body.Status != "failed" {
+					t.Errorf("got status %q, want failed", body.Status)
+				}
+				if cart := userCart(t, f); cart.CheckedOutAt != nil {
+					t.Error("cart was marked checked out for a failed payment")
+				}
+			},
+		},
+		{name: "verify pending payment", method: "GET", path: "/api/v1/protected/verifyPayment?reference=" + refPending, as: "user", want: 200},
+		{
+			name: "verify someone else's payment", method: "GET", path: "/api/v1/protected/verifyPayment?reference=" + refSuccess, as: "other", want: 403,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if cart := userCart(t, f); cart.CheckedOutAt != nil {
+					t.Error("cart was marked checked out by another user")
+				}
+			},
+		},
+	})
+}
+
+func TestPaidOrdersCountedOnce(t *testing.T) {
+	f := newFixture(t)
+	withCartItem(t, f)
+
+	paid := func() string {
+		t.Helper()
+		for _, line := range strings.Split(f.do("GET", "/metrics", "").Body.String(), "\n") {
+			if value, ok := strings.CutPrefix(line, `shop_funnel_events_total{stage="order_paid"} `); ok {
+				return value
+			}
+		}
+		return "0"
+	}
+
+	before := paid()
+	for range 2 {
+		rec := f.do("GET", "/api/v1/protected/verifyPayment?reference="+refSuccess, "", "Authorization", f.authHeader(t, "user"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("verify: got %d, body: %s", rec.Code, rec.Body.String())
+		}
+	}
+	after, err := strconv.Atoi(paid())
+	if want, _ := strconv.Atoi(before); err != nil || after != want+1 {
+		t.Fatalf("got order_paid %d after verifying twice, want %d", after, want+1)
+	}
+}
+
+func TestGuestCartFlow(t *testing.T) {
+	f := newFixture(t)
+	addBody := `{"product_id":"` + f.product.ID.Hex() + `","quantity":2,"color":"black"}`
+
+	// Fetching a CSRF token issues a signed guest cart cookie along with it
+	csrfToken, cookies := f.csrf(t, "")
+	if !strings.Contains(cookies, middleware.GuestCartCookie+"=") {
+		t.Fatal("no guest cart cookie was issued")
+	}
+	rec := f.do("POST", "/api/v1/guest/add_to_cart", addBody, "Cookie", cookies, middleware.CSRFHeader, csrfToken)
+	if rec.Code != 201 {
+		t.Fatalf("add to guest cart: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = f.do("GET", "/api/v1/guest/get_cart", "", "Cookie", cookies)
+	var cart models.Cart
+	decode(t, rec, &cart)
+	if cart.GuestID == "" || len(cart.Items) != 1 {
+		t.Fatalf("got guest cart %+v, want one line", cart)
+	}
+
+	// A tampered cookie is replaced and starts a new, empty cart
+	rec = f.do("GET", "/api/v1/guest/get_cart", "", "Cookie", middleware.GuestCartCookie+"="+cart.GuestID+".forged")
+	var fresh models.Cart
+	decode(t, rec, &fresh)
+	if fresh.GuestID == cart.GuestID || len(fresh.Items) != 0 {
+		t.Fatalf("got %+v for a tampered cookie, want a new empty cart", fresh)
+	}
+	if cookie(rec, middleware.GuestCartCookie) == nil {
+		t.Fatal("no replacement cookie was issued for a tampered cookie")
+	}
+
+	// Signing in merges the guest cart and clears the cookie
+	rec = f.do("GET", "/api/v1/protected/get_cart", "", "Cookie", cookies, "Authorization", f.authHeader(t, "user"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get cart after sign in: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var merged models.Cart
+	decode(t, rec, &merged)
+	if merged.UserID != f.userID || len(merged.Items) != 1 || merged.Items[0].Quantity != 2 {
+		t.Fatalf("got %+v, want the guest line in the user's cart", merged)
+	}
+	if cleared := cookie(rec, middleware.GuestCartCookie); cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatalf("guest cart cookie was not cleared: %+v", cleared)
+	}
+}
+
+// register creates a native account and returns its tokens
+func (f *fixture) register(t *testing.T, email, password string) services.AuthResult {
+	t.Helper()
+	rec := f.do("POST", "/api/v1/auth/register", fmt.Sprintf(`{"email":%q,"password":%q,"name":"Native Shopper"}`, email, password))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var result services.AuthResult
+	decode(t, rec, &result)
+	return result
+}
+
+func TestAccountSessions(t *testing.T) {
+	f := newFixture(t)
+	first := f.register(t, "Native@Example.com", "correct horse")
+	if first.User.Email != "native@example.com" || first.User.Role != middleware.RoleCustomer || first.RefreshToken == "" {
+		t.Fatalf("got %+v, want a customer with a lower-cased email and both tokens", first)
+	}
+
+	// The access token works like any other JWT; the refresh token does not
+	rec := f.do("GET", "/api/v1/protected/verify", "", "Authorization", "Bearer "+first.AccessToken)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), first.User.ID.Hex()) {
+		t.Fatalf("verify with access token: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if rec := f.do("GET", "/api/v1/protected/verify", "", "Authorization", "Bearer "+first.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("verify with refresh token: got %d, want 401", rec.Code)
+	}
+
+	rejected := []struct {
+		name, path, body string
+		want             int
+		code             string
+	}{
+		{"duplicate email", "/api/v1/auth/register", `{"email":"native@example.com","password":"another password"}`, 409, database.CodeConflict},
+		{"short password", "/api/v1/auth/register", `{"email":"short@example.com","password":"short"}`, 400, database.CodeValidationFailed},
+		{"wrong password", "/api/v1/auth/login", `{"email":"native@example.com","password":"wrong horse"}`, 401, database.CodeUnauthorized},
+		{"unknown email", "/api/v1/auth/login", `{"email":"nobody@example.com","password":"correct horse"}`, 401, database.CodeUnauthorized},
+		{"bad refresh token", "/api/v1/auth/refresh", `{"refresh_token":"not-a-token"}`, 401, database.CodeUnauthorized},
+	}
+	for _, tc := range rejected {
+		rec := f.do("POST", tc.path, tc.body)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body.String())
+			continue
+		}
+		errorCode(tc.code)(t, f, rec)
+	}
+
+	// A second device signs in
+	rec = f.do("POST", "/api/v1/auth/login", `{"email":"native@example.com","password":"correct horse"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var second services.AuthResult
+	decode(t, rec, &second)
+
+	rec = f.do("GET", "/api/v1/auth/sessions", "", "Authorization", "Bearer "+second.AccessToken)
+	var listed struct {
+		Sessions []models.UserSession `json:"sessions"`
+		Current  string               `json:"current"`
+	}
+	decode(t, rec, &listed)
+	if len(listed.Sessions) != 2 || listed.Current != second.SessionID {
+		t.Fatalf("got %+v, want two sessions with the second one current", listed)
+	}
+
+	rec = f.do("POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":%q}`, second.RefreshToken))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	// Somebody else cannot revoke the session
+	other := f.register(t, "other-native@example.com", "correct horse")
+	if rec := f.do("DELETE", "/api/v1/auth/sessions/"+second.SessionID, "", "Authorization", "Bearer "+other.AccessToken); rec.Code != http.StatusNotFound {
+		t.Fatalf("revoke another user's session: got %d, want 404", rec.Code)
+	}
+
+	// Logging out ends only that session
+	if rec := f.do("POST", "/api/v1/auth/logout", fmt.Sprintf(`{"refresh_token":%q}`, second.RefreshToken)); rec.Code != http.StatusOK {
+		t.Fatalf("logout: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if rec := f.do("POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":%q}`, second.RefreshToken)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh after logout: got %d, want 401", rec.Code)
+	}
+	if rec := f.do("POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":%q}`, first.RefreshToken)); rec.Code != http.StatusOK {
+		t.Fatalf("refresh of the other session: got %d, want 200", rec.Code)
+	}
+
+	// Logging out everywhere ends the rest
+	rec = f.do("POST", "/api/v1/auth/logout_all", "", "Authorization", "Bearer "+first.AccessToken)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"revoked":1`) {
+		t.Fatalf("logout everywhere: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if rec := f.do("POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":%q}`, first.RefreshToken)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh after logging out everywhere: got %d, want 401", rec.Code)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	f := newFixture(t)
+	account := f.register(t, "rotating@example.com", "correct horse")
+	refresh := func(token string) *httptest.ResponseRecorder {
+		return f.do("POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":%q}`, token))
+	}
+	verify := func(token string) int {
+		return f.do("GET", "/api/v1/protected/verify", "", "Authorization", "Bearer "+token).Code
+	}
+
+	// Each refresh hands out a new refresh token
+	rec := refresh(account.RefreshToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var rotated services.Tokens
+	decode(t, rec, &rotated)
+	if rotated.RefreshToken == "" || rotated.RefreshToken == account.RefreshToken || rotated.SessionID != account.SessionID {
+		t.Fatalf("got %+v, want a new refresh token for the same session", rotated)
+	}
+	if code := verify(rotated.AccessToken); code != http.StatusOK {
+		t.Fatalf("verify with the new access token: got %d, want 200", code)
+	}
+
+	// The old refresh token coming back means it leaked: the session ends and
+	// every token it issued stops working
+	rec = refresh(account.RefreshToken)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("reuse of a rotated refresh token: got %d, want 401", rec.Code)
+	}
+	errorCode(database.CodeUnauthorized)(t, f, rec)
+	if rec := refresh(rotated.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh after reuse: got %d, want 401", rec.Code)
+	}
+	for name, token := range map[string]string{"first": account.AccessToken, "rotated": rotated.AccessToken} {
+		if code := verify(token); code != http.StatusUnauthorized {
+			t.Errorf("verify with the %s access token after reuse: got %d, want 401", name, code)
+		}
+	}
+
+	// Logging out everywhere revokes access tokens straight away too
+	again := f.do("POST", "/api/v1/auth/login", `{"email":"rotating@example.com","password":"correct horse"}`)
+	var session services.AuthResult
+	decode(t, again, &session)
+	if rec := f.do("POST", "/api/v1/auth/logout_all", "", "Authorization", "Bearer "+session.AccessToken); rec.Code != http.StatusOK {
+		t.Fatalf("logout everywhere: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if code := verify(session.AccessToken); code != http.StatusUnauthorized {
+		t.Fatalf("verify after logging out everywhere: got %d, want 401", code)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	f := newFixture(t)
+	account := f.register(t, "forgetful@example.com", "old password")
+
+	// Unknown emails get the same answer and no mail
+	if rec := f.do("POST", "/api/v1/auth/forgot_password", `{"email":"nobody@example.com"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("forgot password for unknown email: got %d, want 202", rec.Code)
+	}
+	if rec := f.do("POST", "/api/v1/auth/forgot_password", `{"email":"Forgetful@example.com"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("forgot password: got %d, want 202", rec.Code)
+	}
+	sent := f.mail.messages()
+	if len(sent) != 1 || sent[0].To != "forgetful@example.com" {
+		t.Fatalf("got messages %+v, want one reset link for the account", sent)
+	}
+	link := sent[0].Body[strings.Index(sent[0].Body, "http://localhost:3000/reset-password?token="):]
+	resetToken := strings.Fields(strings.TrimPrefix(link, "http://localhost:3000/reset-password?token="))[0]
+
+	// A rejected password does not use the token up
+	if rec := f.do("POST", "/api/v1/auth/reset_password", fmt.Sprintf(`{"token":%q,"password":"short"}`, resetToken)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reset with short password: got %d, want 400", rec.Code)
+	}
+	if rec := f.do("POST", "/api/v1/auth/reset_password", fmt.Sprintf(`{"token":%q,"password":"new password"}`, resetToken)); rec.Code != http.StatusOK {
+		t.Fatalf("reset: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if rec := f.do("POST", "/api/v1/auth/reset_password", fmt.Sprintf(`{"token":%q,"password":"newer password"}`, resetToken)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reusing the reset token: got %d, want 400", rec.Code)
+	}
+
+	// Old sessions and the old password are gone
+	if rec := f.do("POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":%q}`, account.RefreshToken)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh after reset: got %d, want 401", rec.Code)
+	}
+	if rec := f.do("POST", "/api/v1/auth/login", `{"email":"forgetful@example.com","password":"old password"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("login with old password: got %d, want 401", rec.Code)
+	}
+	if rec := f.do("POST", "/api/v1/auth/login", `{"email":"forgetful@example.com","password":"new password"}`); rec.Code != http.StatusOK {
+		t.Fatalf("login with new password: got %d, want 200", rec.Code)
+	}
+}
+
+func TestAddressBook(t *testing.T) {
+	f := newFixture(t)
+	user := func(method, path, body string) *httptest.ResponseRecorder {
+		return f.do(method, path, body, "Authorization", f.authHeader(t, "user"))
+	}
+	add := func(body string) models.Address {
+		t.Helper()
+		rec := user("POST", "/api/v1/protected/addresses", body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("add address: got %d, body: %s", rec.Code, rec.Body.String())
+		}
+		var address models.Address
+		decode(t, rec, &address)
+		return address
+	}
+	defaultID := func() string {
+		t.Helper()
+		var book struct {
+			Addresses        []models.Address `json:"addresses"`
+			DefaultAddressID string           `json:"default_address_id"`
+		}
+		decode(t, user("GET", "/api/v1/protected/addresses", ""), &book)
+		defaults := 0
+		for _, address := range book.Addresses {
+			if address.IsDefault {
+				defaults++
+			}
+		}
+		if defaults != 1 {
+			t.Fatalf("got %d default addresses in %+v, want exactly one", defaults, book)
+		}
+		return book.DefaultAddressID
+	}
+
+	// The first address is the default even when not asked to be
+	home := add(`{"type":"home","address_line1":"12 Oxford St","city":"Accra","state":"Greater Accra","postal_code":"ga-123-4567","country":"gh"}`)
+	if !home.IsDefault || home.Country != "GH" || home.PostalCode != "GA-123-4567" {
+		t.Fatalf("got %+v, want a normalized default address", home)
+	}
+	work := add(`{"type":"work","address_line1":"1 Marina","city":"Lagos","state":"Lagos","postal_code":"101001","country":"NG","is_default":true}`)
+	if got := defaultID(); got != work.ID.Hex() {
+		t.Fatalf("default is %s, want the work address %s", got, work.ID.Hex())
+	}
+
+	rejected := []struct {
+		name, method, path, body string
+		as                       string
+		want                     int
+	}{
+		{"bad US postal code", "POST", "/api/v1/protected/addresses", `{"type":"home","address_line1":"1 Main St","city":"Austin","state":"TX","postal_code":"7870","country":"US"}`, "user", 400},
+		{"missing postal code", "POST", "/api/v1/protected/addresses", `{"type":"home","address_line1":"1 Main St","city":"Austin","state":"TX","country":"US"}`, "user", 400},
+		{"country name", "POST", "/api/v1/protected/addresses", `{"type":"home","address_line1":"1 Main St","city":"Accra","state":"Greater Accra","country":"Ghana"}`, "user", 400},
+		{"malformed id", "PUT", "/api/v1/protected/addresses/nope", `{}`, "user", 400},
+		{"another user's address", "DELETE", "/api/v1/protected/addresses/" + home.ID.Hex(), "", "other", 404},
+		{"bad phone", "PATCH", "/api/v1/protected/profile", `{"name":"Ama","phone":"0241234567"}`, "user", 400},
+		{"checkout to an invalid address", "POST", "/api/v1/protected/checkout", `{"amount":40,"email":"user@example.com","shipping_address":{"type":"home","address_line1":"1 Main St","city":"Nairobi","state":"Nairobi","postal_code":"1","country":"KE"}}`, "user", 400},
+		{"checkout to two addresses", "POST", "/api/v1/protected/checkout", `{"amount":40,"email":"user@example.com","address_id":"` + home.ID.Hex() + `","shipping_address":{"type":"home","address_line1":"1 Main St","city":"Nairobi","state":"Nairobi","postal_code":"00100","country":"KE"}}`, "user", 400},
+	}
+	for _, tc := range rejected {
+		if rec := f.do(tc.method, tc.path, tc.body, "Authorization", f.authHeader(t, tc.as)); rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+
+	// Editing the default without is_default keeps it the default
+	rec := user("PUT", "/api/v1/protected/addresses/"+work.ID.Hex(), `{"type":"work","address_line1":"2 Marina","city":"Lagos","state":"Lagos","country":"NG"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update address: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if got := defaultID(); got != work.ID.Hex() {
+		t.Fatalf("default is %s after editing it, want %s", got, work.ID.Hex())
+	}
+	if rec := user("POST", "/api/v1/protected/addresses/"+home.ID.Hex()+"/default", ""); rec.Code != http.StatusOK {
+		t.Fatalf("set default: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if got := defaultID(); got != home.ID.Hex() {
+		t.Fatalf("default is %s, want the home address %s", got, home.ID.Hex())
+	}
+
+	// Checkout can name a saved address, or falls back to the default
+	shippedTo := func(body string) string {
+		t.Helper()
+		rec := user("POST", "/api/v1/protected/checkout", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("checkout %s: got %d, body: %s", body, rec.Code, rec.Body.String())
+		}
+		sent, _ := f.payments.last()
+		metadata, _ := sent.Metadata.(map[string]any)
+		address, _ := metadata["shipping_address"].(map[string]any)
+		city, _ := address["city"].(string)
+		return city
+	}
+	if city := shippedTo(fmt.Sprintf(`{"amount":40,"email":"user@example.com","address_id":%q}`, work.ID.Hex())); city != "Lagos" {
+		t.Errorf("checkout with address_id shipped to %q, want Lagos", city)
+	}
+	if city := shippedTo(`{"amount":40,"email":"user@example.com"}`); city != "Accra" {
+		t.Errorf("checkout without an address shipped to %q, want the default in Accra", city)
+	}
+	if rec := user("POST", "/api/v1/protected/checkout", fmt.Sprintf(`{"amount":40,"email":"user@example.com","address_id":%q}`, primitive.NewObjectID().Hex())); rec.Code != http.StatusNotFound {
+		t.Errorf("checkout with an unknown address: got %d, want 404", rec.Code)
+	}
+
+	// Deleting the default hands it to the remaining address
+	if rec := user("DELETE", "/api/v1/protected/addresses/"+home.ID.Hex(), ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete address: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if got := defaultID(); got != work.ID.Hex() {
+		t.Fatalf("default is %s after deleting the old one, want %s", got, work.ID.Hex())
+	}
+
+	rec = user("PATCH", "/api/v1/protected/profile", `{"name":" Ama Mensah ","phone":"+233241234567"}`)
+	var profile models.UserProfile
+	decode(t, rec, &profile)
+	if profile.Name != "Ama Mensah" || profile.Phone != "+233241234567" || len(profile.Addresses) != 1 {
+		t.Fatalf("got %+v, want the updated profile with one address", profile)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	f := newFixture(t, func(cfg *config.Config) {
+		cfg.RateLimit = config.RateLimitConfig{Enabled: true, Policies: map[string]config.RateLimitPolicy{
+			config.RateLimitAPI:      {Limit: 5, Window: time.Minute},
+			config.RateLimitComments: {Limit: 1, Window: time.Minute, Burst: 2},
+			config.RateLimitAuth:     {Limit: 1, Window: time.Hour},
+		}}
+		// httptest requests come from 192.0.2.1, standing in for the load balancer
+		cfg.TrustedProxies = []string{"192.0.2.0/24"}
+	})
+	comment := func(as string) *httptest.ResponseRecorder {
+		return f.do("POST", "/api/v1/protected/add_comment/"+f.product.ID.Hex(), `{"comment":"Nice case"}`, "Authorization", f.authHeader(t, as))
+	}
+
+	// Comments are limited per user: the third in a row is refused
+	for i, want := range []string{"1", "0"} {
+		rec := comment("user")
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("comment %d: got %d, body: %s", i+1, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("RateLimit-Remaining"); got != want {
+			t.Errorf("comment %d: got RateLimit-Remaining %q, want %q", i+1, got, want)
+		}
+	}
+	rec := comment("user")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("third comment: got %d, want 429", rec.Code)
+	}
+	errorCode(database.CodeTooManyRequests)(t, f, rec)
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("got Retry-After %q, want 60", got)
+	}
+	if got := rec.Header().Get("RateLimit-Limit"); got != "2" {
+		t.Errorf("got RateLimit-Limit %q, want 2", got)
+	}
+	if rec := comment("other"); rec.Code == http.StatusTooManyRequests {
+		t.Errorf("another user's comment was limited too")
+	}
+
+	// Sign-in is limited by client IP
+	login := func(ip string) int {
+		return f.do("POST", "/api/v1/auth/login", `{"email":"nobody@example.com","password":"wrong horse"}`, "X-Forwarded-For", ip).Code
+	}
+	if code := login("203.0.113.1"); code != http.StatusUnauthorized {
+		t.Fatalf("first login: got %d, want 401", code)
+	}
+	if code := login("203.0.113.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("second login from the same IP: got %d, want 429", code)
+	}
+	if code := login("203.0.113.2"); code != http.StatusUnauthorized {
+		t.Fatalf("login from another IP: got %d, want 401", code)
+	}
+
+	// The API-wide policy covers everything but the health checks
+	for i := 0; i < 5; i++ {
+		f.do("GET", "/api/v1/products", "", "X-Forwarded-For", "203.0.113.3")
+	}
+	if rec := f.do("GET", "/api/v1/products", "", "X-Forwarded-For", "203.0.113.3"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("sixth product listing: got %d, want 429", rec.Code)
+	}
+	if rec := f.do("GET", "/api/v1/health/ready", "", "X-Forwarded-For", "203.0.113.3"); rec.Code != http.StatusOK {
+		t.Fatalf("readiness after the limit: got %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimitsIgnoreSpoofedIPs(t *testing.T) {
+	policies := func(cfg *config.Config) {
+		cfg.RateLimit = config.RateLimitConfig{Enabled: true, Policies: map[string]config.RateLimitPolicy{
+			config.RateLimitAuth: {Limit: 1, Window: time.Hour},
+		}}
+	}
+	login := func(f *fixture, forwardedFor string) int {
+		return f.do("POST", "/api/v1/auth/login", `{"email":"nobody@example.com","password":"wrong horse"}`, "X-Forwarded-For", forwardedFor, "X-Real-Ip", forwardedFor).Code
+	}
+
+	// Without trusted proxies the headers are ignored: every request comes
+	// from the connection's address
+	f := newFixture(t, policies)
+	if code := login(f, "203.0.113.1"); code != http.StatusUnauthorized {
+		t.Fatalf("first login: got %d, want 401", code)
+	}
+	for _, ip := range []string{"203.0.113.2", "198.51.100.7", "2001:db8::1"} {
+		if code := login(f, ip); code != http.StatusTooManyRequests {
+			t.Fatalf("login claiming to be from %s: got %d, want 429", ip, code)
+		}
+	}
+
+	// Behind a trusted proxy, addresses the client put in front of the one
+	// the proxy appended are ignored
+	f = newFixture(t, policies, func(cfg *config.Config) { cfg.TrustedProxies = []string{"192.0.2.1"} })
+	if code := login(f, "198.51.100.1, 203.0.113.1"); code != http.StatusUnauthorized {
+		t.Fatalf("first login: got %d, want 401", code)
+	}
+	if code := login(f, "198.51.100.2, 203.0.113.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("login with a rotated X-Forwarded-For: got %d, want 429", code)
+	}
+	if code := login(f, "203.0.113.2"); code != http.StatusUnauthorized {
+		t.Fatalf("login from another client: got %d, want 401", code)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	f := newFixture(t)
+	session := "auth-token=" + token(t, f.userID, "user@example.com", "customer", time.Hour)
+	addToCart := fmt.Sprintf(`{"product_id":%q,"quantity":1,"color":"black"}`, f.product.ID.Hex())
+
+	rec := f.do("GET", "/api/v1/csrf", "", "Cookie", session)
+	var issued struct {
+		Token  string `json:"csrf_token"`
+		Header string `json:"header"`
+	}
+	decode(t, rec, &issued)
+	if issued.Token == "" || issued.Header != middleware.CSRFHeader || !strings.Contains(rec.Header().Get("Set-Cookie"), middleware.CSRFCookie+"="+issued.Token) {
+		t.Fatalf("got %+v and Set-Cookie %q, want the token in the body and a cookie", issued, rec.Header().Get("Set-Cookie"))
+	}
+	if cookie(rec, middleware.GuestCartCookie) != nil {
+		t.Error("a signed-in user was issued a guest cart")
+	}
+	csrfCookie := middleware.CSRFCookie + "=" + issued.Token
+
+	// A valid cookie is handed out again rather than replaced
+	decode(t, f.do("GET", "/api/v1/csrf", "", "Cookie", session+"; "+csrfCookie), &issued)
+	if csrfCookie != middleware.CSRFCookie+"="+issued.Token {
+		t.Fatalf("got a new token %q, want the one in the cookie kept", issued.Token)
+	}
+
+	// Tokens fetched by someone else, signed in or not, cannot be planted
+	otherToken, _ := f.csrf(t, "auth-token="+token(t, f.otherID, "other@example.com", "customer", time.Hour))
+	guestToken, guestCookies := f.csrf(t, "")
+	guestCart := strings.Split(guestCookies, "; ")[0]
+	if !strings.HasPrefix(guestCart, middleware.GuestCartCookie+"=") {
+		t.Fatalf("got cookies %q, want the guest cart first", guestCookies)
+	}
+
+	forged := "bm9uY2U.c2lnbmF0dXJl"
+	cases := []struct {
+		name, method, path, body string
+		headers                  []string
+		want                     int
+	}{
+		{"cookie without token", "POST", "/api/v1/protected/add_to_cart", addToCart, []string{"Cookie", session}, 403},
+		{"cookie with header only", "POST", "/api/v1/protected/add_to_cart", addToCart, []string{"Cookie", session, middleware.CSRFHeader, issued.Token}, 403},
+		{"cookie with mismatched token", "POST", "/api/v1/protected/add_to_cart", addToCart, []string{"Cookie", session + "; " + csrfCookie, middleware.CSRFHeader, issued.Token + "x"}, 403},
+		{"cookie with forged token", "POST", "/api/v1/protected/add_to_cart", addToCart, []string{"Cookie", session + "; " + middleware.CSRFCookie + "=" + forged, middleware.CSRFHeader, forged}, 403},
+		{"cookie with another user's token", "POST", "/api/v1/protected/add_to_cart", addToCart, []string{"Cookie", session + "; " + middleware.CSRFCookie + "=" + otherToken, middleware.CSRFHeader, otherToken}, 403},
+		{"cookie with a guest token", "POST", "/api/v1/protected/add_to_cart", addToCart, []string{"Cookie", session + "; " + middleware.CSRFCookie + "=" + guestToken, middleware.CSRFHeader, guestToken}, 403},
+		{"cookie with token", "POST", "/api/v1/protected/add_to_cart", addToCart, []string{"Cookie", session + "; " + csrfCookie, middleware.CSRFHeader, issued.Token}, 201},
+		{"cookie reading", "GET", "/api/v1/protected/get_cart", "", []string{"Cookie", session}, 200},
+		{"bearer without token", "POST", "/api/v1/protected/add_to_cart", addToCart, []string{"Authorization", f.authHeader(t, "user")}, 201},
+		{"guest without token", "POST", "/api/v1/guest/add_to_cart", addToCart, []string{"Cookie", guestCookies}, 403},
+		{"guest without a cart cookie", "POST", "/api/v1/guest/add_to_cart", addToCart, []string{"Cookie", middleware.CSRFCookie + "=" + guestToken, middleware.CSRFHeader, guestToken}, 403},
+		{"guest with the user's token", "POST", "/api/v1/guest/add_to_cart", addToCart, []string{"Cookie", guestCart + "; " + csrfCookie, middleware.CSRFHeader, issued.Token}, 403},
+		{"guest with token", "POST", "/api/v1/guest/add_to_cart", addToCart, []string{"Cookie", guestCookies, middleware.CSRFHeader, guestToken}, 201},
+		{"guest reading", "GET", "/api/v1/guest/get_cart", "", []string{"Cookie", guestCookies}, 200},
+	}
+	for _, tc := range cases {
+		rec := f.do(tc.method, tc.path, tc.body, tc.headers...)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body.String())
+			continue
+		}
+		if tc.want == http.StatusForbidden {
+			errorCode(database.CodeForbidden)(t, f, rec)
+		}
+	}
+}
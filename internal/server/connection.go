@@ -3,56 +3,71 @@ package server
 import (
 	"context"
 	"fmt"
-	"os"
-	"path/filepath"
 	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/joshuatakyi/shop/internal/logging"
+	"github.com/joshuatakyi/shop/internal/metrics"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
-var (
-	Client *mongo.Client
-)
-func InitializeConnection() error {
-	// Try to load from current directory first
-	if err := godotenv.Load(".env.local"); err != nil {
-		// If that fails, try loading from the project root
-		workDir, err := os.Getwd()
-		if err == nil {
-			// Try to find .env.local relative to the working directory
-			envPath := filepath.Join(workDir, ".env.local")
-			godotenv.Load(envPath)
-		}
-		fmt.Printf("Warning: Could not load .env.local file. Using environment variables directly.\n")
-	}
-	uri := os.Getenv("MONGODB_URI")
+// Connect opens a MongoDB client for uri. The caller owns the client and must Disconnect it.
+func Connect(uri string) (*mongo.Client, error) {
 	if uri == "" {
-		return fmt.Errorf("MONGODB_URI environment variable is not set")
+		return nil, fmt.Errorf("MONGODB_URI environment variable is not set")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var err error
-	Client, err = mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(commandMonitor()))
 	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %v", err)
+		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
 	}
 
-	fmt.Println("Connected to MongoDB")
+	return client, nil
+}
 
-	return nil
+// commandMonitor traces every MongoDB command as a child span of the
+// operation's context, times it for the metrics and logs it through the
+// logger carried by that context, so repository calls made while serving a
+// request are logged with that request's ID. Successful commands are logged at
+// debug level and failures at warn.
+func commandMonitor() *event.CommandMonitor {
+	tracing := otelmongo.NewMonitor()
+	return &event.CommandMonitor{
+		Started: tracing.Started,
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			tracing.Succeeded(ctx, evt)
+			metrics.ObserveMongoCommand(evt.CommandName, evt.Duration, false)
+			logging.FromContext(ctx).Debug("MongoDB command",
+				"command", evt.CommandName,
+				"database", evt.DatabaseName,
+				"latency_ms", float64(evt.Duration.Microseconds())/1000,
+			)
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			tracing.Failed(ctx, evt)
+			metrics.ObserveMongoCommand(evt.CommandName, evt.Duration, true)
+			logging.FromContext(ctx).Warn("MongoDB command failed",
+				"command", evt.CommandName,
+				"database", evt.DatabaseName,
+				"latency_ms", float64(evt.Duration.Microseconds())/1000,
+				"error", evt.Failure,
+			)
+		},
+	}
 }
 
-func Disconnect() {
+// Disconnect closes the client, giving in-flight operations up to 10 seconds to finish
+func Disconnect(client *mongo.Client) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if err := Client.Disconnect(ctx); err != nil {
-		fmt.Printf("failed to disconnect from MongoDB: %v\n", err)
-	} else {
-		fmt.Println("Disconnected from MongoDB")
+	if err := client.Disconnect(ctx); err != nil {
+		return fmt.Errorf("failed to disconnect from MongoDB: %v", err)
 	}
-}
\ No newline at end of file
+	return nil
+}
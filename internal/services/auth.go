@@ -0,0 +1,516 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joshuatakyi/shop/internal/config"
+	"github.com/joshuatakyi/shop/internal/logging"
+	"github.com/joshuatakyi/shop/internal/middleware"
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/joshuatakyi/shop/internal/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultPasswordCost is the bcrypt cost of new password hashes
+const DefaultPasswordCost = 12
+
+// Password length limits. bcrypt only reads the first 72 bytes, so longer
+// passwords are refused rather than silently truncated.
+const (
+	minPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+// AuthService manages the accounts the API owns when native accounts are
+// enabled. Access tokens are short-lived HS256 JWTs that AuthMiddleware
+// verifies like any other and checks against the denylist. Refresh tokens are
+// JWTs too, tied to a session document: each refresh replaces the refresh
+// token, and presenting a replaced one is treated as theft and ends the session.
+type AuthService struct {
+	Repo     models.ShopCalls
+	Notifier notifier.Notifier // Delivers password reset links
+	Denylist *TokenDenylist    // Access tokens of revoked sessions
+
+	Secret   string // Signs every token; the same JWT secret AuthMiddleware verifies with
+	Issuer   string // Set as iss when AuthMiddleware requires one
+	Audience string // Set as aud when AuthMiddleware requires one
+
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	ResetTTL   time.Duration
+	ResetURL   string // Page of the Next.js app that takes the reset token
+
+	PasswordCost int              // bcrypt cost, lowered in tests
+	Now          func() time.Time // Replaced in tests
+
+	dummyOnce sync.Once
+	dummyHash []byte
+}
+
+// NewAuthService returns the account service configured by cfg. Reset links
+// point at frontendURL.
+func NewAuthService(cfg config.AuthConfig, frontendURL string, repo models.ShopCalls, n notifier.Notifier) (*AuthService, error) {
+	if cfg.JWTSecret == "" {
+		return nil, fmt.Errorf("native accounts need a JWT secret")
+	}
+	return &AuthService{
+		Repo:         repo,
+		Notifier:     n,
+		Denylist:     NewTokenDenylist(repo, cfg.DenylistSync),
+		Secret:       cfg.JWTSecret,
+		Issuer:       cfg.Issuer,
+		Audience:     cfg.Audience,
+		AccessTTL:    cfg.AccessTokenTTL,
+		RefreshTTL:   cfg.RefreshTokenTTL,
+		ResetTTL:     cfg.PasswordResetTTL,
+		ResetURL:     frontendURL + "/reset-password",
+		PasswordCost: DefaultPasswordCost,
+		Now:          time.Now,
+	}, nil
+}
+
+// ClientInfo describes the device a session is opened from, shown when the
+// user lists their sessions
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// Tokens are issued on sign-in and refresh
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds until the access token expires
+	SessionID    string `json:"session_id"`
+}
+
+// AuthResult is the account that signed in and its new tokens
+type AuthResult struct {
+	User *models.User `json:"user"`
+	Tokens
+}
+
+// errBadCredentials is the same for an unknown email and a wrong password so
+// the response does not reveal which emails have accounts
+var errBadCredentials = models.Unauthenticated("Invalid email or password")
+
+// errBadRefreshToken covers every reason a refresh token is refused
+var errBadRefreshToken = models.Unauthenticated("Invalid or expired refresh token")
+
+// Register creates a customer account and signs it in
+func (s *AuthService) Register(ctx context.Context, email, password, name string, client ClientInfo) (*AuthResult, error) {
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.Repo.CreateUser(ctx, models.User{
+		Email:        NormalizeEmail(email),
+		Name:         strings.TrimSpace(name),
+		Role:         middleware.RoleCustomer,
+		PasswordHash: string(hash),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create account: %w", err)
+	}
+	return s.startSession(ctx, user, client)
+}
+
+// Login checks the password and opens a new session
+func (s *AuthService) Login(ctx context.Context, email, password string, client ClientInfo) (*AuthResult, error) {
+	user, err := s.Repo.GetUserByEmail(ctx, NormalizeEmail(email))
+	if errors.Is(err, models.ErrNotFound) {
+		// Spend as long as a real check would
+		bcrypt.CompareHashAndPassword(s.dummyPasswordHash(), []byte(password))
+		return nil, errBadCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up account: %w", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, errBadCredentials
+	}
+	return s.startSession(ctx, user, client)
+}
+
+// Refresh rotates the session of a refresh token: it returns a new access
+// token and a new refresh token, and the one presented stops working. A
+// refresh token that was already rotated out means it was copied, so the
+// whole session is revoked and whoever holds it must sign in again.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	session, err := s.sessionFor(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	now := s.Now()
+	if !session.Active(now) {
+		return nil, errBadRefreshToken
+	}
+	presented := hashToken(refreshToken)
+	if subtle.ConstantTimeCompare([]byte(session.RefreshTokenHash), []byte(presented)) != 1 {
+		return nil, s.refreshReused(ctx, session)
+	}
+
+	// Read the account again so role changes reach the next access token
+	user, err := s.Repo.GetUserByID(ctx, session.UserID)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, errBadRefreshToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up account: %w", err)
+	}
+
+	// The session keeps its original expiry: rotating does not extend it
+	refresh, err := s.refreshToken(user, session.ID, session.ExpiresAt, now)
+	if err != nil {
+		return nil, err
+	}
+	access, issued, err := s.accessToken(user, session.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	accessTokens := append(unexpired(session.AccessTokens, now), issued)
+
+	err = s.Repo.RotateSession(ctx, session.ID, presented, hashToken(refresh), accessTokens, now)
+	if errors.Is(err, models.ErrConflict) {
+		// Another request rotated the same token first
+		return nil, s.refreshReused(ctx, session)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.AccessTTL / time.Second),
+		SessionID:    session.ID.Hex(),
+	}, nil
+}
+
+// refreshReused revokes a session whose old refresh token came back
+func (s *AuthService) refreshReused(ctx context.Context, session *models.UserSession) error {
+	logging.FromContext(ctx).Warn("Refresh token reused, revoking session", "userId", session.UserID.Hex(), "sessionId", session.ID.Hex())
+	if err := s.revokeSession(ctx, session.ID, RevokedTokenReuse); err != nil {
+		return err
+	}
+	return errBadRefreshToken
+}
+
+// Logout revokes the session of a refresh token, along with its access
+// tokens. Logging out of a session that is already revoked or expired succeeds.
+func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
+	session, err := s.sessionFor(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+	return s.revokeSession(ctx, session.ID, RevokedLogout)
+}
+
+// LogoutEverywhere revokes every open session of the user and their access
+// tokens, and returns how many sessions there were
+func (s *AuthService) LogoutEverywhere(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return s.revokeUserSessions(ctx, userID, RevokedLogoutAll)
+}
+
+// Sessions lists the user's open sessions
+func (s *AuthService) Sessions(ctx context.Context, userID primitive.ObjectID) ([]models.UserSession, error) {
+	return s.Repo.ListUserSessions(ctx, userID, s.Now())
+}
+
+// RevokeSession revokes one of the user's sessions, e.g. a lost phone. Other
+// users' sessions are reported as not found.
+func (s *AuthService) RevokeSession(ctx context.Context, userID, sessionID primitive.ObjectID) error {
+	session, err := s.Repo.GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return models.NotFound("Session not found")
+	}
+	return s.revokeSession(ctx, session.ID, RevokedLogout)
+}
+
+// revokeSession revokes the session, then denylists the access tokens it
+// held once revoked, so a refresh racing the revocation cannot keep one
+func (s *AuthService) revokeSession(ctx context.Context, id primitive.ObjectID, reason string) error {
+	session, err := s.Repo.RevokeSession(ctx, id, s.Now())
+	if err != nil {
+		return err
+	}
+	if err := s.Denylist.Revoke(ctx, session.AccessTokens, reason); err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+	return nil
+}
+
+// revokeUserSessions revokes every open session of the user, then denylists
+// the access tokens they held once revoked
+func (s *AuthService) revokeUserSessions(ctx context.Context, userID primitive.ObjectID, reason string) (int64, error) {
+	sessions, err := s.Repo.RevokeUserSessions(ctx, userID, s.Now())
+	if err != nil {
+		return 0, err
+	}
+	var tokens []models.IssuedToken
+	for _, session := range sessions {
+		tokens = append(tokens, session.AccessTokens...)
+	}
+	if err := s.Denylist.Revoke(ctx, tokens, reason); err != nil {
+		return 0, fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+	return int64(len(sessions)), nil
+}
+
+// RequestPasswordReset sends a reset link to the account with the email, if
+// there is one. It succeeds either way so it cannot be used to find accounts;
+// delivery failures are logged instead of returned for the same reason.
+func (s *AuthService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.Repo.GetUserByEmail(ctx, NormalizeEmail(email))
+	if errors.Is(err, models.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up account: %w", err)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	now := s.Now()
+	reset := models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(s.ResetTTL),
+		CreatedAt: now,
+	}
+	if err := s.Repo.CreatePasswordReset(ctx, reset); err != nil {
+		return err
+	}
+
+	msg := notifier.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. If it was you, choose a new password here:\n\n%s?token=%s\n\nThe link expires in %s. If you did not ask for it, you can ignore this email.",
+			s.ResetURL, token, s.ResetTTL),
+	}
+	if err := s.Notifier.Send(ctx, msg); err != nil {
+		logging.FromContext(ctx).Error("Failed to send password reset", "userId", user.ID.Hex(), "error", err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password with a reset token and, since whoever
+// knew the old password may still be signed in, revokes every session
+func (s *AuthService) ResetPassword(ctx context.Context, token, password string) error {
+	// Check the password before the token is used up
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	reset, err := s.Repo.ConsumePasswordReset(ctx, hashToken(token), s.Now())
+	if errors.Is(err, models.ErrNotFound) {
+		return models.InvalidField("token", "valid", "Password reset token is invalid or expired")
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Repo.UpdateUserPassword(ctx, reset.UserID, string(hash)); err != nil {
+		return err
+	}
+	if _, err := s.revokeUserSessions(ctx, reset.UserID, RevokedPasswordReset); err != nil {
+		return err
+	}
+	return nil
+}
+
+// startSession opens a session for user and issues its tokens
+func (s *AuthService) startSession(ctx context.Context, user *models.User, client ClientInfo) (*AuthResult, error) {
+	now := s.Now()
+	sessionID := primitive.NewObjectID()
+
+	expiresAt := now.Add(s.RefreshTTL)
+	refresh, err := s.refreshToken(user, sessionID, expiresAt, now)
+	if err != nil {
+		return nil, err
+	}
+	access, issued, err := s.accessToken(user, sessionID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.Repo.CreateSession(ctx, models.UserSession{
+		ID:               sessionID,
+		UserID:           user.ID,
+		RefreshTokenHash: hashToken(refresh),
+		AccessTokens:     []models.IssuedToken{issued},
+		UserAgent:        client.UserAgent,
+		IP:               client.IP,
+		CreatedAt:        now,
+		LastUsedAt:       now,
+		ExpiresAt:        expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthResult{
+		User: user,
+		Tokens: Tokens{
+			AccessToken:  access,
+			RefreshToken: refresh,
+			TokenType:    "Bearer",
+			ExpiresIn:    int(s.AccessTTL / time.Second),
+			SessionID:    sessionID.Hex(),
+		},
+	}, nil
+}
+
+// sessionFor verifies a refresh token and returns its session, whether or not
+// it is still active or the token is its current one
+func (s *AuthService) sessionFor(ctx context.Context, refreshToken string) (*models.UserSession, error) {
+	claims, err := middleware.ValidateJWT(ctx, refreshToken, middleware.AuthConfig{
+		JWTSecret: s.Secret,
+		Issuer:    s.Issuer,
+		Audience:  s.Audience,
+	})
+	if err != nil {
+		return nil, errBadRefreshToken
+	}
+	if typ, _ := claims["typ"].(string); typ != middleware.TokenTypeRefresh {
+		return nil, errBadRefreshToken
+	}
+	sid, _ := claims["sid"].(string)
+	sessionID, err := primitive.ObjectIDFromHex(sid)
+	if err != nil {
+		return nil, errBadRefreshToken
+	}
+
+	session, err := s.Repo.GetSession(ctx, sessionID)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, errBadRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// accessToken issues an access token carrying the claims AuthMiddleware
+// reads, and returns its jti and expiry for the session to remember
+func (s *AuthService) accessToken(user *models.User, sessionID primitive.ObjectID, now time.Time) (string, models.IssuedToken, error) {
+	jti, err := randomToken()
+	if err != nil {
+		return "", models.IssuedToken{}, err
+	}
+	issued := models.IssuedToken{JTI: jti, ExpiresAt: now.Add(s.AccessTTL)}
+	token, err := s.sign(jwt.MapClaims{
+		"sub":   user.ID.Hex(),
+		"email": user.Email,
+		"name":  user.Name,
+		"role":  user.Role,
+		"sid":   sessionID.Hex(),
+		"typ":   middleware.TokenTypeAccess,
+		"jti":   jti,
+		"exp":   issued.ExpiresAt.Unix(),
+	}, now)
+	return token, issued, err
+}
+
+// refreshToken issues a refresh token for the session, valid until expiresAt
+func (s *AuthService) refreshToken(user *models.User, sessionID primitive.ObjectID, expiresAt, now time.Time) (string, error) {
+	jti, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	return s.sign(jwt.MapClaims{
+		"sub": user.ID.Hex(),
+		"sid": sessionID.Hex(),
+		"typ": middleware.TokenTypeRefresh,
+		"jti": jti,
+		"exp": expiresAt.Unix(),
+	}, now)
+}
+
+// sign adds the claims every token carries and signs it with HS256
+func (s *AuthService) sign(claims jwt.MapClaims, now time.Time) (string, error) {
+	claims["iat"] = now.Unix()
+	if s.Issuer != "" {
+		claims["iss"] = s.Issuer
+	}
+	if s.Audience != "" {
+		claims["aud"] = s.Audience
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.Secret))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %v", err)
+	}
+	return signed, nil
+}
+
+func (s *AuthService) hashPassword(password string) ([]byte, error) {
+	if len([]rune(password)) < minPasswordLength {
+		return nil, models.InvalidField("password", "min", fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+	}
+	if len(password) > maxPasswordBytes {
+		return nil, models.InvalidField("password", "max", fmt.Sprintf("Password must be at most %d bytes", maxPasswordBytes))
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.PasswordCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+	return hash, nil
+}
+
+// dummyPasswordHash is compared against when nobody has the email, made with
+// the same cost as real hashes so both take as long
+func (s *AuthService) dummyPasswordHash() []byte {
+	s.dummyOnce.Do(func() {
+		s.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not the password of any account"), s.PasswordCost)
+	})
+	return s.dummyHash
+}
+
+// unexpired returns the tokens that have not expired by now
+func unexpired(tokens []models.IssuedToken, now time.Time) []models.IssuedToken {
+	var live []models.IssuedToken
+	for _, token := range tokens {
+		if now.Before(token.ExpiresAt) {
+			live = append(live, token)
+		}
+	}
+	return live
+}
+
+// NormalizeEmail returns the form emails are stored and looked up in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// randomToken returns 32 random bytes, URL-safe encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how refresh and reset tokens are stored: a database dump alone
+// is not enough to use them
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
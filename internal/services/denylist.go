@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/metrics"
+	"github.com/joshuatakyi/shop/internal/models"
+)
+
+// denylistJob names the denylist sync in the job metrics
+const denylistJob = "token_denylist"
+
+// denylistOverlap is how far back each sync reads before the previous one, so
+// entries written by other instances with a lagging clock are not missed
+const denylistOverlap = time.Minute
+
+// Reasons recorded with denylist entries
+const (
+	RevokedLogout        = "logout"
+	RevokedLogoutAll     = "logout_all"
+	RevokedPasswordReset = "password_reset"
+	RevokedTokenReuse    = "refresh_token_reuse"
+)
+
+// TokenDenylist is the set of access tokens revoked before they expire, by
+// jti. Revocations are stored in MongoDB so every instance sees them, and
+// each instance keeps a copy in memory that Run syncs every Interval, so
+// checking a token never waits on the database. A revocation made by another
+// instance can take up to Interval to apply here.
+type TokenDenylist struct {
+	Repo     models.ShopCalls
+	Interval time.Duration
+	Logger   *slog.Logger
+	Now      func() time.Time // Replaced in tests
+
+	mu       sync.RWMutex
+	entries  map[string]time.Time // jti → when the token expires
+	syncedAt time.Time            // when the last successful sync started
+}
+
+// NewTokenDenylist returns an empty denylist synced from repo every interval
+func NewTokenDenylist(repo models.ShopCalls, interval time.Duration) *TokenDenylist {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &TokenDenylist{
+		Repo:     repo,
+		Interval: interval,
+		Logger:   slog.Default(),
+		Now:      time.Now,
+		entries:  map[string]time.Time{},
+	}
+}
+
+// IsRevoked reports whether the token with the jti has been revoked
+func (d *TokenDenylist) IsRevoked(jti string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	expiresAt, ok := d.entries[jti]
+	return ok && d.Now().Before(expiresAt)
+}
+
+// Revoke denylists the tokens that have not expired yet. They are refused by
+// this instance straight away, even if storing them fails.
+func (d *TokenDenylist) Revoke(ctx context.Context, tokens []models.IssuedToken, reason string) error {
+	now := d.Now()
+	var revoked []models.RevokedToken
+	for _, token := range tokens {
+		if now.Before(token.ExpiresAt) {
+			revoked = append(revoked, models.RevokedToken{JTI: token.JTI, ExpiresAt: token.ExpiresAt, RevokedAt: now, Reason: reason})
+		}
+	}
+	if len(revoked) == 0 {
+		return nil
+	}
+
+	d.add(revoked)
+	return d.Repo.AddRevokedTokens(ctx, revoked)
+}
+
+// Sync reads the entries revoked since the last sync, including those made
+// by other instances, and forgets the ones whose tokens have expired
+func (d *TokenDenylist) Sync(ctx context.Context) error {
+	start := d.Now()
+	d.mu.RLock()
+	since := d.syncedAt
+	d.mu.RUnlock()
+	if !since.IsZero() {
+		since = since.Add(-denylistOverlap)
+	}
+
+	tokens, err := d.Repo.ListRevokedTokens(ctx, since, start)
+	if err != nil {
+		return err
+	}
+	d.add(tokens)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for jti, expiresAt := range d.entries {
+		if !start.Before(expiresAt) {
+			delete(d.entries, jti)
+		}
+	}
+	d.syncedAt = start
+	return nil
+}
+
+// Run syncs on every tick until ctx is cancelled. A failed sync keeps the
+// entries already known and is retried on the next tick.
+func (d *TokenDenylist) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		err := d.Sync(ctx)
+		metrics.ObserveJobRun(denylistJob, start, err)
+		if err != nil {
+			d.Logger.Error("Token denylist sync failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *TokenDenylist) add(tokens []models.RevokedToken) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, token := range tokens {
+		d.entries[token.JTI] = token.ExpiresAt
+	}
+}
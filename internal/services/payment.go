@@ -2,16 +2,29 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"os"
-
-	"github.com/joho/godotenv"
+	"time"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"github.com/joshuatakyi/shop/internal/metrics"
+	"github.com/joshuatakyi/shop/internal/models"
+	"github.com/joshuatakyi/shop/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// paystackClient sends every Paystack request. Its transport records a client
+// span and forwards the trace context.
+var paystackClient = &http.Client{
+	Transport: otelhttp.NewTransport(http.DefaultTransport, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return "Paystack " + r.Method
+	})),
+}
+
 // PaymentService handles payment operations using Paystack
 type PaymentService struct {
 	SecretKey string
@@ -26,6 +39,7 @@ type PaystackTransactionRequest struct {
 	Reference   string `json:"reference,omitempty"`
 	Currency    string `json:"currency,omitempty"`
 	CallbackURL string `json:"callback_url,omitempty"`
+	Metadata    any    `json:"metadata,omitempty"` // Returned with the transaction when it is verified
 }
 
 // PaystackResponse represents the standard response structure from Paystack
@@ -43,29 +57,57 @@ type PaystackTransactionResponse struct {
 }
 
 // NewPaymentService creates and initializes a new payment service
-func NewPaymentService() (*PaymentService, error) {
-	// Load environment variables from .env.local file
-	if err := godotenv.Load(".env.local"); err != nil {
-		log.Printf("Warning: Error loading .env.local file: %v", err)
-		// Continue execution, as environment variables might be set elsewhere
+func NewPaymentService(cfg config.PaystackConfig) (*PaymentService, error) {
+	if cfg.SecretKey == "" || cfg.PublicKey == "" {
+		return nil, fmt.Errorf("paystack keys are not configured")
 	}
 
-	secretKey := os.Getenv("PAYSTACK_SECRET_KEY")
-	publicKey := os.Getenv("PAYSTACK_PUBLIC_KEY")
-
-	if secretKey == "" || publicKey == "" {
-		return nil, fmt.Errorf("paystack keys are not set in the environment variables")
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.paystack.co"
 	}
 
 	return &PaymentService{
-		SecretKey: secretKey,
-		PublicKey: publicKey,
-		BaseURL:   "https://api.paystack.co",
+		SecretKey: cfg.SecretKey,
+		PublicKey: cfg.PublicKey,
+		BaseURL:   baseURL,
 	}, nil
 }
 
-// InitializeTransaction creates a new payment transaction
-func (p *PaymentService) InitializeTransaction(email string, amountInSmallestUnit int, reference, currency, callbackURL string) (*PaystackTransactionResponse, error) {
+// statusError turns a non-OK Paystack response into an error. Paystack
+// rejecting the request, e.g. for an unknown reference, is a failed payment
+// the shopper can be told about; its own failures stay internal.
+func statusError(operation string, status int, body []byte) error {
+	if status >= 400 && status < 500 {
+		var paystackResp PaystackResponse
+		if err := json.Unmarshal(body, &paystackResp); err == nil && paystackResp.Message != "" {
+			return models.PaymentFailed("Payment %s failed: %s", operation, paystackResp.Message)
+		}
+		return models.PaymentFailed("Payment %s failed", operation)
+	}
+	return fmt.Errorf("paystack %s API returned non-OK status: %d, body: %s", operation, status, string(body))
+}
+
+// startCall begins the span and timing of one Paystack operation. Call the
+// returned function with the operation's error once it ends.
+func startCall(ctx context.Context, operation string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "paystack."+operation)
+	return ctx, func(err error) {
+		metrics.ObservePaystackCall(operation, start, err)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// InitializeTransaction creates a new payment transaction. metadata may be nil.
+func (p *PaymentService) InitializeTransaction(ctx context.Context, email string, amountInSmallestUnit int, reference, currency, callbackURL string, metadata any) (_ *PaystackTransactionResponse, err error) {
+	ctx, finish := startCall(ctx, "initialize")
+	defer func() { finish(err) }()
+
 	// Create the request payload
 	payload := PaystackTransactionRequest{
 		Email:       email,
@@ -73,6 +115,7 @@ func (p *PaymentService) InitializeTransaction(email string, amountInSmallestUni
 		Reference:   reference,
 		Currency:    currency,
 		CallbackURL: callbackURL,
+		Metadata:    metadata,
 	}
 
 	// Convert payload to JSON
@@ -82,7 +125,7 @@ func (p *PaymentService) InitializeTransaction(email string, amountInSmallestUni
 	}
 
 	// Create the request
-	req, err := http.NewRequest("POST", p.BaseURL+"/transaction/initialize", bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.BaseURL+"/transaction/initialize", bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
@@ -92,8 +135,7 @@ func (p *PaymentService) InitializeTransaction(email string, amountInSmallestUni
 	req.Header.Set("Content-Type", "application/json")
 
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := paystackClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error sending request to Paystack: %v", err)
 	}
@@ -107,7 +149,7 @@ func (p *PaymentService) InitializeTransaction(email string, amountInSmallestUni
 
 	// Check if request was successful
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("paystack API returned non-OK status: %d, body: %s", resp.StatusCode, string(body))
+		return nil, statusError("initialization", resp.StatusCode, body)
 	}
 
 	// Parse the response
@@ -118,7 +160,7 @@ func (p *PaymentService) InitializeTransaction(email string, amountInSmallestUni
 
 	// Check if the transaction was successful
 	if !paystackResp.Status {
-		return nil, fmt.Errorf("paystack transaction initialization failed: %s", paystackResp.Message)
+		return nil, models.PaymentFailed("Payment could not be started: %s", paystackResp.Message)
 	}
 
 	// Extract the transaction data
@@ -137,9 +179,12 @@ func (p *PaymentService) InitializeTransaction(email string, amountInSmallestUni
 }
 
 // VerifyTransaction confirms if a transaction was successful
-func (p *PaymentService) VerifyTransaction(reference string) (*PaystackResponse, error) {
+func (p *PaymentService) VerifyTransaction(ctx context.Context, reference string) (_ *PaystackResponse, err error) {
+	ctx, finish := startCall(ctx, "verify")
+	defer func() { finish(err) }()
+
 	// Create the request
-	req, err := http.NewRequest("GET", p.BaseURL+"/transaction/verify/"+reference, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", p.BaseURL+"/transaction/verify/"+reference, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating verification request: %v", err)
 	}
@@ -149,8 +194,7 @@ func (p *PaymentService) VerifyTransaction(reference string) (*PaystackResponse,
 	req.Header.Set("Content-Type", "application/json")
 
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := paystackClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error sending verification request to Paystack: %v", err)
 	}
@@ -164,7 +208,7 @@ func (p *PaymentService) VerifyTransaction(reference string) (*PaystackResponse,
 
 	// Check if request was successful
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("paystack verification API returned non-OK status: %d, body: %s", resp.StatusCode, string(body))
+		return nil, statusError("verification", resp.StatusCode, body)
 	}
 
 	// Parse the response
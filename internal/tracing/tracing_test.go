@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/joshuatakyi/shop/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetupStdout(t *testing.T) {
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	var out bytes.Buffer
+	shutdown, err := Setup(context.Background(), config.TracingConfig{Exporter: "stdout", ServiceName: "shop-test", SampleRatio: 1}, config.EnvTest, &out)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	_, span := Tracer().Start(context.Background(), "checkout")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	for _, want := range []string{`"Name":"checkout"`, `"Value":"shop-test"`, `"Value":"test"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("exported spans do not contain %s: %s", want, out.String())
+		}
+	}
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), config.TracingConfig{Exporter: "zipkin"}, config.EnvTest, nil); err == nil {
+		t.Fatal("Setup accepted an unknown exporter")
+	}
+}